@@ -0,0 +1,134 @@
+package main
+
+import (
+	"chess-archive/config"
+	chessArchive "chess-archive/internal"
+	"chess-archive/internal/sqlite"
+	"chess-archive/pkg/google/drive"
+	"context"
+
+	"cloud.google.com/go/firestore"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// app holds the clients and the accounts shared by the commands
+type app struct {
+	cfg             *config.Config
+	logger          logrus.FieldLogger
+	accounts        []*chessArchive.Account
+	dataStoreClient *firestore.Client
+	gdClient        drive.GDriveClient
+	sqliteStore     *sqlite.Store
+	checkpoints     chessArchive.CheckpointStore //shared by the accounts with the file store, nil with Firestore
+	deadLetters     chessArchive.DeadLetterStore //shared by the accounts with the file store, nil with Firestore
+}
+
+func newApp(ctx context.Context, cfg *config.Config, logger logrus.FieldLogger) (*app, error) {
+	var (
+		a   = &app{cfg: cfg, logger: logger}
+		err error
+	)
+
+	//Google services are optional, the local processor with the file checkpoint store runs without credentials
+	if cfg.FirestoreEnabled() {
+		a.dataStoreClient, err = firestore.NewClient(ctx, cfg.Google.ProjectID)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if cfg.ProcessorEnabled(config.ProcessorSQLite) {
+		a.sqliteStore, err = sqlite.NewStore(ctx, logger, cfg.SQLite.Path)
+
+		if err != nil {
+			a.Close()
+
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if cfg.ProcessorEnabled(config.ProcessorDrive) {
+		a.gdClient, err = drive.NewHTTPtClient(ctx, chessArchive.NewRetryPolicy(cfg))
+
+		if err != nil {
+			a.Close()
+
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	//every account uses the same file, a store per account would overwrite the entries of the others
+	if cfg.Checkpoint.Store == config.CheckpointFile {
+		a.checkpoints = chessArchive.NewFileCheckpointStore(cfg.Checkpoint.File)
+	}
+
+	if cfg.DeadLetter.Store == config.DeadLetterFile {
+		a.deadLetters = chessArchive.NewFileDeadLetterStore(cfg.DeadLetter.File)
+	}
+
+	a.accounts, err = a.newAccounts(cfg)
+	if err != nil {
+		a.Close()
+
+		return nil, errors.WithStack(err)
+	}
+
+	return a, nil
+}
+
+// newAccounts wires the accounts of the users of the config with the clients of the app,
+// the config may only narrow down the processors and the users the app was created for
+func (a *app) newAccounts(cfg *config.Config) ([]*chessArchive.Account, error) {
+	for _, name := range cfg.Archiver.Processors {
+		if !a.cfg.ProcessorEnabled(name) {
+			return nil, &chessArchive.CommandError{Reason: "the " + name + " processor is not enabled"}
+		}
+	}
+
+	accounts := make([]*chessArchive.Account, 0, len(cfg.Users))
+
+	for _, user := range cfg.Users {
+		acc, err := a.newAccount(cfg, user)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		accounts = append(accounts, acc)
+	}
+
+	return accounts, nil
+}
+
+func (a *app) Close() {
+	if a.sqliteStore != nil {
+		_ = a.sqliteStore.Close()
+	}
+
+	if a.dataStoreClient != nil {
+		_ = a.dataStoreClient.Close()
+	}
+}
+
+// newAccount wires the providers, stores and processors of the user with the clients of the app
+func (a *app) newAccount(cfg *config.Config, user config.User) (*chessArchive.Account, error) {
+	clients := chessArchive.Clients{
+		DataStore:   a.dataStoreClient,
+		GDrive:      a.gdClient,
+		Checkpoints: a.checkpoints,
+		DeadLetters: a.deadLetters,
+	}
+
+	//a nil store would make a non-nil interface
+	if a.sqliteStore != nil {
+		clients.SQLite = a.sqliteStore
+	}
+
+	acc, err := chessArchive.NewAccount(cfg, user, a.logger, clients)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return acc, nil
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"chess-archive/config"
+	chessArchive "chess-archive/internal"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	formatPGN  = "pgn"
+	formatJSON = "json"
+)
+
+// runSync archives the games played since the last checkpoint
+func runSync(ctx context.Context, logger logrus.FieldLogger, args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	cf := newConfigFlags(fs)
+	cf.registerRun(fs)
+	_ = fs.Parse(args)
+
+	a, err := setup(ctx, fs, cf, logger)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer a.Close()
+
+	spec, err := chessArchive.NewRunSpec(a.cfg)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = chessArchive.NewArchiver(logger, a.cfg, a.accounts).Run(ctx, spec)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	logger.Infoln("success")
+
+	return nil
+}
+
+// runBackfill archives the games played in the time range again, the checkpoints are left untouched
+func runBackfill(ctx context.Context, logger logrus.FieldLogger, args []string) error {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	cf := newConfigFlags(fs)
+	cf.registerRun(fs)
+	since := fs.String("since", "", "first day or RFC 3339 time of the range, inclusive (required)")
+	until := fs.String("until", "", "day or RFC 3339 time ending the range, exclusive, no end when empty")
+	_ = fs.Parse(args)
+
+	if *since == "" {
+		return invalidArgs("backfill: -since is required")
+	}
+
+	a, err := setup(ctx, fs, cf, logger)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer a.Close()
+
+	spec, err := chessArchive.NewRunSpec(a.cfg)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	spec.Since, err = parseTime(*since)
+	if err != nil {
+		return invalidArgs("backfill: invalid -since: %s", err)
+	}
+
+	if *until != "" {
+		spec.Until, err = parseTime(*until)
+		if err != nil {
+			return invalidArgs("backfill: invalid -until: %s", err)
+		}
+
+		if !spec.Until.After(spec.Since) {
+			return invalidArgs("backfill: -until should be after -since")
+		}
+	}
+
+	_, err = chessArchive.NewArchiver(logger, a.cfg, a.accounts).Run(ctx, spec)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	logger.Infoln("success")
+
+	return nil
+}
+
+// runVerify compares the games kept by the stores of every user
+func runVerify(ctx context.Context, logger logrus.FieldLogger, args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	cf := newConfigFlags(fs)
+	withDrive := fs.Bool("drive", false, "join the Firestore games with the files of the Drive archive folder")
+	repair := fs.Bool("repair", false, "archive the games missing from Firestore or Drive again, with -drive")
+	_ = fs.Parse(args)
+
+	if *repair && !*withDrive {
+		return invalidArgs("verify: -repair is only used with -drive")
+	}
+
+	a, err := setup(ctx, fs, cf, logger)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer a.Close()
+
+	if *withDrive {
+		return verifyDrive(ctx, a, *repair)
+	}
+
+	var (
+		reports      []*chessArchive.VerifyReport
+		inconsistent int
+	)
+
+	for _, acc := range a.accounts {
+		for _, provider := range acc.Providers {
+			report, err := chessArchive.VerifyStores(ctx, provider.Source(), provider.User(), acc.Processors)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			if len(report.Games) < 2 {
+				logger.Warnf("%s games of %s are kept by %d store(s), nothing to compare", provider.Source(), acc.Name, len(report.Games))
+			}
+
+			if !report.Consistent() {
+				inconsistent++
+			}
+
+			reports = append(reports, report)
+		}
+	}
+
+	err = printJSON(os.Stdout, reports)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if inconsistent > 0 {
+		return errors.Errorf("verify: %d inconsistent archive(s)", inconsistent)
+	}
+
+	return nil
+}
+
+// verifyDrive compares the Firestore games with the Drive archive of every user
+func verifyDrive(ctx context.Context, a *app, repair bool) error {
+	var (
+		reports      []*chessArchive.DriveReport
+		inconsistent int
+	)
+
+	for _, acc := range a.accounts {
+		report, err := chessArchive.VerifyDrive(ctx, acc, repair)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if !report.Consistent() {
+			inconsistent++
+		}
+
+		reports = append(reports, report)
+	}
+
+	err := printJSON(os.Stdout, reports)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if inconsistent > 0 {
+		return errors.Errorf("verify: %d inconsistent Drive archive(s)", inconsistent)
+	}
+
+	return nil
+}
+
+// runExport dumps the games of every user from one of the stores
+func runExport(ctx context.Context, logger logrus.FieldLogger, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	cf := newConfigFlags(fs)
+	from := fs.String("from", "", "processor to read the games from, the first one able to list its games when empty")
+	format := fs.String("format", formatPGN, "pgn or json, the JSON export has a game per line")
+	out := fs.String("out", "", "file to write to, stdout when empty")
+	_ = fs.Parse(args)
+
+	if *format != formatPGN && *format != formatJSON {
+		return invalidArgs("export: unknown format %q", *format)
+	}
+
+	a, err := setup(ctx, fs, cf, logger)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer a.Close()
+
+	var w io.Writer = os.Stdout
+
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer f.Close()
+
+		w = f
+	}
+
+	enc := json.NewEncoder(w)
+
+	var exported int
+
+	for _, acc := range a.accounts {
+		lister, err := findLister(acc, *from)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		for _, provider := range acc.Providers {
+			it, err := lister.ListGames(ctx, provider.Source(), provider.User())
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			err = chessArchive.EachGame(it, func(g *chessArchive.Game) error {
+				exported++
+
+				if *format == formatJSON {
+					return enc.Encode(g)
+				}
+
+				_, err := fmt.Fprintf(w, "%s\n\n", strings.TrimSpace(g.PGN))
+
+				return err
+			})
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+
+	logger.Infof("%d games exported", exported)
+
+	return nil
+}
+
+// runRestore copies the games of every user from one of the stores into the other enabled processors
+func runRestore(ctx context.Context, logger logrus.FieldLogger, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	cf := newConfigFlags(fs)
+	from := fs.String("from", "", "processor to read the games from (required)")
+	_ = fs.Parse(args)
+
+	if *from == "" {
+		return invalidArgs("restore: -from is required")
+	}
+
+	cfg, err := cf.load(fs)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	//the games are restored one by one, every game would wait for the flush interval of its batch
+	cfg.Firestore.BatchSize = 0
+
+	a, err := newApp(ctx, cfg, logger)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer a.Close()
+
+	var restored int
+
+	for _, acc := range a.accounts {
+		lister, err := findLister(acc, *from)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		for _, provider := range acc.Providers {
+			it, err := lister.ListGames(ctx, provider.Source(), provider.User())
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			err = chessArchive.EachGame(it, func(g *chessArchive.Game) error {
+				restored++
+
+				return restore(ctx, logger, a.cfg.Archiver.DryRun, acc, *from, g)
+			})
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+
+	logger.Infof("%d games restored from %s", restored, *from)
+
+	return nil
+}
+
+// restore hands the game to every processor of the account but the one it was read from, a dry run only plans it
+func restore(ctx context.Context, logger logrus.FieldLogger, dryRun bool, acc *chessArchive.Account, from string, g *chessArchive.Game) error {
+	for _, p := range acc.Processors {
+		if p.Name() == from {
+			continue
+		}
+
+		if !dryRun {
+			err := p.Process(ctx, g)
+			if err != nil {
+				return errors.Wrapf(err, "restoring game ID: %s to %s", g.ID, p.Name())
+			}
+
+			continue
+		}
+
+		planner, ok := p.(chessArchive.Planner)
+		if !ok {
+			logger.Infof("dry run: game ID: %s would be processed by %s", g.ID, p.Name())
+
+			continue
+		}
+
+		action, err := planner.Plan(ctx, g)
+		if err != nil {
+			return errors.Wrapf(err, "planning game ID: %s for %s", g.ID, p.Name())
+		}
+
+		logger.Infof("dry run: %s would %s game ID: %s", p.Name(), action, g.ID)
+	}
+
+	return nil
+}
+
+// runStats summarizes the archived games of every user
+func runStats(ctx context.Context, logger logrus.FieldLogger, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	cf := newConfigFlags(fs)
+	from := fs.String("from", "", "processor to read the games from, the first one able to list its games when empty")
+	_ = fs.Parse(args)
+
+	a, err := setup(ctx, fs, cf, logger)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer a.Close()
+
+	var list []*chessArchive.GameStats
+
+	for _, acc := range a.accounts {
+		lister, err := findLister(acc, *from)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		for _, provider := range acc.Providers {
+			stats, err := chessArchive.CollectStats(ctx, provider.Source(), provider.User(), lister)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			list = append(list, stats)
+		}
+	}
+
+	return errors.WithStack(printJSON(os.Stdout, list))
+}
+
+// runMigrate upgrades the games stored in Firestore to the schema version of the code
+func runMigrate(ctx context.Context, logger logrus.FieldLogger, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	cf := newConfigFlags(fs)
+	to := fs.Int("to", chessArchive.SchemaVersion, "schema version to migrate the games to")
+	restart := fs.Bool("restart", false, "scan every game again instead of resuming the stopped migration")
+	list := fs.Bool("list", false, "print the migrations without running them")
+	_ = fs.Parse(args)
+
+	if *list {
+		for _, m := range chessArchive.Migrations() {
+			fmt.Printf("%d\t%s\n", m.Version, m.Description)
+		}
+
+		return nil
+	}
+
+	a, err := setup(ctx, fs, cf, logger)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer a.Close()
+
+	if a.dataStoreClient == nil {
+		return errors.New("migrate: the games are stored in Firestore, the firestore processor should be enabled")
+	}
+
+	var (
+		reports    []*chessArchive.MigrationReport
+		namespaces []string
+		users      = map[string][]config.User{}
+	)
+
+	//users may share a namespace, every namespace is migrated once with its users as the owners of the legacy games
+	for _, user := range a.cfg.Users {
+		if _, ok := users[user.Namespace]; !ok {
+			namespaces = append(namespaces, user.Namespace)
+		}
+
+		users[user.Namespace] = append(users[user.Namespace], user)
+	}
+
+	for _, namespace := range namespaces {
+		migrator := chessArchive.NewMigrator(
+			logger.WithField("namespace", namespace),
+			a.dataStoreClient,
+			namespace,
+			chessArchive.NewOwners(users[namespace]),
+			a.cfg.Archiver.DryRun,
+		)
+
+		report, err := migrator.Migrate(ctx, *to, *restart)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		reports = append(reports, report)
+	}
+
+	return errors.WithStack(printJSON(os.Stdout, reports))
+}
+
+// setup loads the config with the flags applied and wires the accounts
+func setup(ctx context.Context, fs *flag.FlagSet, cf *configFlags, logger logrus.FieldLogger) (*app, error) {
+	cfg, err := cf.load(fs)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	a, err := newApp(ctx, cfg, logger)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return a, nil
+}
+
+// findLister returns the named processor of the account, the first one able to list its games when the name is empty
+func findLister(acc *chessArchive.Account, name string) (chessArchive.GameLister, error) {
+	for _, p := range acc.Processors {
+		lister, ok := p.(chessArchive.GameLister)
+		if !ok || (name != "" && p.Name() != name) {
+			continue
+		}
+
+		return lister, nil
+	}
+
+	if name == "" {
+		return nil, errors.New("none of the processors can list the archived games")
+	}
+
+	return nil, invalidArgs("the %s processor is not enabled or can not list the archived games", name)
+}
+
+// parseTime accepts a day or an RFC 3339 time, days are in the configured time zone
+func parseTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+
+	loc, err := time.LoadLocation(config.TimeZone)
+	if err != nil {
+		return time.Time{}, errors.WithStack(err)
+	}
+
+	t, err := time.ParseInLocation("2006-01-02", v, loc)
+
+	return t, errors.WithStack(err)
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return errors.WithStack(enc.Encode(v))
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"chess-archive/config"
+	"flag"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// configFlags are the settings every command may override, only the flags set on the command line replace the ENV values
+type configFlags struct {
+	users       string
+	processors  string
+	maxInFlight int
+	timeZone    string
+	localDir    string
+	sqlitePath  string
+	dryRun      bool
+	maxGames    int
+	perfTypes   string
+	rated       string
+	opponent    string
+}
+
+func newConfigFlags(fs *flag.FlagSet) *configFlags {
+	f := &configFlags{}
+
+	fs.StringVar(&f.users, "users", "", "comma separated names of the users to run for, all users when empty (USERS)")
+	fs.StringVar(&f.processors, "processors", "", "comma separated processors (PROCESSORS)")
+	fs.IntVar(&f.maxInFlight, "max-in-flight", 0, "workers processing the games of a user concurrently (ARCHIVER_MAX_IN_FLIGHT)")
+	fs.StringVar(&f.timeZone, "timezone", "", "time zone of the archived game names and of the dates given to the flags (TIMEZONE)")
+	fs.StringVar(&f.localDir, "local-dir", "", "directory of the local processor (LOCAL_ARCHIVE_DIR)")
+	fs.StringVar(&f.sqlitePath, "sqlite-path", "", "database file of the sqlite processor (SQLITE_PATH)")
+	fs.BoolVar(&f.dryRun, "dry-run", false, "report what the processors would create, update or skip without writing anything (DRY_RUN)")
+
+	return f
+}
+
+// registerRun adds the flags narrowing down the archived games
+func (f *configFlags) registerRun(fs *flag.FlagSet) {
+	fs.IntVar(&f.maxGames, "max-games", 0, "games archived per source at most, no limit when zero (RUN_MAX_GAMES)")
+	fs.StringVar(&f.perfTypes, "perf-types", "", "comma separated speeds, e.g. blitz,rapid (RUN_PERF_TYPES)")
+	fs.StringVar(&f.rated, "rated", "", "true for rated games only, false for casual games only (RUN_RATED)")
+	fs.StringVar(&f.opponent, "opponent", "", "archive the games against this opponent only (RUN_OPPONENT)")
+}
+
+// load reads the config from the ENV and applies the flags set on the command line
+func (f *configFlags) load(fs *flag.FlagSet) (*config.Config, error) {
+	set := map[string]bool{}
+
+	fs.Visit(func(fl *flag.Flag) {
+		set[fl.Name] = true
+	})
+
+	cfg, err := config.NewConfig(func(c *config.Config) error {
+		if set["processors"] {
+			c.Archiver.Processors = splitList(f.processors)
+		}
+
+		if set["max-in-flight"] {
+			c.Archiver.MaxInFlight = f.maxInFlight
+		}
+
+		if set["timezone"] {
+			c.TimeZone = f.timeZone
+		}
+
+		if set["local-dir"] {
+			c.Local.Dir = f.localDir
+		}
+
+		if set["sqlite-path"] {
+			c.SQLite.Path = f.sqlitePath
+		}
+
+		if set["dry-run"] {
+			c.Archiver.DryRun = f.dryRun
+		}
+
+		if set["max-games"] {
+			c.Run.MaxGames = f.maxGames
+		}
+
+		if set["perf-types"] {
+			c.Run.PerfTypes = splitList(f.perfTypes)
+		}
+
+		if set["rated"] {
+			c.Run.Rated = f.rated
+		}
+
+		if set["opponent"] {
+			c.Run.Opponent = f.opponent
+		}
+
+		if set["users"] {
+			return errors.WithStack(c.SelectUsers(splitList(f.users)))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	config.TimeZone = cfg.TimeZone
+
+	return cfg, nil
+}
+
+func splitList(v string) []string {
+	var list []string
+
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
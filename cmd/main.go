@@ -1,71 +1,94 @@
 package main
 
 import (
-	"chess-archive/config"
 	chessArchive "chess-archive/internal"
-	"chess-archive/pkg/google/drive"
 	"chess-archive/pkg/google/logging"
 	"context"
-	"time"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
 
-	"github.com/VMAnalytic/lichess-api-client/lichess"
-
-	"cloud.google.com/go/firestore"
 	_ "github.com/joho/godotenv/autoload"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
-func main() {
-	logger := logging.NewLogger()
-	cfg, err := config.NewConfig()
+type command struct {
+	usage string
+	run   func(ctx context.Context, logger logrus.FieldLogger, args []string) error
+}
 
-	if err != nil {
-		logger.Fatalln(err)
-	}
+var commands = map[string]command{
+	"sync":     {usage: "archive the games played since the last checkpoint", run: runSync},
+	"backfill": {usage: "archive the games played between -since and -until again", run: runBackfill},
+	"verify":   {usage: "compare the games kept by the stores", run: runVerify},
+	"export":   {usage: "dump the archived games as PGN or JSON", run: runExport},
+	"stats":    {usage: "summarize the archived games", run: runStats},
+	"restore":  {usage: "copy the games archived by -from into the other processors", run: runRestore},
+	"migrate":  {usage: "upgrade the games stored in Firestore to the current schema version", run: runMigrate},
+	"serve":    {usage: "sync on a cron schedule and serve the health, metrics and sync endpoints", run: runServe},
+}
 
-	config.TimeZone = cfg.TimeZone
+func main() {
+	os.Exit(run(context.Background(), logging.NewLogger(), os.Args[1:]))
+}
 
-	ctx := context.Background()
+// run runs the command named by the first argument and returns the exit code of the process
+func run(ctx context.Context, logger logrus.FieldLogger, args []string) int {
+	//without a command the incremental sync runs, as it did before the commands existed
+	name := "sync"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
 
-	dataStoreClient, err := firestore.NewClient(ctx, cfg.Google.ProjectID)
+	cmd, ok := commands[name]
+	if !ok {
+		usage()
 
-	if err != nil {
-		logger.Fatalln(err)
+		return 2
 	}
 
-	lichessClient := lichess.NewClient(cfg.Lichess.APIKey, nil)
-	err = lichessClient.SetLimits(1*time.Second, uint(cfg.Lichess.LimitPerSec))
-
+	err := cmd.run(ctx, logger, args)
 	if err != nil {
-		logger.Fatalln(err)
+		logger.Errorln(err)
 	}
 
-	gameStorage := chessArchive.NewDataStoreGameStorage(logger, dataStoreClient)
-
-	transformer := chessArchive.NewGameTransformer(cfg.Lichess.UserID)
-	gdClient, err := drive.NewHTTPtClient(ctx)
+	return exitCode(err)
+}
 
-	if err != nil {
-		logger.Fatalln(err)
+// exitCode is 2 for an invalid command as for the invalid flags, 1 for the other failures
+func exitCode(err error) int {
+	var cmdErr *chessArchive.CommandError
+
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &cmdErr):
+		return 2
+	default:
+		return 1
 	}
+}
 
-	gcloudProcessor := chessArchive.NewDriveStoreProcessor(cfg.Google.ArchiveFolderID, gdClient, transformer, logger)
+// invalidArgs fails the command on the arguments given to it
+func invalidArgs(format string, args ...interface{}) error {
+	return &chessArchive.CommandError{Reason: fmt.Sprintf(format, args...)}
+}
 
-	dataProcessor := chessArchive.NewDataStoreProcessor(logger, transformer, dataStoreClient)
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
 
-	arch := chessArchive.NewArchiver(
-		logger,
-		cfg,
-		transformer,
-		lichessClient,
-		gameStorage,
-		[]chessArchive.Processor{gcloudProcessor, dataProcessor},
-	)
+	sort.Strings(names)
 
-	err = arch.Run(ctx)
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
 
-	if err != nil {
-		logger.Fatalln(err)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
 	}
 
-	logger.Infoln("success")
+	fmt.Fprintf(os.Stderr, "\nRun %s <command> -h for the flags of the command\n", os.Args[0])
 }
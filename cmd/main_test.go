@@ -0,0 +1,124 @@
+package main
+
+import (
+	chessArchive "chess-archive/internal"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func setenv(t *testing.T, env map[string]string) {
+	t.Helper()
+
+	for key, value := range env {
+		previous, ok := os.LookupEnv(key)
+
+		t.Cleanup(func(key, previous string, ok bool) func() {
+			return func() {
+				if ok {
+					_ = os.Setenv(key, previous)
+				} else {
+					_ = os.Unsetenv(key)
+				}
+			}
+		}(key, previous, ok))
+
+		_ = os.Setenv(key, value)
+	}
+}
+
+// setLocalEnv configures a single chess.com user archived by the local processor, nothing needs credentials
+func setLocalEnv(t *testing.T) {
+	dir := t.TempDir()
+
+	setenv(t, map[string]string{
+		"ENVIRONMENT":       "local",
+		"USERS":             "",
+		"LICHESS_USER_ID":   "",
+		"CHESSCOM_USERNAME": "alice",
+		"PROCESSORS":        "local",
+		"LOCAL_ARCHIVE_DIR": filepath.Join(dir, "archive"),
+		"CHECKPOINT_STORE":  "file",
+		"CHECKPOINT_FILE":   filepath.Join(dir, "checkpoints.json"),
+		"DEAD_LETTER_STORE": "file",
+		"DEAD_LETTER_FILE":  filepath.Join(dir, "dead-letters.jsonl"),
+	})
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, 0},
+		{"invalid command", errors.WithStack(&chessArchive.CommandError{Reason: "x"}), 2},
+		{"invalid arguments", errors.WithStack(invalidArgs("backfill: -since is required")), 2},
+		{"failed run", errors.New("boom"), 1},
+	}
+
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("%s: exitCode() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRunRejectsTheInvalidArguments(t *testing.T) {
+	setLocalEnv(t)
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"unknown command", []string{"unknown"}},
+		{"backfill without -since", []string{"backfill"}},
+		{"backfill with an invalid -since", []string{"backfill", "-since", "yesterday"}},
+		{"backfill with an invalid -until", []string{"backfill", "-since", "2021-01-02", "-until", "tomorrow"}},
+		{"backfill ending before -since", []string{"backfill", "-since", "2021-01-02", "-until", "2021-01-01"}},
+		{"verify repairing without -drive", []string{"verify", "-repair"}},
+		{"export to an unknown format", []string{"export", "-format", "xml"}},
+		{"export from a disabled processor", []string{"export", "-from", "drive"}},
+		{"restore without -from", []string{"restore"}},
+	}
+
+	for _, tt := range tests {
+		logger, _ := test.NewNullLogger()
+
+		if got := run(context.Background(), logger, tt.args); got != 2 {
+			t.Errorf("%s: run(%q) = %d, want 2", tt.name, tt.args, got)
+		}
+	}
+}
+
+func TestRunFailsOnAnInvalidConfig(t *testing.T) {
+	setLocalEnv(t)
+	setenv(t, map[string]string{"ARCHIVER_MAX_IN_FLIGHT": "0"})
+
+	logger, _ := test.NewNullLogger()
+
+	if got := run(context.Background(), logger, []string{"sync"}); got != 1 {
+		t.Errorf("run(sync) = %d, want 1", got)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	got, err := parseTime("2021-03-04")
+	if err != nil || got.Format("2006-01-02T15:04:05Z07:00") != "2021-03-04T00:00:00Z" {
+		t.Errorf("parseTime(day) = %v, %v, want the start of the day", got, err)
+	}
+
+	got, err = parseTime("2021-03-04T10:20:30+02:00")
+	if err != nil || got.Unix() != 1614846030 {
+		t.Errorf("parseTime(RFC 3339) = %v, %v", got, err)
+	}
+
+	_, err = parseTime("04/03/2021")
+	if err == nil {
+		t.Errorf("parseTime(04/03/2021) error = nil, want an error")
+	}
+}
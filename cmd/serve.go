@@ -0,0 +1,232 @@
+package main
+
+import (
+	chessArchive "chess-archive/internal"
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// commands are small, larger bodies are rejected
+const maxPayload = 1 << 20
+
+// server runs the archiver on the schedule and on the sync requests, one run at a time
+type server struct {
+	app     *app
+	logger  logrus.FieldLogger
+	metrics *chessArchive.Metrics //shared by the runs, so the throughput covers the whole uptime
+	busy    chan struct{}
+
+	mu      sync.Mutex
+	lastRun *chessArchive.Report
+}
+
+// runServe archives the games on a cron schedule and serves the health, metrics and sync endpoints until it is stopped
+func runServe(ctx context.Context, logger logrus.FieldLogger, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	cf := newConfigFlags(fs)
+	cf.registerRun(fs)
+	addr := fs.String("addr", "", "address of the HTTP server (SERVE_ADDR)")
+	schedule := fs.String("schedule", "", "cron spec of the scheduled syncs, e.g. @hourly or 0 */6 * * * (SERVE_SCHEDULE)")
+	_ = fs.Parse(args)
+
+	a, err := setup(ctx, fs, cf, logger)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer a.Close()
+
+	if *addr != "" {
+		a.cfg.Serve.Addr = *addr
+	}
+
+	if *schedule != "" {
+		a.cfg.Serve.Schedule = *schedule
+	}
+
+	s := &server{
+		app:     a,
+		logger:  logger,
+		metrics: chessArchive.NewMetrics(),
+		busy:    make(chan struct{}, 1),
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	c := cron.New()
+
+	_, err = c.AddFunc(a.cfg.Serve.Schedule, func() {
+		report, err := s.run(ctx, nil)
+		if err != nil {
+			logger.WithError(err).Warnln("scheduled sync skipped")
+
+			return
+		}
+
+		if report.Error != "" {
+			logger.Errorf("scheduled sync failed: %s", report.Error)
+		}
+	})
+	if err != nil {
+		return errors.Wrapf(err, "invalid schedule %q", a.cfg.Serve.Schedule)
+	}
+
+	c.Start()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.health)
+	mux.HandleFunc("/metrics", s.stats)
+	mux.HandleFunc("/sync", s.sync)
+
+	srv := &http.Server{Addr: a.cfg.Serve.Addr, Handler: mux}
+	errs := make(chan error, 1)
+
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	logger.Infof("serving on %s, syncing on schedule %s", a.cfg.Serve.Addr, a.cfg.Serve.Schedule)
+
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		logger.Infoln("shutting down...")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		err = srv.Shutdown(shutdownCtx)
+	}
+
+	//waits for the running scheduled sync, it is cancelled together with the context
+	<-c.Stop().Done()
+
+	if err == http.ErrServerClosed {
+		return nil
+	}
+
+	return errors.WithStack(err)
+}
+
+var errBusy = errors.New("a sync is already running")
+
+// run archives the games selected by the JSON command and reports the outcome,
+// it fails with errBusy without running when another sync is running
+func (s *server) run(ctx context.Context, payload []byte) (*chessArchive.Report, error) {
+	select {
+	case s.busy <- struct{}{}:
+	default:
+		return nil, errBusy
+	}
+
+	defer func() { <-s.busy }()
+
+	started := time.Now()
+	summary, err := s.archive(ctx, payload)
+	report := chessArchive.NewReport(started, summary, err)
+
+	s.mu.Lock()
+	s.lastRun = report
+	s.mu.Unlock()
+
+	return report, nil
+}
+
+// archive runs the JSON command, the empty command runs the sync configured for the server
+func (s *server) archive(ctx context.Context, payload []byte) (*chessArchive.Summary, error) {
+	cmd, err := chessArchive.ParseCommand(payload)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	cfg, err := cmd.Apply(s.app.cfg)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	spec, err := cmd.Spec(cfg)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	accounts, err := s.app.newAccounts(cfg)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	summary, err := chessArchive.NewArchiver(s.logger, cfg, accounts).WithMetrics(s.metrics).Run(ctx, spec)
+
+	return summary, errors.WithStack(err)
+}
+
+func (s *server) health(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *server) stats(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	lastRun := s.lastRun
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, struct {
+		Running    bool                          `json:"running"`
+		Processors []chessArchive.ProcessorStats `json:"processors"`
+		LastRun    *chessArchive.Report          `json:"last_run,omitempty"`
+	}{
+		Running:    len(s.busy) > 0,
+		Processors: s.metrics.Snapshot(),
+		LastRun:    lastRun,
+	})
+}
+
+// sync runs the archiver for the JSON command of the body and responds with the run report
+func (s *server) sync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	started := time.Now()
+
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPayload))
+	if err != nil {
+		err = &chessArchive.CommandError{Reason: err.Error()}
+		writeJSON(w, http.StatusBadRequest, chessArchive.NewReport(started, nil, err))
+
+		return
+	}
+
+	report, err := s.run(r.Context(), payload)
+	if err != nil {
+		writeJSON(w, http.StatusConflict, chessArchive.NewReport(started, nil, err))
+
+		return
+	}
+
+	if report.Error != "" {
+		s.logger.Errorf("requested sync failed: %s", report.Error)
+	}
+
+	writeJSON(w, report.Status(), report)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(v)
+}
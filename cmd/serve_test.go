@@ -0,0 +1,72 @@
+package main
+
+import (
+	"chess-archive/config"
+	chessArchive "chess-archive/internal"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// newTestServer serves a user without any source, so a sync runs without reaching the chess sites
+func newTestServer(t *testing.T) *server {
+	t.Helper()
+	setLocalEnv(t)
+
+	cfg, err := config.NewConfig()
+	if err != nil {
+		t.Fatalf("NewConfig() error = %v", err)
+	}
+
+	cfg.Users[0].ChessComUsername = ""
+	dir := t.TempDir()
+	logger, _ := test.NewNullLogger()
+
+	return &server{
+		app: &app{
+			cfg:         cfg,
+			logger:      logger,
+			checkpoints: chessArchive.NewFileCheckpointStore(filepath.Join(dir, "checkpoints.json")),
+			deadLetters: chessArchive.NewFileDeadLetterStore(filepath.Join(dir, "dead-letters.jsonl")),
+		},
+		logger:  logger,
+		metrics: chessArchive.NewMetrics(),
+		busy:    make(chan struct{}, 1),
+	}
+}
+
+func TestServerSyncStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		payload string
+		busy    bool
+		want    int
+	}{
+		{"scheduled sync", http.MethodPost, "", false, http.StatusOK},
+		{"not a POST", http.MethodGet, "", false, http.StatusMethodNotAllowed},
+		{"unknown field", http.MethodPost, `{"unknown":1}`, false, http.StatusBadRequest},
+		{"unknown user", http.MethodPost, `{"user":"bob"}`, false, http.StatusBadRequest},
+		{"disabled processor", http.MethodPost, `{"processors":["drive"]}`, false, http.StatusBadRequest},
+		{"too large", http.MethodPost, strings.Repeat(" ", maxPayload+1), false, http.StatusBadRequest},
+		{"running sync", http.MethodPost, "", true, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		s := newTestServer(t)
+		if tt.busy {
+			s.busy <- struct{}{}
+		}
+
+		w := httptest.NewRecorder()
+		s.sync(w, httptest.NewRequest(tt.method, "/sync", strings.NewReader(tt.payload)))
+
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d, body %s", tt.name, w.Code, tt.want, w.Body)
+		}
+	}
+}
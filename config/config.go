@@ -1,8 +1,12 @@
 package config
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joeshaw/envdecode"
 	"github.com/pkg/errors"
@@ -12,6 +16,23 @@ type ENV string
 
 const gCloud ENV = "gcloud"
 
+const (
+	CheckpointFirestore = "firestore"
+	CheckpointFile      = "file"
+)
+
+const (
+	DeadLetterFirestore = "firestore"
+	DeadLetterFile      = "file"
+)
+
+const (
+	ProcessorDrive     = "drive"
+	ProcessorFirestore = "firestore"
+	ProcessorLocal     = "local"
+	ProcessorSQLite    = "sqlite"
+)
+
 var TimeZone = "UTC"
 
 type Config struct {
@@ -19,10 +40,64 @@ type Config struct {
 	Timeout  int    `env:"TIMEOUT,default=60"` //in seconds
 	TimeZone string `env:"TIMEZONE,default=UTC"`
 
+	Archiver struct {
+		MaxInFlight int      `env:"ARCHIVER_MAX_IN_FLIGHT,default=20"` //workers processing the games of a user concurrently
+		Processors  []string `env:"PROCESSORS,default=drive;firestore"`
+		Concurrency []string `env:"PROCESSOR_CONCURRENCY,default=drive:4"` //name:limit, ARCHIVER_MAX_IN_FLIGHT when missing
+		DryRun      bool     `env:"DRY_RUN,default=false"`                 //report what the processors would do, nothing is written
+	}
+
+	Run struct {
+		MaxGames  int      `env:"RUN_MAX_GAMES,default=0"` //per source, no limit when zero
+		PerfTypes []string `env:"RUN_PERF_TYPES"`          //e.g. blitz;rapid, all when empty
+		Rated     string   `env:"RUN_RATED"`               //true for rated, false for casual, both when empty
+		Opponent  string   `env:"RUN_OPPONENT"`
+	}
+
+	Serve struct {
+		Addr     string `env:"SERVE_ADDR,default=:8080"`
+		Schedule string `env:"SERVE_SCHEDULE,default=@hourly"` //cron spec of the scheduled syncs
+	}
+
+	Local struct {
+		Dir    string `env:"LOCAL_ARCHIVE_DIR"`
+		Layout string `env:"LOCAL_ARCHIVE_LAYOUT"`
+		Mode   string `env:"LOCAL_ARCHIVE_MODE,default=game"`   //game or monthly
+		Naming string `env:"LOCAL_ARCHIVE_NAMING,default=name"` //name or id
+	}
+
+	SQLite struct {
+		Path string `env:"SQLITE_PATH,default=chess-archive.db"`
+	}
+
+	Firestore struct {
+		BatchSize  int           `env:"FIRESTORE_BATCH_SIZE,default=0"`   //games written together, at most 500 and the games reaching firestore at once, every game on its own below 2
+		BatchFlush time.Duration `env:"FIRESTORE_BATCH_FLUSH,default=1s"` //longest wait of a game for its batch to fill up
+	}
+
+	Checkpoint struct {
+		Store string `env:"CHECKPOINT_STORE,default=firestore"` //firestore or file
+		File  string `env:"CHECKPOINT_FILE,default=checkpoints.json"`
+	}
+
+	Retry struct {
+		MaxAttempts  int           `env:"RETRY_MAX_ATTEMPTS,default=5"`
+		InitialDelay time.Duration `env:"RETRY_INITIAL_DELAY,default=500ms"`
+		MaxDelay     time.Duration `env:"RETRY_MAX_DELAY,default=30s"`
+		Deadline     time.Duration `env:"RETRY_DEADLINE,default=2m"` //no retry starts later, 0 means no deadline
+	}
+
+	DeadLetter struct {
+		Store       string `env:"DEAD_LETTER_STORE,default=firestore"` //firestore or file
+		File        string `env:"DEAD_LETTER_FILE,default=dead-letters.jsonl"`
+		MaxAttempts int    `env:"DEAD_LETTER_MAX_ATTEMPTS,default=5"` //failed games are not retried anymore afterwards
+	}
+
 	Google struct {
 		ProjectID       string `env:"GOOGLE_PROJECT_ID"`
 		Secret          string `env:"GOOGLE_APPLICATION_CREDENTIALS"`
 		ArchiveFolderID string `env:"ARCHIVE_FOLDER_ID"`
+		ArchiveLayout   string `env:"ARCHIVE_LAYOUT"` //e.g. {year}/{month}, empty keeps all games in the archive folder
 	}
 
 	Lichess struct {
@@ -30,6 +105,69 @@ type Config struct {
 		UserID      string `env:"LICHESS_USER_ID"`
 		LimitPerSec int    `env:"LICHESS_API_LIMIT,default=20"`
 	}
+
+	ChessCom struct {
+		Username string `env:"CHESSCOM_USERNAME"`
+	}
+
+	UsersJSON string `env:"USERS"` //JSON list of users, LICHESS_USER_ID and CHESSCOM_USERNAME define the only user when empty
+	Users     []User
+
+	configured int //number of users defined by the environment, SelectUsers keeps it
+}
+
+// User is an account archived by the deployment, the empty settings fall back to the global ones
+type User struct {
+	Name             string `json:"name"`
+	LichessUserID    string `json:"lichess_user_id"`
+	LichessAPIKey    string `json:"lichess_api_key"`
+	ChessComUsername string `json:"chesscom_username"`
+	ArchiveFolderID  string `json:"archive_folder_id"`
+	Namespace        string `json:"namespace"` //Firestore namespace, the root collections are used when empty
+}
+
+func (u User) LichessEnabled() bool {
+	return u.LichessUserID != ""
+}
+
+func (u User) ChessComEnabled() bool {
+	return u.ChessComUsername != ""
+}
+
+// loadUsers decodes the users and fills their settings with the global defaults
+func (c *Config) loadUsers() error {
+	if c.UsersJSON == "" {
+		c.Users = []User{{LichessUserID: c.Lichess.UserID, ChessComUsername: c.ChessCom.Username}}
+	} else {
+		err := json.Unmarshal([]byte(c.UsersJSON), &c.Users)
+		if err != nil {
+			return errors.Wrap(err, "USERS ENV: invalid JSON")
+		}
+	}
+
+	c.configured = len(c.Users)
+
+	for i := range c.Users {
+		u := &c.Users[i]
+
+		if u.Name == "" {
+			u.Name = u.LichessUserID
+		}
+
+		if u.Name == "" {
+			u.Name = u.ChessComUsername
+		}
+
+		if u.LichessAPIKey == "" {
+			u.LichessAPIKey = c.Lichess.APIKey
+		}
+
+		if u.ArchiveFolderID == "" {
+			u.ArchiveFolderID = c.Google.ArchiveFolderID
+		}
+	}
+
+	return nil
 }
 
 func (c *Config) validate() error {
@@ -45,7 +183,49 @@ func (c *Config) validate() error {
 		return errors.WithStack(err)
 	}
 
-	err = c.validateLichessCredentials()
+	err = c.validateUsers()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if c.Archiver.MaxInFlight < 1 {
+		return errors.New("ARCHIVER_MAX_IN_FLIGHT ENV: should be positive")
+	}
+
+	if c.Run.MaxGames < 0 {
+		return errors.New("RUN_MAX_GAMES ENV: should not be negative")
+	}
+
+	if c.Run.Rated != "" && c.Run.Rated != "true" && c.Run.Rated != "false" {
+		return errors.Errorf("RUN_RATED ENV: %q should be true, false or empty", c.Run.Rated)
+	}
+
+	if c.Firestore.BatchSize < 0 || c.Firestore.BatchSize > 500 {
+		return errors.New("FIRESTORE_BATCH_SIZE ENV: should be between 0 and 500")
+	}
+
+	if c.Firestore.BatchFlush <= 0 {
+		return errors.New("FIRESTORE_BATCH_FLUSH ENV: should be positive")
+	}
+
+	if c.Checkpoint.Store != CheckpointFirestore && c.Checkpoint.Store != CheckpointFile {
+		return errors.Errorf("CHECKPOINT_STORE ENV: unknown store %q", c.Checkpoint.Store)
+	}
+
+	if c.Retry.MaxAttempts < 1 {
+		return errors.New("RETRY_MAX_ATTEMPTS ENV: should be positive")
+	}
+
+	if c.DeadLetter.Store != DeadLetterFirestore && c.DeadLetter.Store != DeadLetterFile {
+		return errors.Errorf("DEAD_LETTER_STORE ENV: unknown store %q", c.DeadLetter.Store)
+	}
+
+	err = c.validateProcessors()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = c.ValidateBatching()
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -53,21 +233,157 @@ func (c *Config) validate() error {
 	return nil
 }
 
-func (c *Config) validateEnvironment() error {
-	if c.Env == "" {
-		return errors.New("credentials file does not exist at the specified path")
+// ValidateBatching rejects the Firestore batches which could never fill up: every game waits for its batch,
+// so a batch only gets the games a worker of the user passed through the processors before firestore at once
+func (c *Config) ValidateBatching() error {
+	if !c.ProcessorEnabled(ProcessorFirestore) {
+		return nil
+	}
+
+	limit := c.Archiver.MaxInFlight
+
+	for _, p := range c.Archiver.Processors {
+		if concurrency := c.ProcessorConcurrency(p); concurrency < limit {
+			limit = concurrency
+		}
+
+		if p == ProcessorFirestore {
+			break
+		}
+	}
+
+	if c.Firestore.BatchSize > limit {
+		return errors.Errorf(
+			"FIRESTORE_BATCH_SIZE ENV: should not exceed the %d games reaching the firestore processor at once, "+
+				"see ARCHIVER_MAX_IN_FLIGHT and PROCESSOR_CONCURRENCY",
+			limit,
+		)
+	}
+
+	return nil
+}
+
+func (c *Config) validateUsers() error {
+	if len(c.Users) == 0 {
+		return errors.New("USERS ENV: at least one user is required")
+	}
+
+	names := make(map[string]bool, len(c.Users))
+
+	for _, u := range c.Users {
+		if !u.LichessEnabled() && !u.ChessComEnabled() {
+			return errors.New("at least one of LICHESS_USER_ID or CHESSCOM_USERNAME is required for every user")
+		}
+
+		if u.LichessEnabled() && u.LichessAPIKey == "" {
+			return errors.Errorf("LICHESS_API_KEY ENV: required by the user %s", u.Name)
+		}
+
+		if names[u.Name] {
+			return errors.Errorf("USERS ENV: duplicated user %s", u.Name)
+		}
+
+		names[u.Name] = true
+	}
+
+	return nil
+}
+
+func (c *Config) validateProcessors() error {
+	for _, item := range c.Archiver.Concurrency {
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 {
+			return errors.Errorf("PROCESSOR_CONCURRENCY ENV: %q should be name:limit", item)
+		}
+
+		limit, err := strconv.Atoi(parts[1])
+		if err != nil || limit < 1 {
+			return errors.Errorf("PROCESSOR_CONCURRENCY ENV: %q should have a positive limit", item)
+		}
+	}
+
+	for _, p := range c.Archiver.Processors {
+		switch p {
+		case ProcessorDrive, ProcessorFirestore, ProcessorSQLite:
+		case ProcessorLocal:
+			if c.Local.Dir == "" {
+				return errors.New("LOCAL_ARCHIVE_DIR ENV: required by the local processor")
+			}
+		default:
+			return errors.Errorf("PROCESSORS ENV: unknown processor %q", p)
+		}
 	}
 
 	return nil
 }
 
-func (c *Config) validateLichessCredentials() error {
-	if c.Lichess.APIKey == "" {
-		return errors.New("LICHESS_API_KEY ENV: required")
+// ProcessorConcurrency returns how many games the processor may handle at the same time across all users
+func (c *Config) ProcessorConcurrency(name string) int {
+	for _, item := range c.Archiver.Concurrency {
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 || parts[0] != name {
+			continue
+		}
+
+		limit, err := strconv.Atoi(parts[1])
+		if err == nil && limit > 0 {
+			return limit
+		}
 	}
 
-	if c.Lichess.UserID == "" {
-		return errors.New("LICHESS_USERNAME ENV: required")
+	return c.Archiver.MaxInFlight
+}
+
+// SelectUsers keeps only the named users
+func (c *Config) SelectUsers(names []string) error {
+	selected := make([]User, 0, len(names))
+
+	for _, name := range names {
+		var found bool
+
+		for _, u := range c.Users {
+			if u.Name == name {
+				selected = append(selected, u)
+				found = true
+			}
+		}
+
+		if !found {
+			return errors.Errorf("unknown user %s", name)
+		}
+	}
+
+	c.Users = selected
+
+	return nil
+}
+
+// MultiUser reports whether the environment defines several users, even when SelectUsers kept only one of them
+func (c *Config) MultiUser() bool {
+	return c.configured > 1 || len(c.Users) > 1
+}
+
+// ProcessorEnabled reports whether the processor is listed in PROCESSORS
+func (c *Config) ProcessorEnabled(name string) bool {
+	for _, p := range c.Archiver.Processors {
+		if p == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FirestoreEnabled reports whether the games, the checkpoints or the dead letters are stored in Firestore
+func (c *Config) FirestoreEnabled() bool {
+	return c.ProcessorEnabled(ProcessorFirestore) ||
+		c.Checkpoint.Store == CheckpointFirestore ||
+		c.DeadLetter.Store == DeadLetterFirestore
+}
+
+func (c *Config) validateEnvironment() error {
+	if c.Env == "" {
+		return errors.New("credentials file does not exist at the specified path")
 	}
 
 	return nil
@@ -98,7 +414,11 @@ func (c *Config) validateGoogleCredentials() error {
 	return nil
 }
 
-func NewConfig() (*Config, error) {
+// Override changes the settings read from the environment, e.g. from the command line flags
+type Override func(c *Config) error
+
+// NewConfig reads the settings from the environment, the overrides are applied before the settings are validated
+func NewConfig(overrides ...Override) (*Config, error) {
 	var config Config
 
 	err := envdecode.Decode(&config)
@@ -108,6 +428,18 @@ func NewConfig() (*Config, error) {
 		}
 	}
 
+	err = config.loadUsers()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for _, override := range overrides {
+		err = override(&config)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
 	err = config.validate()
 	if err != nil {
 		return nil, errors.WithStack(err)
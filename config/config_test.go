@@ -0,0 +1,140 @@
+package config
+
+import (
+	"os"
+	"testing"
+)
+
+// setenv sets the environment variables for the test, the previous values are restored afterwards
+func setenv(t *testing.T, env map[string]string) {
+	t.Helper()
+
+	for key, value := range env {
+		previous, ok := os.LookupEnv(key)
+
+		t.Cleanup(func(key string) func() {
+			return func() {
+				if ok {
+					_ = os.Setenv(key, previous)
+				} else {
+					_ = os.Unsetenv(key)
+				}
+			}
+		}(key))
+
+		_ = os.Setenv(key, value)
+	}
+}
+
+func TestNewConfigSingleUser(t *testing.T) {
+	setenv(t, map[string]string{
+		"ENVIRONMENT":       "local",
+		"LICHESS_API_KEY":   "key",
+		"LICHESS_USER_ID":   "alice",
+		"CHESSCOM_USERNAME": "Alice",
+		"USERS":             "",
+	})
+
+	c, err := NewConfig()
+	if err != nil {
+		t.Fatalf("NewConfig() error = %v", err)
+	}
+
+	if len(c.Users) != 1 {
+		t.Fatalf("Users = %+v, want the single user of LICHESS_USER_ID", c.Users)
+	}
+
+	if u := c.Users[0]; u.Name != "alice" || u.LichessAPIKey != "key" || u.ChessComUsername != "Alice" {
+		t.Errorf("Users[0] = %+v", u)
+	}
+
+	if c.MultiUser() {
+		t.Errorf("MultiUser() = true, want false")
+	}
+}
+
+func TestNewConfigUsers(t *testing.T) {
+	setenv(t, map[string]string{
+		"ENVIRONMENT":       "local",
+		"LICHESS_API_KEY":   "key",
+		"ARCHIVE_FOLDER_ID": "folder",
+		"USERS":             `[{"lichess_user_id":"a","namespace":"a"},{"name":"b","chesscom_username":"B","archive_folder_id":"b"}]`,
+	})
+
+	c, err := NewConfig()
+	if err != nil {
+		t.Fatalf("NewConfig() error = %v", err)
+	}
+
+	if len(c.Users) != 2 {
+		t.Fatalf("Users = %+v, want 2 users", c.Users)
+	}
+
+	if a := c.Users[0]; a.Name != "a" || a.LichessAPIKey != "key" || a.ArchiveFolderID != "folder" || a.Namespace != "a" {
+		t.Errorf("Users[0] = %+v", a)
+	}
+
+	if b := c.Users[1]; b.Name != "b" || b.LichessEnabled() || !b.ChessComEnabled() || b.ArchiveFolderID != "b" {
+		t.Errorf("Users[1] = %+v", b)
+	}
+
+	err = c.SelectUsers([]string{"b"})
+	if err != nil || len(c.Users) != 1 || c.Users[0].Name != "b" {
+		t.Errorf("SelectUsers() = %+v, %v, want user b", c.Users, err)
+	}
+
+	if !c.MultiUser() {
+		t.Errorf("MultiUser() = false after SelectUsers(), want true")
+	}
+}
+
+func TestNewConfigRejectsInvalidUsers(t *testing.T) {
+	for name, users := range map[string]string{
+		"duplicated": `[{"lichess_user_id":"a"},{"name":"a","chesscom_username":"b"}]`,
+		"no source":  `[{"name":"a"}]`,
+		"malformed":  `{"name":"a"}`,
+	} {
+		setenv(t, map[string]string{"ENVIRONMENT": "local", "LICHESS_API_KEY": "key", "USERS": users})
+
+		_, err := NewConfig()
+		if err == nil {
+			t.Errorf("%s: NewConfig() error = nil, want an error", name)
+		}
+	}
+}
+
+func TestNewConfigRejectsBatchesLargerThanTheConcurrency(t *testing.T) {
+	tests := []struct {
+		name        string
+		processors  string
+		concurrency string
+		maxInFlight string
+		size        string
+		wantErr     bool
+	}{
+		{"larger than firestore", "firestore", "firestore:20", "20", "50", true},
+		{"as large as firestore", "firestore", "firestore:20", "20", "20", false},
+		{"larger than the workers", "firestore", "firestore:20", "10", "20", true},
+		{"larger than a processor before", "drive;firestore", "drive:4;firestore:20", "20", "5", true},
+		{"as large as a processor before", "drive;firestore", "drive:4;firestore:20", "20", "4", false},
+		{"larger than a processor after", "firestore;drive", "drive:4;firestore:20", "20", "20", false},
+	}
+
+	for _, tt := range tests {
+		setenv(t, map[string]string{
+			"ENVIRONMENT":            "local",
+			"LICHESS_API_KEY":        "key",
+			"LICHESS_USER_ID":        "alice",
+			"USERS":                  "",
+			"PROCESSORS":             tt.processors,
+			"PROCESSOR_CONCURRENCY":  tt.concurrency,
+			"ARCHIVER_MAX_IN_FLIGHT": tt.maxInFlight,
+			"FIRESTORE_BATCH_SIZE":   tt.size,
+		})
+
+		_, err := NewConfig()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: NewConfig() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -6,29 +6,107 @@ import (
 	"chess-archive/pkg/google/drive"
 	"chess-archive/pkg/google/logging"
 	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/firestore"
-	"github.com/VMAnalytic/lichess-api-client/lichess"
-
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
+// commands are small, larger bodies are rejected
+const maxPayload = 1 << 20
+
 var (
-	logger    logrus.FieldLogger
-	cfg       *config.Config
-	initError error
+	logger logrus.FieldLogger = logging.NewLogger()
+
+	//reused by the invocations handled by the function instance
+	container = &app{}
 )
 
-func init() {
-	logger = logging.NewLogger()
-	cfg, initError = config.NewConfig()
+// app is the application container of the function instance, the config and the clients are created
+// by the first invocation needing them and cached for the next ones
+type app struct {
+	mu              sync.Mutex
+	cfg             *config.Config
+	dataStoreClient *firestore.Client
+	gdClient        drive.GDriveClient
+	checkpoints     chessArchive.CheckpointStore //shared by the accounts with the file store, nil with Firestore
+	deadLetters     chessArchive.DeadLetterStore //shared by the accounts with the file store, nil with Firestore
+}
+
+// config loads the config, an invalid config is loaded again by the next invocation
+func (a *app) config() (*config.Config, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-	if initError != nil {
-		logger.Fatal(initError)
+	if a.cfg != nil {
+		return a.cfg, nil
+	}
+
+	cfg, err := config.NewConfig()
+
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
 
 	config.TimeZone = cfg.TimeZone
+	a.cfg = cfg
+
+	//every account uses the same file, a store per account would overwrite the entries of the others
+	if cfg.Checkpoint.Store == config.CheckpointFile {
+		a.checkpoints = chessArchive.NewFileCheckpointStore(cfg.Checkpoint.File)
+	}
+
+	if cfg.DeadLetter.Store == config.DeadLetterFile {
+		a.deadLetters = chessArchive.NewFileDeadLetterStore(cfg.DeadLetter.File)
+	}
+
+	return cfg, nil
+}
+
+// firestore returns the cached Firestore client,
+// the clients outlive the invocation which created them, so they are not bound to its context
+func (a *app) firestore(cfg *config.Config) (*firestore.Client, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.dataStoreClient != nil {
+		return a.dataStoreClient, nil
+	}
+
+	client, err := firestore.NewClient(context.Background(), cfg.Google.ProjectID)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	a.dataStoreClient = client
+
+	return client, nil
+}
+
+// drive returns the cached Drive client
+func (a *app) drive(cfg *config.Config) (drive.GDriveClient, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.gdClient != nil {
+		return a.gdClient, nil
+	}
+
+	client, err := drive.NewHTTPtClient(context.Background(), chessArchive.NewRetryPolicy(cfg))
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	a.gdClient = client
+
+	return client, nil
 }
 
 // PubSubMessage is the payload of a Google Pub/Sub event
@@ -36,50 +114,139 @@ type PubSubMessage struct {
 	Data []byte `json:"data"`
 }
 
-// TrackEvent consumes a Pub/Sub message.
+// TrackEvent consumes a Pub/Sub message, its payload is the JSON command of the run.
+// The scheduled sync sends an empty payload, a replay names the user, the time window or the game to archive again.
+// Only the transient failures are returned to the runtime, so Pub/Sub redelivers the message,
+// the others would fail the same way again and are acknowledged after being logged.
 func TrackEvent(ctx context.Context, m PubSubMessage) error {
-	dataStoreClient, err := firestore.NewClient(ctx, cfg.Google.ProjectID)
+	_, err := container.run(ctx, m.Data)
+
+	if err == nil {
+		logger.Infoln("success")
+
+		return nil
+	}
+
+	if !chessArchive.IsTransient(err) {
+		logger.WithError(err).Errorln("run failed, the message is not redelivered")
+
+		return nil
+	}
+
+	logger.WithError(err).Warnln("run failed, the message is redelivered")
+
+	return err
+}
+
+// SyncHTTP runs the archiver for a manual or webhook request, the body is the same JSON command as the Pub/Sub payload.
+// It responds with the JSON run report, the status is 400 for an invalid command and 503 for a transient failure.
+func SyncHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	started := time.Now()
+
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPayload))
+
+	var summary *chessArchive.Summary
 
 	if err != nil {
-		logger.Fatalln(err)
+		err = &chessArchive.CommandError{Reason: err.Error()}
+	} else {
+		summary, err = container.run(r.Context(), payload)
 	}
 
-	lichessClient := lichess.NewClient(cfg.Lichess.APIKey, nil)
-	err = lichessClient.SetLimits(1*time.Second, uint(cfg.Lichess.LimitPerSec))
+	report := chessArchive.NewReport(started, summary, err)
 
 	if err != nil {
-		logger.Fatalln(err)
+		logger.WithError(err).Errorln("run failed")
 	}
 
-	gameStorage := chessArchive.NewDataStoreGameStorage(logger, dataStoreClient)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(report.Status())
+
+	_ = json.NewEncoder(w).Encode(report)
+}
 
-	transformer := chessArchive.NewGameTransformer(cfg.Lichess.UserID)
-	gdClient, err := drive.NewHTTPtClient(ctx)
+// run archives the games selected by the JSON command
+func (a *app) run(ctx context.Context, payload []byte) (*chessArchive.Summary, error) {
+	cfg, err := a.config()
 
 	if err != nil {
-		logger.Fatalln(err)
+		return nil, errors.WithStack(err)
 	}
 
-	gcloudProcessor := chessArchive.NewDriveStoreProcessor(cfg.Google.ArchiveFolderID, gdClient, transformer, logger)
+	cmd, err := chessArchive.ParseCommand(payload)
 
-	dataProcessor := chessArchive.NewDataStoreProcessor(logger, transformer, dataStoreClient)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 
-	arch := chessArchive.NewArchiver(
-		logger,
-		cfg,
-		transformer,
-		lichessClient,
-		gameStorage,
-		[]chessArchive.Processor{gcloudProcessor, dataProcessor},
-	)
+	runCfg, err := cmd.Apply(cfg)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 
-	err = arch.Run(ctx)
+	spec, err := cmd.Spec(runCfg)
 
 	if err != nil {
-		logger.Fatalln(err)
+		return nil, errors.WithStack(err)
 	}
 
-	logger.Infoln("success")
+	for _, name := range runCfg.Archiver.Processors {
+		if name != config.ProcessorDrive && name != config.ProcessorFirestore {
+			return nil, &chessArchive.CommandError{Reason: "the " + name + " processor is not available in the function"}
+		}
+	}
+
+	var (
+		dataStoreClient *firestore.Client
+		gdClient        drive.GDriveClient
+	)
+
+	if runCfg.FirestoreEnabled() {
+		dataStoreClient, err = a.firestore(runCfg)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if runCfg.ProcessorEnabled(config.ProcessorDrive) {
+		gdClient, err = a.drive(runCfg)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	clients := chessArchive.Clients{
+		DataStore:   dataStoreClient,
+		GDrive:      gdClient,
+		Checkpoints: a.checkpoints,
+		DeadLetters: a.deadLetters,
+	}
+
+	accounts := make([]*chessArchive.Account, 0, len(runCfg.Users))
+
+	for _, user := range runCfg.Users {
+		acc, err := chessArchive.NewAccount(runCfg, user, logger, clients)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		accounts = append(accounts, acc)
+	}
+
+	arch := chessArchive.NewArchiver(logger, runCfg, accounts)
+
+	summary, err := arch.Run(ctx, spec)
 
-	return nil
+	return summary, errors.WithStack(err)
 }
@@ -0,0 +1,101 @@
+package gfunctions
+
+import (
+	"chess-archive/config"
+	chessArchive "chess-archive/internal"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useTestContainer replaces the container of the function instance with a user without any source,
+// so a run neither reaches the chess sites nor the Google services
+func useTestContainer(t *testing.T) {
+	t.Helper()
+
+	dir := t.TempDir()
+	env := map[string]string{
+		"ENVIRONMENT":       "local",
+		"USERS":             "",
+		"LICHESS_USER_ID":   "",
+		"CHESSCOM_USERNAME": "alice",
+		"PROCESSORS":        "drive",
+		"CHECKPOINT_STORE":  "file",
+		"DEAD_LETTER_STORE": "file",
+	}
+
+	for key, value := range env {
+		previous, ok := os.LookupEnv(key)
+
+		t.Cleanup(func(key, previous string, ok bool) func() {
+			return func() {
+				if ok {
+					_ = os.Setenv(key, previous)
+				} else {
+					_ = os.Unsetenv(key)
+				}
+			}
+		}(key, previous, ok))
+
+		_ = os.Setenv(key, value)
+	}
+
+	cfg, err := config.NewConfig()
+	if err != nil {
+		t.Fatalf("NewConfig() error = %v", err)
+	}
+
+	cfg.Users[0].ChessComUsername = ""
+	cfg.Archiver.Processors = nil
+
+	previous := container
+	container = &app{
+		cfg:         cfg,
+		checkpoints: chessArchive.NewFileCheckpointStore(filepath.Join(dir, "checkpoints.json")),
+		deadLetters: chessArchive.NewFileDeadLetterStore(filepath.Join(dir, "dead-letters.jsonl")),
+	}
+
+	t.Cleanup(func() {
+		container = previous
+	})
+}
+
+func TestSyncHTTPStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		payload string
+		want    int
+	}{
+		{"scheduled sync", http.MethodPost, "", http.StatusOK},
+		{"not a POST", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"unknown field", http.MethodPost, `{"unknown":1}`, http.StatusBadRequest},
+		{"unknown user", http.MethodPost, `{"user":"bob"}`, http.StatusBadRequest},
+		{"processor of the CLI", http.MethodPost, `{"processors":["local"]}`, http.StatusBadRequest},
+		{"too large", http.MethodPost, strings.Repeat(" ", maxPayload+1), http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		useTestContainer(t)
+
+		w := httptest.NewRecorder()
+		SyncHTTP(w, httptest.NewRequest(tt.method, "/", strings.NewReader(tt.payload)))
+
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d, body %s", tt.name, w.Code, tt.want, w.Body)
+		}
+	}
+}
+
+func TestTrackEventAcknowledgesTheInvalidCommands(t *testing.T) {
+	useTestContainer(t)
+
+	err := TrackEvent(context.Background(), PubSubMessage{Data: []byte(`{"unknown":1}`)})
+	if err != nil {
+		t.Errorf("TrackEvent() error = %v, want nil", err)
+	}
+}
@@ -4,13 +4,18 @@ go 1.16
 
 require (
 	cloud.google.com/go/firestore v1.5.0
-	github.com/VMAnalytic/lichess-api-client v0.0.0-20210517162314-b6d501140556 // indirect
+	github.com/VMAnalytic/lichess-api-client v0.0.0-20210517162314-b6d501140556
 	github.com/fatih/structs v1.1.0
 	github.com/joeshaw/envdecode v0.0.0-20200121155833-099f1fc765bd
 	github.com/joho/godotenv v1.3.0
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/pkg/errors v0.9.1
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/sirupsen/logrus v1.8.1
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
 	golang.org/x/time v0.0.0-20210220033141-f8bda1e9f3ba
 	google.golang.org/api v0.46.0
+	google.golang.org/genproto v0.0.0-20210429181445-86c259c2b4ab
+	google.golang.org/grpc v1.37.0
+	google.golang.org/protobuf v1.26.0
 )
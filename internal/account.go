@@ -0,0 +1,116 @@
+package chessarchive
+
+import (
+	"chess-archive/config"
+	"chess-archive/pkg/google/drive"
+	"path/filepath"
+
+	"cloud.google.com/go/firestore"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// DatabaseStore is a processor keeping the games of every user in a single database, the newest game is looked up in it
+type DatabaseStore interface {
+	Processor
+	GameStorage
+}
+
+// Clients are shared by the accounts, the ones of the disabled processors and stores are nil
+type Clients struct {
+	DataStore   *firestore.Client
+	GDrive      drive.GDriveClient
+	SQLite      DatabaseStore
+	Checkpoints CheckpointStore //shared by the accounts with the file store, nil with Firestore
+	DeadLetters DeadLetterStore //shared by the accounts with the file store, nil with Firestore
+}
+
+// NewAccount wires the providers, stores and processors of the user with the shared clients
+func NewAccount(cfg *config.Config, user config.User, logger logrus.FieldLogger, clients Clients) (*Account, error) {
+	logger = logger.WithField("user", user.Name)
+	files := NewFileTransformer(user.LichessUserID, user.ChessComUsername)
+
+	providers, err := NewProviders(cfg, user)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	processors, err := newProcessors(cfg, user, logger, files, clients)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	checkpoints := clients.Checkpoints
+	if checkpoints == nil {
+		checkpoints = NewDataStoreCheckpointStore(clients.DataStore, user.Namespace)
+	}
+
+	deadLetters := clients.DeadLetters
+	if deadLetters == nil {
+		deadLetters = NewDataStoreDeadLetterStore(clients.DataStore, user.Namespace)
+	}
+
+	return &Account{
+		Name:        user.Name,
+		Providers:   providers,
+		GameStorage: NewGameStorage(cfg, user, logger, files, clients.DataStore, clients.GDrive, clients.SQLite),
+		Checkpoints: checkpoints,
+		DeadLetters: deadLetters,
+		Processors:  processors,
+	}, nil
+}
+
+func newProcessors(
+	cfg *config.Config,
+	user config.User,
+	logger logrus.FieldLogger,
+	files *FileTransformer,
+	clients Clients,
+) ([]Processor, error) {
+	var processors []Processor
+
+	for _, name := range cfg.Archiver.Processors {
+		switch name {
+		case config.ProcessorDrive:
+			layout, err := ParseLayout(cfg.Google.ArchiveLayout)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+
+			processors = append(processors, NewDriveStoreProcessor(user.ArchiveFolderID, layout, clients.GDrive, files, logger))
+		case config.ProcessorFirestore:
+			processors = append(
+				processors,
+				NewDataStoreProcessor(
+					logger,
+					clients.DataStore,
+					user.Namespace,
+					NewRetryPolicy(cfg),
+				).WithBatching(cfg.Firestore.BatchSize, cfg.Firestore.BatchFlush),
+			)
+		case config.ProcessorLocal:
+			layout, err := ParseLayout(cfg.Local.Layout)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+
+			//every user gets its own directory, so the monthly files are never shared,
+			//the full user list decides it so a run narrowed to one user writes to the same directory
+			dir := cfg.Local.Dir
+			if cfg.MultiUser() {
+				dir = filepath.Join(dir, user.Name)
+			}
+
+			fsProcessor, err := NewFileSystemProcessor(dir, layout, cfg.Local.Mode, cfg.Local.Naming, logger)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+
+			processors = append(processors, fsProcessor)
+		case config.ProcessorSQLite:
+			processors = append(processors, clients.SQLite)
+		}
+	}
+
+	return processors, nil
+}
@@ -3,86 +3,663 @@ package chessarchive
 import (
 	"chess-archive/config"
 	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/VMAnalytic/lichess-api-client/lichess"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
+	"google.golang.org/api/iterator"
 )
 
+// Account is a user archived by the deployment together with its own sources, stores and processors
+type Account struct {
+	Name        string
+	Providers   []GameProvider
+	GameStorage GameStorage //optional, used to resume until the first checkpoint is saved
+	Checkpoints CheckpointStore
+	DeadLetters DeadLetterStore //optional, without it a failed game stops the run of the account
+	Processors  []Processor
+}
+
+type gameJob struct {
+	seq  uint64
+	game *Game
+}
+
+// accountRun is the state of an account during a run
+type accountRun struct {
+	*Account
+	logger  logrus.FieldLogger
+	summary *UserSummary
+	spec    RunSpec
+}
+
+// RunError is returned by a run in which some accounts failed, it keeps the error of every failed account
+type RunError struct {
+	Users map[string]error
+}
+
+func (e *RunError) Error() string {
+	names := make([]string, 0, len(e.Users))
+	for name := range e.Users {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return fmt.Sprintf("archiving failed for users: %s", strings.Join(names, ", "))
+}
+
 type Archiver struct {
-	logger        logrus.FieldLogger
-	cfg           *config.Config
-	transformer   *LichessTransformer
-	chessProvider *lichess.Client
-	gameStorage   GameStorage
-	processors    []Processor
+	logger   logrus.FieldLogger
+	cfg      *config.Config
+	accounts []*Account
+	limits   map[string]chan struct{} //games handled by each processor at the same time, shared by the accounts
+	metrics  *Metrics
 }
 
 func NewArchiver(
 	logger logrus.FieldLogger,
 	cfg *config.Config,
-	transformer *LichessTransformer,
-	chessProvider *lichess.Client,
-	gameStorage GameStorage,
-	processors []Processor,
+	accounts []*Account,
 ) *Archiver {
+	limits := map[string]chan struct{}{}
+
+	for _, acc := range accounts {
+		for _, p := range acc.Processors {
+			if _, ok := limits[p.Name()]; !ok {
+				limits[p.Name()] = make(chan struct{}, cfg.ProcessorConcurrency(p.Name()))
+			}
+		}
+	}
+
 	return &Archiver{
-		logger:        logger,
-		cfg:           cfg,
-		transformer:   transformer,
-		chessProvider: chessProvider,
-		gameStorage:   gameStorage,
-		processors:    processors,
+		logger:   logger,
+		cfg:      cfg,
+		accounts: accounts,
+		limits:   limits,
+		metrics:  NewMetrics(),
 	}
 }
 
-func (a Archiver) Run(ctx context.Context) error {
+// Metrics returns the throughput of the processors
+func (a Archiver) Metrics() *Metrics {
+	return a.metrics
+}
+
+// WithMetrics returns a copy of the archiver recording the throughput into the metrics, so they outlive the archiver
+func (a Archiver) WithMetrics(metrics *Metrics) *Archiver {
+	a.metrics = metrics
+
+	return &a
+}
+
+// Run archives the games of the accounts matching the spec concurrently, a failed account does not stop the others.
+// A failed game does not stop its account either, it is recorded as a dead letter and retried on the next run.
+func (a Archiver) Run(ctx context.Context, spec RunSpec) (*Summary, error) {
 	a.logger.Infoln("process started...")
 
-	since := int64(0)
-	latest, err := a.gameStorage.Last(ctx)
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		failed  = map[string]error{}
+		summary = &Summary{Users: make([]*UserSummary, len(a.accounts))}
+	)
 
-	if err != nil {
-		return errors.WithStack(err)
+	for i, acc := range a.accounts {
+		run := &accountRun{
+			Account: acc,
+			logger:  a.logger.WithField("user", acc.Name),
+			summary: newUserSummary(acc.Name),
+			spec:    spec,
+		}
+		summary.Users[i] = run.summary
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			err := a.runAccount(ctx, run)
+			a.flush(ctx, run)
+
+			if err != nil {
+				run.logger.WithError(err).Errorln("archiving failed")
+				run.summary.Error = err.Error()
+
+				mu.Lock()
+				failed[run.Name] = err
+				mu.Unlock()
+			}
+
+			if a.cfg.Archiver.DryRun {
+				run.logger.Infof(
+					"dry run, %d games fetched, planned: %v, failed: %v",
+					run.summary.Games,
+					run.summary.Planned,
+					run.summary.Failed,
+				)
+
+				return
+			}
+
+			run.logger.Infof(
+				"%d games fetched, succeeded: %v, failed: %v, dead letters retried: %d, recovered: %d",
+				run.summary.Games,
+				run.summary.Succeeded,
+				run.summary.Failed,
+				run.summary.Retried,
+				run.summary.Recovered,
+			)
+		}()
 	}
 
-	if latest != nil {
-		since = latest.PlayedAt
+	wg.Wait()
+
+	summary.Processors = a.metrics.Snapshot()
+
+	for _, stats := range summary.Processors {
+		a.logger.Infof(
+			"%s processor: %d processed, %d failed, %.2f games/s",
+			stats.Name,
+			stats.Processed,
+			stats.Failed,
+			stats.Throughput,
+		)
 	}
 
-	games, _, err := a.chessProvider.Games.List(ctx, a.cfg.Lichess.UserID, lichess.ListOptions{Since: since})
-	if err != nil {
-		return errors.WithStack(err)
+	if len(failed) > 0 {
+		return summary, &RunError{Users: failed}
 	}
 
-	group, gctx := errgroup.WithContext(ctx)
+	if spec.GameID != "" && summary.Games() == 0 {
+		return summary, errors.Errorf("game ID: %s was not played by any of the archived users", spec.GameID)
+	}
+
+	a.logger.Infoln("process finished...")
+
+	return summary, nil
+}
+
+// flush writes the games the processors of the account still buffer, their Process calls gave up waiting for them already
+func (a Archiver) flush(ctx context.Context, run *accountRun) {
+	for _, p := range run.Processors {
+		f, ok := p.(Flusher)
+		if !ok {
+			continue
+		}
 
-	for _, g := range games {
-		game, err := a.transformer.Transform(g)
+		err := f.Flush(ctx)
+		if err != nil {
+			run.logger.WithError(err).Errorf("%s processor could not write its buffered games", p.Name())
+		}
+	}
+}
+
+func (a Archiver) runAccount(ctx context.Context, run *accountRun) error {
+	for _, provider := range run.Providers {
+		if run.spec.GameID != "" {
+			err := a.rearchive(ctx, run, provider)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			continue
+		}
+
+		//a dry run leaves the dead letters for the next real run
+		if !a.cfg.Archiver.DryRun {
+			err := a.retryDeadLetters(ctx, run, provider)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+
+		err := a.runProvider(ctx, run, provider)
 		if err != nil {
 			return errors.WithStack(err)
 		}
+	}
+
+	return nil
+}
 
-		for _, p := range a.processors {
-			proc := p
+func (a Archiver) runProvider(ctx context.Context, run *accountRun, provider GameProvider) error {
+	var (
+		resume *Checkpoint
+		opts   = run.spec.options()
+		err    error
+	)
 
-			group.Go(func() error {
-				err = proc.Process(gctx, game)
+	if run.spec.resumes() {
+		resume, err = a.resumeFrom(ctx, run.Account, provider)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if resume != nil {
+			opts.Since = resume.PlayedAt
+
+			//the checkpoint game is listed again and skipped
+			if opts.Max > 0 {
+				opts.Max++
+			}
+		}
+	}
+
+	run.logger.Infof("fetching %s games of %s since %d", provider.Source(), provider.User(), opts.Since)
+
+	group, gctx := errgroup.WithContext(ctx)
+
+	games, err := provider.List(gctx, opts)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer games.Stop()
+
+	cur := newCursor()
+	cp := newCheckpointer(run.Checkpoints, provider.Source(), provider.User())
+	//the stream is not read while all workers are busy
+	jobs := make(chan gameJob)
+
+	group.Go(func() error {
+		defer close(jobs)
+
+		for {
+			game, err := games.Next()
+			if err == iterator.Done {
+				return nil
+			}
+
+			var terr *TransformError
+			if errors.As(err, &terr) {
+				run.summary.game()
+
+				err = a.transformFailed(gctx, run, provider, terr, 1)
 				if err != nil {
 					return errors.WithStack(err)
 				}
 
-				return nil
-			})
+				continue
+			}
+
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			//since is inclusive, the checkpoint game itself was already processed
+			if resume != nil && game.ID == resume.GameID {
+				continue
+			}
+
+			run.summary.game()
+
+			select {
+			case jobs <- gameJob{seq: cur.add(), game: game}:
+			case <-gctx.Done():
+				return gctx.Err()
+			}
 		}
+	})
+
+	for i := 0; i < a.cfg.Archiver.MaxInFlight; i++ {
+		group.Go(func() error {
+			for job := range jobs {
+				_, err := a.process(gctx, run, provider, job.game)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+
+				//the failed processors are in the dead letters, the checkpoint may move past the game
+				pos, posSeq, advanced := cur.commit(job.seq, job.game)
+				if !advanced || a.cfg.Archiver.DryRun || !run.spec.savesCheckpoints() {
+					continue
+				}
+
+				err = cp.save(gctx, posSeq, pos)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+
+				run.logger.Debugf("%s checkpoint advanced to game ID: %s (%d)", provider.Source(), pos.ID, pos.PlayedAt)
+			}
+
+			return nil
+		})
 	}
 
-	if err = group.Wait(); err != nil {
+	err = group.Wait()
+
+	if pos := cur.position(); pos != nil {
+		run.logger.Infof("%s games committed up to game ID: %s (%d)", provider.Source(), pos.ID, pos.PlayedAt)
+	}
+
+	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	a.logger.Infoln("process finished...")
+	return nil
+}
+
+// rearchive fetches the game of the spec from the provider again and hands it to every processor
+func (a Archiver) rearchive(ctx context.Context, run *accountRun, provider GameProvider) error {
+	if run.spec.Source != 0 && provider.Source() != run.spec.Source {
+		return nil
+	}
+
+	fetcher, ok := provider.(GameFetcher)
+	if !ok {
+		if run.spec.Source == 0 {
+			return nil
+		}
+
+		return errors.Errorf("%s games can not be fetched by ID, backfill the time range of the game instead", provider.Source())
+	}
+
+	game, err := fetcher.Fetch(ctx, run.spec.GameID)
+
+	var terr *TransformError
+	if errors.As(err, &terr) {
+		run.summary.game()
+
+		return errors.WithStack(a.transformFailed(ctx, run, provider, terr, 1))
+	}
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !game.playedBy(provider.User()) {
+		run.logger.Debugf("game ID: %s was not played by %s", game.ID, provider.User())
+
+		return nil
+	}
+
+	run.summary.game()
+	run.logger.Infof("re-archiving %s game ID: %s", provider.Source(), game.ID)
+
+	_, err = a.process(ctx, run, provider, game)
+
+	return errors.WithStack(err)
+}
+
+// resumeFrom returns the checkpoint of the provider, the newest stored game is used until the first checkpoint is saved
+func (a Archiver) resumeFrom(ctx context.Context, acc *Account, provider GameProvider) (*Checkpoint, error) {
+	cp, err := acc.Checkpoints.Get(ctx, provider.Source(), provider.User())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if cp != nil || acc.GameStorage == nil {
+		return cp, nil
+	}
+
+	latest, err := acc.GameStorage.Last(ctx, provider.Source(), provider.User())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if latest == nil {
+		return nil, nil
+	}
+
+	return &Checkpoint{
+		Source:   provider.Source(),
+		UserID:   provider.User(),
+		PlayedAt: latest.PlayedAt,
+		GameID:   latest.ID,
+	}, nil
+}
+
+// process hands the game to every processor, a failed processor does not stop the others.
+// It reports whether all processors succeeded, the returned error means the failure could not be recorded.
+func (a Archiver) process(ctx context.Context, run *accountRun, provider GameProvider, game *Game) (bool, error) {
+	return a.processAttempt(ctx, run, provider, game, 1)
+}
+
+// processAttempt processes the game, the dead letters of the failed processors record the attempt
+func (a Archiver) processAttempt(ctx context.Context, run *accountRun, provider GameProvider, game *Game, attempts int) (bool, error) {
+	if game.PGNError != "" {
+		run.logger.Warnf("game ID: %s is archived with invalid PGN: %s", game.ID, game.PGNError)
+	}
+
+	if a.cfg.Archiver.DryRun {
+		return a.plan(ctx, run, game)
+	}
+
+	ok := true
+
+	for _, p := range run.Processors {
+		err := a.processWith(ctx, p, game)
+		if err == nil {
+			run.summary.succeed(p.Name())
+
+			continue
+		}
+
+		ok = false
+
+		err = a.processFailed(ctx, run, provider, p, game, err, attempts)
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+	}
+
+	return ok, nil
+}
+
+// plan reports what every processor would do with the game, nothing is written
+func (a Archiver) plan(ctx context.Context, run *accountRun, game *Game) (bool, error) {
+	ok := true
+
+	for _, p := range run.Processors {
+		planner, isPlanner := p.(Planner)
+		if !isPlanner {
+			run.logger.Infof("%s processor would process game ID: %s", p.Name(), game.ID)
+			run.summary.plan(p.Name(), "process")
+
+			continue
+		}
+
+		var action Action
+
+		err := a.withSlot(ctx, p, func() error {
+			var err error
+			action, err = planner.Plan(ctx, game)
+
+			return err
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return false, errors.WithStack(err)
+			}
+
+			ok = false
+
+			run.logger.Errorf("game ID: %s could not be planned in the %s processor: %s", game.ID, p.Name(), err)
+			run.summary.fail(p.Name())
+
+			continue
+		}
+
+		run.logger.Infof("%s processor would %s game ID: %s", p.Name(), action, game.ID)
+		run.summary.plan(p.Name(), string(action))
+	}
+
+	return ok, nil
+}
+
+// processWith hands the game to the processor once the processor has a free slot
+func (a Archiver) processWith(ctx context.Context, p Processor, game *Game) error {
+	return a.withSlot(ctx, p, func() error {
+		return p.Process(ctx, game)
+	})
+}
+
+// withSlot runs the function once the processor has a free slot
+func (a Archiver) withSlot(ctx context.Context, p Processor, fn func() error) error {
+	limit := a.limits[p.Name()]
+
+	select {
+	case limit <- struct{}{}:
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	}
+
+	defer func() { <-limit }()
+
+	done := a.metrics.start(p.Name())
+	err := fn()
+	done(err)
+
+	return errors.WithStack(err)
+}
+
+func (a Archiver) processFailed(
+	ctx context.Context,
+	run *accountRun,
+	provider GameProvider,
+	p Processor,
+	game *Game,
+	cause error,
+	attempts int,
+) error {
+	//the cancelled run is not a failure of the game
+	if ctx.Err() != nil || run.DeadLetters == nil {
+		return errors.WithStack(cause)
+	}
+
+	run.logger.Errorf("game ID: %s failed in the %s processor (attempt %d): %s", game.ID, p.Name(), attempts, cause)
+	run.summary.fail(p.Name())
+
+	return errors.WithStack(run.DeadLetters.Save(ctx, &DeadLetter{
+		Source:   provider.Source(),
+		UserID:   provider.User(),
+		GameID:   game.ID,
+		Stage:    p.Name(),
+		Error:    cause.Error(),
+		Attempts: attempts,
+		FailedAt: time.Now().UTC(),
+		Game:     game,
+	}))
+}
+
+func (a Archiver) transformFailed(
+	ctx context.Context,
+	run *accountRun,
+	provider GameProvider,
+	terr *TransformError,
+	attempts int,
+) error {
+	if run.DeadLetters == nil {
+		return errors.WithStack(terr)
+	}
+
+	run.logger.Errorf("game ID: %s could not be transformed (attempt %d): %s", terr.GameID, attempts, terr.Err)
+	run.summary.fail(stageTransform)
+
+	if a.cfg.Archiver.DryRun {
+		return nil
+	}
+
+	return errors.WithStack(run.DeadLetters.Save(ctx, &DeadLetter{
+		Source:   provider.Source(),
+		UserID:   provider.User(),
+		GameID:   terr.GameID,
+		Stage:    stageTransform,
+		Error:    terr.Err.Error(),
+		Attempts: attempts,
+		FailedAt: time.Now().UTC(),
+		Raw:      string(terr.Raw),
+	}))
+}
+
+// retryDeadLetters retries the failed games of the provider until they run out of attempts
+func (a Archiver) retryDeadLetters(ctx context.Context, run *accountRun, provider GameProvider) error {
+	if run.DeadLetters == nil {
+		return nil
+	}
+
+	list, err := run.DeadLetters.List(ctx, provider.Source(), provider.User())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, dl := range list {
+		if dl.Attempts >= a.cfg.DeadLetter.MaxAttempts {
+			run.logger.Warnf("game ID: %s is not retried in %s after %d attempts: %s", dl.GameID, dl.Stage, dl.Attempts, dl.Error)
+
+			continue
+		}
+
+		recovered, err := a.retry(ctx, run, provider, dl)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		run.summary.retry(recovered)
+	}
 
 	return nil
 }
+
+func (a Archiver) retry(ctx context.Context, run *accountRun, provider GameProvider, dl *DeadLetter) (bool, error) {
+	if dl.Stage == stageTransform {
+		return a.retryTransform(ctx, run, provider, dl)
+	}
+
+	var p Processor
+
+	for _, candidate := range run.Processors {
+		if candidate.Name() == dl.Stage {
+			p = candidate
+		}
+	}
+
+	if p == nil {
+		run.logger.Warnf("game ID: %s is not retried, the %s processor is not enabled", dl.GameID, dl.Stage)
+
+		return false, nil
+	}
+
+	err := a.processWith(ctx, p, dl.Game)
+	if err != nil {
+		return false, errors.WithStack(a.processFailed(ctx, run, provider, p, dl.Game, err, dl.Attempts+1))
+	}
+
+	run.summary.succeed(p.Name())
+
+	return true, errors.WithStack(run.DeadLetters.Delete(ctx, dl))
+}
+
+// retryTransform transforms the raw game again and hands it to every processor
+func (a Archiver) retryTransform(ctx context.Context, run *accountRun, provider GameProvider, dl *DeadLetter) (bool, error) {
+	decoder, ok := provider.(RawGameDecoder)
+	if !ok {
+		run.logger.Warnf("game ID: %s is not retried, %s games can not be transformed again", dl.GameID, provider.Source())
+
+		return false, nil
+	}
+
+	game, err := decoder.Decode([]byte(dl.Raw))
+	if err != nil {
+		var terr *TransformError
+		if !errors.As(err, &terr) {
+			return false, errors.WithStack(err)
+		}
+
+		return false, errors.WithStack(a.transformFailed(ctx, run, provider, terr, dl.Attempts+1))
+	}
+
+	err = run.DeadLetters.Delete(ctx, dl)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	//the game keeps counting its attempts when a processor fails it now
+	return a.processAttempt(ctx, run, provider, game, dl.Attempts+1)
+}
@@ -0,0 +1,381 @@
+package chessarchive
+
+import (
+	"chess-archive/config"
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// lichessGames creates the games 0 to n-1 of the user, played a second apart
+func lichessGames(userID string, n int) []*Game {
+	var games []*Game
+
+	for i := 0; i < n; i++ {
+		games = append(games, &Game{ID: fmt.Sprint(i), Source: lichessorg, UserID: userID, PlayedAt: int64(i * 1000)})
+	}
+
+	return games
+}
+
+func newTestArchiver(cfg *config.Config, accounts ...*Account) *Archiver {
+	logger, _ := test.NewNullLogger()
+
+	if cfg.Archiver.MaxInFlight == 0 {
+		cfg.Archiver.MaxInFlight = 5
+	}
+
+	return NewArchiver(logger, cfg, accounts)
+}
+
+func TestArchiverRunArchivesEveryPage(t *testing.T) {
+	store := NewMemoryGameStore()
+	acc := &Account{
+		Name:        "u",
+		Providers:   []GameProvider{NewMemoryGameProvider(lichessorg, "u", 7, lichessGames("u", 100)...)},
+		GameStorage: store,
+		Checkpoints: NewMemoryCheckpointStore(),
+		Processors:  []Processor{store},
+	}
+
+	s, err := newTestArchiver(&config.Config{}, acc).Run(context.Background(), RunSpec{})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if got := len(store.Games()); got != 100 {
+		t.Errorf("stored games = %d, want 100", got)
+	}
+
+	if s.Users[0].Games != 100 || s.Users[0].Succeeded[store.Name()] != 100 || s.Failures() != 0 {
+		t.Errorf("summary = %+v, want 100 games archived without failures", s.Users[0])
+	}
+}
+
+func TestArchiverRunResumesAfterLastGame(t *testing.T) {
+	games := lichessGames("u", 10)
+	provider := NewMemoryGameProvider(lichessorg, "u", 3, games[:6]...)
+	store := NewMemoryGameStore()
+	acc := &Account{
+		Name:        "u",
+		Providers:   []GameProvider{provider},
+		GameStorage: store,
+		Checkpoints: NewMemoryCheckpointStore(),
+		Processors:  []Processor{store},
+	}
+	a := newTestArchiver(&config.Config{}, acc)
+
+	_, err := a.Run(context.Background(), RunSpec{})
+	if err != nil {
+		t.Fatalf("first Run() error = %v", err)
+	}
+
+	provider.Add(games[6:]...)
+
+	s, err := a.Run(context.Background(), RunSpec{})
+	if err != nil {
+		t.Fatalf("second Run() error = %v", err)
+	}
+
+	if s.Users[0].Games != 4 {
+		t.Errorf("games of the second run = %d, want 4", s.Users[0].Games)
+	}
+
+	if got := len(store.Games()); got != 10 {
+		t.Errorf("stored games = %d, want 10", got)
+	}
+}
+
+func TestArchiverRunSeparatesAccounts(t *testing.T) {
+	alice, bob := NewMemoryGameStore(), NewMemoryGameStore()
+	accounts := []*Account{
+		{
+			Name:        "alice",
+			Providers:   []GameProvider{NewMemoryGameProvider(lichessorg, "alice", 2, lichessGames("alice", 3)...)},
+			Checkpoints: NewMemoryCheckpointStore(),
+			Processors:  []Processor{alice},
+		},
+		{
+			Name:        "bob",
+			Providers:   []GameProvider{NewMemoryGameProvider(lichessorg, "bob", 2, lichessGames("bob", 5)...)},
+			Checkpoints: NewMemoryCheckpointStore(),
+			Processors:  []Processor{bob},
+		},
+	}
+
+	s, err := newTestArchiver(&config.Config{}, accounts...).Run(context.Background(), RunSpec{})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if len(alice.Games()) != 3 || len(bob.Games()) != 5 {
+		t.Errorf("stored games = %d and %d, want 3 and 5", len(alice.Games()), len(bob.Games()))
+	}
+
+	if len(s.Users) != 2 || s.Games() != 8 {
+		t.Errorf("summary of %d users with %d games, want 2 users with 8 games", len(s.Users), s.Games())
+	}
+}
+
+// failingProcessor fails the listed games until it is repaired
+type failingProcessor struct {
+	mu    sync.Mutex
+	games map[string]bool
+}
+
+func (p *failingProcessor) Name() string {
+	return "failing"
+}
+
+func (p *failingProcessor) Process(_ context.Context, g *Game) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.games[g.ID] {
+		return errors.Errorf("game ID: %s can not be processed", g.ID)
+	}
+
+	return nil
+}
+
+func (p *failingProcessor) repair() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.games = nil
+}
+
+func TestArchiverRunRetriesDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryGameStore()
+	checkpoints := NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoints.json"))
+	deadLetters := NewFileDeadLetterStore(filepath.Join(t.TempDir(), "dead-letters.jsonl"))
+	failing := &failingProcessor{games: map[string]bool{"5": true}}
+	acc := &Account{
+		Name:        "u",
+		Providers:   []GameProvider{NewMemoryGameProvider(lichessorg, "u", 7, lichessGames("u", 100)...)},
+		GameStorage: store,
+		Checkpoints: checkpoints,
+		DeadLetters: deadLetters,
+		Processors:  []Processor{store, failing},
+	}
+
+	cfg := &config.Config{}
+	cfg.DeadLetter.MaxAttempts = 3
+	a := newTestArchiver(cfg, acc)
+
+	s, err := a.Run(ctx, RunSpec{})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if s.Failures() != 1 || s.Users[0].Failed[failing.Name()] != 1 {
+		t.Errorf("summary = %+v, want game 5 failed", s.Users[0])
+	}
+
+	//the failed game does not hold the checkpoint back
+	cp, err := checkpoints.Get(ctx, lichessorg, "u")
+	if err != nil || cp == nil || cp.GameID != "99" {
+		t.Fatalf("checkpoint = %+v, %v, want game 99", cp, err)
+	}
+
+	dls, err := deadLetters.List(ctx, lichessorg, "u")
+	if err != nil || len(dls) != 1 || dls[0].GameID != "5" || dls[0].Attempts != 1 {
+		t.Fatalf("dead letters = %+v, %v, want game 5 after one attempt", dls, err)
+	}
+
+	s, err = a.Run(ctx, RunSpec{})
+	if err != nil || s.Users[0].Retried != 1 || s.Users[0].Recovered != 0 {
+		t.Fatalf("second Run() = %+v, %v, want game 5 retried without success", s.Users[0], err)
+	}
+
+	dls, err = deadLetters.List(ctx, lichessorg, "u")
+	if err != nil || len(dls) != 1 || dls[0].Attempts != 2 {
+		t.Fatalf("dead letters = %+v, %v, want game 5 after two attempts", dls, err)
+	}
+
+	failing.repair()
+
+	s, err = a.Run(ctx, RunSpec{})
+	if err != nil || s.Users[0].Recovered != 1 {
+		t.Fatalf("third Run() = %+v, %v, want game 5 recovered", s.Users[0], err)
+	}
+
+	dls, err = deadLetters.List(ctx, lichessorg, "u")
+	if err != nil || len(dls) != 0 {
+		t.Errorf("dead letters = %+v, %v, want none", dls, err)
+	}
+}
+
+func TestArchiverRunDryRunPlansActions(t *testing.T) {
+	ctx := context.Background()
+	games := lichessGames("u", 10)
+	store := NewMemoryGameStore()
+
+	_ = store.Process(ctx, games[0])
+
+	changed := *games[1]
+	changed.Status = "resign"
+	_ = store.Process(ctx, &changed)
+
+	checkpoints := NewMemoryCheckpointStore()
+	acc := &Account{
+		Name:        "u",
+		Providers:   []GameProvider{NewMemoryGameProvider(lichessorg, "u", 3, games...)},
+		Checkpoints: checkpoints,
+		Processors:  []Processor{store, &failingProcessor{}},
+	}
+
+	cfg := &config.Config{}
+	cfg.Archiver.DryRun = true
+
+	s, err := newTestArchiver(cfg, acc).Run(ctx, RunSpec{})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	planned := s.Users[0].Planned[store.Name()]
+	if planned[string(ActionCreate)] != 8 || planned[string(ActionUpdate)] != 1 || planned[string(ActionSkip)] != 1 {
+		t.Errorf("planned actions = %v, want 8 created, 1 updated and 1 skipped", planned)
+	}
+
+	if len(store.Games()) != 2 {
+		t.Errorf("stored games = %d, want the 2 stored before the dry run", len(store.Games()))
+	}
+
+	cp, err := checkpoints.Get(ctx, lichessorg, "u")
+	if err != nil || cp != nil {
+		t.Errorf("checkpoint = %+v, %v, want none after a dry run", cp, err)
+	}
+}
+
+// decodingProvider transforms the raw payload, the ID of a game, into the game again
+type decodingProvider struct {
+	*MemoryGameProvider
+}
+
+func (p decodingProvider) Decode(raw []byte) (*Game, error) {
+	return &Game{ID: string(raw), Source: lichessorg, UserID: "u"}, nil
+}
+
+func TestArchiverRunKeepsCountingAttemptsAfterTransform(t *testing.T) {
+	ctx := context.Background()
+	deadLetters := NewFileDeadLetterStore(filepath.Join(t.TempDir(), "dead-letters.jsonl"))
+	failing := &failingProcessor{games: map[string]bool{"g1": true}}
+	acc := &Account{
+		Name:        "u",
+		Providers:   []GameProvider{decodingProvider{NewMemoryGameProvider(lichessorg, "u", 7)}},
+		Checkpoints: NewMemoryCheckpointStore(),
+		DeadLetters: deadLetters,
+		Processors:  []Processor{failing},
+	}
+
+	_ = deadLetters.Save(ctx, &DeadLetter{Source: lichessorg, UserID: "u", GameID: "g1", Stage: stageTransform, Attempts: 2, Raw: "g1"})
+
+	cfg := &config.Config{}
+	cfg.DeadLetter.MaxAttempts = 5
+
+	_, err := newTestArchiver(cfg, acc).Run(ctx, RunSpec{})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	dls, err := deadLetters.List(ctx, lichessorg, "u")
+	if err != nil || len(dls) != 1 || dls[0].Stage != failing.Name() || dls[0].Attempts != 3 {
+		t.Errorf("dead letters = %+v, %v, want the processor failure after three attempts", dls, err)
+	}
+}
+
+// blockingProcessor holds every game until it is released and counts the games it holds at the same time
+type blockingProcessor struct {
+	mu       sync.Mutex
+	inFlight int
+	max      int
+	entered  chan struct{}
+	release  chan struct{}
+}
+
+func (p *blockingProcessor) Name() string {
+	return "blocking"
+}
+
+func (p *blockingProcessor) Process(ctx context.Context, _ *Game) error {
+	p.mu.Lock()
+	p.inFlight++
+	if p.inFlight > p.max {
+		p.max = p.inFlight
+	}
+	p.mu.Unlock()
+
+	p.entered <- struct{}{}
+
+	select {
+	case <-p.release:
+	case <-ctx.Done():
+	}
+
+	p.mu.Lock()
+	p.inFlight--
+	p.mu.Unlock()
+
+	return nil
+}
+
+func TestArchiverRunCapsTheProcessorConcurrency(t *testing.T) {
+	p := &blockingProcessor{entered: make(chan struct{}, 100), release: make(chan struct{})}
+
+	var accounts []*Account
+
+	//the cap is shared by the accounts, each of them has more workers than the cap
+	for _, userID := range []string{"alice", "bob"} {
+		accounts = append(accounts, &Account{
+			Name:        userID,
+			Providers:   []GameProvider{NewMemoryGameProvider(lichessorg, userID, 10, lichessGames(userID, 20)...)},
+			Checkpoints: NewMemoryCheckpointStore(),
+			Processors:  []Processor{p},
+		})
+	}
+
+	cfg := &config.Config{}
+	cfg.Archiver.MaxInFlight = 5
+	cfg.Archiver.Concurrency = []string{"blocking:2"}
+
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := newTestArchiver(cfg, accounts...).Run(context.Background(), RunSpec{})
+		done <- err
+	}()
+
+	for i := 0; i < 2; i++ {
+		<-p.entered
+	}
+
+	//the other workers have the time to get past the cap if it did not hold
+	time.Sleep(50 * time.Millisecond)
+
+	p.mu.Lock()
+	held := p.inFlight
+	p.mu.Unlock()
+
+	if held != 2 {
+		t.Errorf("games held by the processor = %d, want 2", held)
+	}
+
+	close(p.release)
+
+	err := <-done
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if p.max != 2 {
+		t.Errorf("most games processed at the same time = %d, want 2", p.max)
+	}
+}
@@ -0,0 +1,177 @@
+package chessarchive
+
+import (
+	"chess-archive/pkg/retry"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxBatchSize is the most writes Firestore accepts in a batch
+const maxBatchSize = 500
+
+// Flusher is implemented by the processors buffering the games, the archiver flushes them once the run of an account ends
+type Flusher interface {
+	//Flush writes the buffered games at once
+	Flush(ctx context.Context) error
+}
+
+type batchedGame struct {
+	game *Game
+	done chan error
+}
+
+// firestoreBatcher buffers the games of the concurrent Process calls and commits them together.
+// Every call waits for the commit of its own game, so the archiver still gets the outcome of every game
+// and the checkpoint never moves past a game which is not written yet.
+// The batches are committed with a context of their own, a caller giving up does not fail the games of the others.
+type firestoreBatcher struct {
+	processor *DataStoreProcessor
+	size      int
+	flush     time.Duration
+
+	mu      sync.Mutex
+	pending []*batchedGame
+	timer   *time.Timer
+}
+
+func newFirestoreBatcher(processor *DataStoreProcessor, size int, flush time.Duration) *firestoreBatcher {
+	if size > maxBatchSize {
+		size = maxBatchSize
+	}
+
+	return &firestoreBatcher{processor: processor, size: size, flush: flush}
+}
+
+// write buffers the game and waits until its batch is committed, the batch is committed once it is full
+// or once its first game has waited for the flush interval. A caller cancelled before the commit of its batch
+// takes its game out of the buffer, once the commit started the caller waits for its outcome.
+func (b *firestoreBatcher) write(ctx context.Context, g *Game) error {
+	bg := &batchedGame{game: g, done: make(chan error, 1)}
+
+	var full []*batchedGame
+
+	b.mu.Lock()
+	b.pending = append(b.pending, bg)
+
+	switch {
+	case len(b.pending) >= b.size:
+		full = b.take()
+	case b.timer == nil:
+		b.timer = time.AfterFunc(b.flush, func() {
+			_ = b.Flush(context.Background())
+		})
+	}
+	b.mu.Unlock()
+
+	if full != nil {
+		//the retry policy bounds the commit, the callers of the batch do not
+		_ = b.commit(context.Background(), full)
+	}
+
+	select {
+	case err := <-bg.done:
+		return errors.WithStack(err)
+	case <-ctx.Done():
+		if b.remove(bg) {
+			return errors.WithStack(ctx.Err())
+		}
+
+		return errors.WithStack(<-bg.done)
+	}
+}
+
+// remove takes the game out of the buffer, false when its batch is already committed
+func (b *firestoreBatcher) remove(bg *batchedGame) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, pending := range b.pending {
+		if pending == bg {
+			b.pending = append(b.pending[:i], b.pending[i+1:]...)
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// Flush commits the buffered games, the error of the first failed game is returned
+func (b *firestoreBatcher) Flush(ctx context.Context) error {
+	b.mu.Lock()
+	games := b.take()
+	b.mu.Unlock()
+
+	return errors.WithStack(b.commit(ctx, games))
+}
+
+// take empties the buffer, the caller holds the lock
+func (b *firestoreBatcher) take() []*batchedGame {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+
+	games := b.pending
+	b.pending = nil
+
+	return games
+}
+
+// commit writes the games in batches of up to maxBatchSize and hands every game its outcome.
+// A batch is written as a whole, so the games of a batch which can not be written are written one by one
+// and a single invalid game does not fail the others.
+func (b *firestoreBatcher) commit(ctx context.Context, games []*batchedGame) error {
+	var first error
+
+	for start := 0; start < len(games); start += maxBatchSize {
+		end := start + maxBatchSize
+		if end > len(games) {
+			end = len(games)
+		}
+
+		chunk := games[start:end]
+
+		err := b.processor.retry.Do(ctx, func(ctx context.Context) error {
+			batch := b.processor.datastoreClient.Batch()
+
+			for _, bg := range chunk {
+				batch.Set(collection(b.processor.datastoreClient, b.processor.namespace, "games").Doc(gameKey(bg.game)), bg.game)
+			}
+
+			_, err := batch.Commit(ctx)
+
+			return err
+		})
+
+		if err != nil && len(chunk) > 1 && ctx.Err() == nil && !retry.IsRetryable(err) {
+			b.processor.logger.WithError(err).Warnf("DataStoreProcessor batch of %d games failed, writing them one by one", len(chunk))
+
+			for _, bg := range chunk {
+				err := b.processor.set(ctx, bg.game)
+				if err != nil && first == nil {
+					first = err
+				}
+
+				bg.done <- err
+			}
+
+			continue
+		}
+
+		if err == nil {
+			b.processor.logger.Debugf("DataStoreProcessor committed a batch of %d games", len(chunk))
+		} else if first == nil {
+			first = err
+		}
+
+		for _, bg := range chunk {
+			bg.done <- err
+		}
+	}
+
+	return first
+}
@@ -0,0 +1,60 @@
+package chessarchive
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestFirestoreBatcherWriteCancelledBeforeCommit(t *testing.T) {
+	b := newFirestoreBatcher(nil, 10, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := b.write(ctx, &Game{ID: "g1", Source: lichessorg, UserID: "u"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("write() error = %v, want %v", err, context.Canceled)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if games := b.take(); len(games) != 0 {
+		t.Errorf("pending games = %d, want the cancelled game out of the batch", len(games))
+	}
+}
+
+func TestFirestoreBatcherCommitsAFullBatch(t *testing.T) {
+	f, client := newFakeFirestore(t)
+	processor := newTestDataStoreProcessor(client, "").WithBatching(3, time.Hour)
+
+	errs := make(chan error, 3)
+
+	for _, g := range lichessGames("u", 3) {
+		go func(g *Game) {
+			errs <- processor.Process(context.Background(), g)
+		}(g)
+	}
+
+	//the flush interval is never reached, the third game fills up the batch
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Errorf("Process() error = %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Process() is blocked, want the full batch committed")
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.commits != 1 || len(f.docs) != 3 {
+		t.Errorf("commits = %d with %d games, want the 3 games in 1 commit", f.commits, len(f.docs))
+	}
+}
@@ -0,0 +1,213 @@
+package board
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const StartFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+
+var pieceLetters = map[PieceType]byte{
+	Pawn:   'p',
+	Knight: 'n',
+	Bishop: 'b',
+	Rook:   'r',
+	Queen:  'q',
+	King:   'k',
+}
+
+func pieceFromLetter(c byte) (Piece, bool) {
+	color := Black
+
+	if c >= 'A' && c <= 'Z' {
+		color = White
+		c += 'a' - 'A'
+	}
+
+	for pt, l := range pieceLetters {
+		if l == c {
+			return Piece{Type: pt, Color: color}, true
+		}
+	}
+
+	return Piece{}, false
+}
+
+// ParseFEN reads the position from the Forsyth-Edwards Notation, the move counters are optional
+func ParseFEN(fen string) (*Position, error) {
+	fields := strings.Fields(fen)
+	if len(fields) < 4 {
+		return nil, errors.Errorf("invalid FEN %q: expected at least 4 fields", fen)
+	}
+
+	p := &Position{enPassant: NoSquare, fullMoves: 1}
+
+	ranks := strings.Split(fields[0], "/")
+	if len(ranks) != 8 {
+		return nil, errors.Errorf("invalid FEN %q: expected 8 ranks", fen)
+	}
+
+	for i, row := range ranks {
+		rank, file := 7-i, 0
+
+		for j := 0; j < len(row); j++ {
+			c := row[j]
+
+			if c >= '1' && c <= '8' {
+				file += int(c - '0')
+
+				continue
+			}
+
+			piece, ok := pieceFromLetter(c)
+			if !ok || file > 7 {
+				return nil, errors.Errorf("invalid FEN %q: bad rank %q", fen, row)
+			}
+
+			p.board[NewSquare(file, rank)] = piece
+			file++
+		}
+
+		if file != 8 {
+			return nil, errors.Errorf("invalid FEN %q: bad rank %q", fen, row)
+		}
+	}
+
+	switch fields[1] {
+	case "w":
+		p.turn = White
+	case "b":
+		p.turn = Black
+	default:
+		return nil, errors.Errorf("invalid FEN %q: bad side to move", fen)
+	}
+
+	if fields[2] != "-" {
+		for _, c := range fields[2] {
+			switch c {
+			case 'K':
+				p.castling |= castleWhiteKing
+			case 'Q':
+				p.castling |= castleWhiteQueen
+			case 'k':
+				p.castling |= castleBlackKing
+			case 'q':
+				p.castling |= castleBlackQueen
+			default:
+				return nil, errors.Errorf("invalid FEN %q: bad castling rights", fen)
+			}
+		}
+	}
+
+	if fields[3] != "-" {
+		sq, ok := parseSquare(fields[3])
+		if !ok {
+			return nil, errors.Errorf("invalid FEN %q: bad en passant square", fen)
+		}
+
+		p.enPassant = sq
+	}
+
+	var err error
+
+	if len(fields) > 4 {
+		p.halfMoves, err = strconv.Atoi(fields[4])
+		if err != nil {
+			return nil, errors.Errorf("invalid FEN %q: bad halfmove clock", fen)
+		}
+	}
+
+	if len(fields) > 5 {
+		p.fullMoves, err = strconv.Atoi(fields[5])
+		if err != nil {
+			return nil, errors.Errorf("invalid FEN %q: bad fullmove number", fen)
+		}
+	}
+
+	if p.king(White) == NoSquare || p.king(Black) == NoSquare {
+		return nil, errors.Errorf("invalid FEN %q: both kings are required", fen)
+	}
+
+	return p, nil
+}
+
+// FEN returns the position in the Forsyth-Edwards Notation
+func (p *Position) FEN() string {
+	var sb strings.Builder
+
+	for rank := 7; rank >= 0; rank-- {
+		empty := 0
+
+		for file := 0; file < 8; file++ {
+			piece := p.board[NewSquare(file, rank)]
+			if piece.Type == NoPieceType {
+				empty++
+
+				continue
+			}
+
+			if empty > 0 {
+				sb.WriteByte(byte('0' + empty))
+				empty = 0
+			}
+
+			l := pieceLetters[piece.Type]
+			if piece.Color == White {
+				l -= 'a' - 'A'
+			}
+
+			sb.WriteByte(l)
+		}
+
+		if empty > 0 {
+			sb.WriteByte(byte('0' + empty))
+		}
+
+		if rank > 0 {
+			sb.WriteByte('/')
+		}
+	}
+
+	if p.turn == White {
+		sb.WriteString(" w ")
+	} else {
+		sb.WriteString(" b ")
+	}
+
+	castling := ""
+
+	for _, c := range []struct {
+		right  uint8
+		letter string
+	}{
+		{castleWhiteKing, "K"},
+		{castleWhiteQueen, "Q"},
+		{castleBlackKing, "k"},
+		{castleBlackQueen, "q"},
+	} {
+		if p.castling&c.right != 0 {
+			castling += c.letter
+		}
+	}
+
+	if castling == "" {
+		castling = "-"
+	}
+
+	sb.WriteString(castling)
+	sb.WriteByte(' ')
+	sb.WriteString(p.enPassant.String())
+	sb.WriteString(" " + strconv.Itoa(p.halfMoves) + " " + strconv.Itoa(p.fullMoves))
+
+	return sb.String()
+}
+
+func parseSquare(s string) (Square, bool) {
+	if len(s) != 2 || s[0] < 'a' || s[0] > 'h' || s[1] < '1' || s[1] > '8' {
+		return NoSquare, false
+	}
+
+	return NewSquare(int(s[0]-'a'), int(s[1]-'1')), true
+}
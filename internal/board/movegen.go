@@ -0,0 +1,232 @@
+package board
+
+var (
+	knightSteps = [8][2]int{{1, 2}, {2, 1}, {2, -1}, {1, -2}, {-1, -2}, {-2, -1}, {-2, 1}, {-1, 2}}
+	kingSteps   = [8][2]int{{1, 0}, {1, 1}, {0, 1}, {-1, 1}, {-1, 0}, {-1, -1}, {0, -1}, {1, -1}}
+	bishopRays  = [4][2]int{{1, 1}, {1, -1}, {-1, 1}, {-1, -1}}
+	rookRays    = [4][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}}
+	promotions  = [4]PieceType{Queen, Rook, Bishop, Knight}
+)
+
+// offset returns the square shifted by the file and rank deltas, NoSquare when it leaves the board
+func offset(sq Square, df, dr int) Square {
+	f, r := sq.File()+df, sq.Rank()+dr
+	if f < 0 || f > 7 || r < 0 || r > 7 {
+		return NoSquare
+	}
+
+	return NewSquare(f, r)
+}
+
+// LegalMoves returns every legal move of the side to move
+func (p *Position) LegalMoves() []Move {
+	pseudo := p.pseudoLegalMoves()
+	legal := pseudo[:0]
+
+	for _, m := range pseudo {
+		next := p.Play(m)
+		king := next.king(p.turn)
+
+		if king != NoSquare && next.attacked(king, next.turn) {
+			continue
+		}
+
+		legal = append(legal, m)
+	}
+
+	return legal
+}
+
+func (p *Position) pseudoLegalMoves() []Move {
+	moves := make([]Move, 0, 48)
+
+	for sq := Square(0); sq < 64; sq++ {
+		piece := p.board[sq]
+		if piece.Type == NoPieceType || piece.Color != p.turn {
+			continue
+		}
+
+		switch piece.Type {
+		case Pawn:
+			moves = p.pawnMoves(moves, sq)
+		case Knight:
+			moves = p.stepMoves(moves, sq, knightSteps[:])
+		case Bishop:
+			moves = p.rayMoves(moves, sq, bishopRays[:])
+		case Rook:
+			moves = p.rayMoves(moves, sq, rookRays[:])
+		case Queen:
+			moves = p.rayMoves(moves, sq, bishopRays[:])
+			moves = p.rayMoves(moves, sq, rookRays[:])
+		case King:
+			moves = p.stepMoves(moves, sq, kingSteps[:])
+			moves = p.castlingMoves(moves, sq)
+		}
+	}
+
+	return moves
+}
+
+func (p *Position) pawnMoves(moves []Move, from Square) []Move {
+	dir, startRank, lastRank := 1, 1, 7
+	if p.turn == Black {
+		dir, startRank, lastRank = -1, 6, 0
+	}
+
+	add := func(to Square) {
+		if to.Rank() != lastRank {
+			moves = append(moves, Move{From: from, To: to})
+
+			return
+		}
+
+		for _, promo := range promotions {
+			moves = append(moves, Move{From: from, To: to, Promotion: promo})
+		}
+	}
+
+	if one := offset(from, 0, dir); one != NoSquare && p.board[one].Type == NoPieceType {
+		add(one)
+
+		if two := offset(from, 0, 2*dir); from.Rank() == startRank && p.board[two].Type == NoPieceType {
+			add(two)
+		}
+	}
+
+	for _, df := range [2]int{-1, 1} {
+		to := offset(from, df, dir)
+		if to == NoSquare {
+			continue
+		}
+
+		target := p.board[to]
+		if (target.Type != NoPieceType && target.Color != p.turn) || to == p.enPassant {
+			add(to)
+		}
+	}
+
+	return moves
+}
+
+func (p *Position) stepMoves(moves []Move, from Square, steps [][2]int) []Move {
+	for _, s := range steps {
+		to := offset(from, s[0], s[1])
+		if to == NoSquare {
+			continue
+		}
+
+		if target := p.board[to]; target.Type == NoPieceType || target.Color != p.turn {
+			moves = append(moves, Move{From: from, To: to})
+		}
+	}
+
+	return moves
+}
+
+func (p *Position) rayMoves(moves []Move, from Square, rays [][2]int) []Move {
+	for _, r := range rays {
+		for to := offset(from, r[0], r[1]); to != NoSquare; to = offset(to, r[0], r[1]) {
+			target := p.board[to]
+
+			if target.Type == NoPieceType {
+				moves = append(moves, Move{From: from, To: to})
+
+				continue
+			}
+
+			if target.Color != p.turn {
+				moves = append(moves, Move{From: from, To: to})
+			}
+
+			break
+		}
+	}
+
+	return moves
+}
+
+// castlingMoves generates the standard chess castling, the king may not leave, cross or land on an attacked square
+func (p *Position) castlingMoves(moves []Move, from Square) []Move {
+	rank, kingSide, queenSide := 0, castleWhiteKing, castleWhiteQueen
+	if p.turn == Black {
+		rank, kingSide, queenSide = 7, castleBlackKing, castleBlackQueen
+	}
+
+	if from != NewSquare(4, rank) || p.attacked(from, p.turn.Other()) {
+		return moves
+	}
+
+	rook := Piece{Type: Rook, Color: p.turn}
+
+	if p.castling&kingSide != 0 && p.board[NewSquare(7, rank)] == rook &&
+		p.empty(rank, 5, 6) && !p.attacked(NewSquare(5, rank), p.turn.Other()) {
+		moves = append(moves, Move{From: from, To: NewSquare(6, rank)})
+	}
+
+	if p.castling&queenSide != 0 && p.board[NewSquare(0, rank)] == rook &&
+		p.empty(rank, 1, 3) && !p.attacked(NewSquare(3, rank), p.turn.Other()) {
+		moves = append(moves, Move{From: from, To: NewSquare(2, rank)})
+	}
+
+	return moves
+}
+
+func (p *Position) empty(rank, fromFile, toFile int) bool {
+	for f := fromFile; f <= toFile; f++ {
+		if p.board[NewSquare(f, rank)].Type != NoPieceType {
+			return false
+		}
+	}
+
+	return true
+}
+
+// attacked reports whether any piece of the color attacks the square
+func (p *Position) attacked(sq Square, by Color) bool {
+	pawnRank := -1
+	if by == Black {
+		pawnRank = 1
+	}
+
+	for _, df := range [2]int{-1, 1} {
+		if from := offset(sq, df, pawnRank); from != NoSquare && p.board[from] == (Piece{Type: Pawn, Color: by}) {
+			return true
+		}
+	}
+
+	if p.attackedByStep(sq, by, knightSteps[:], Knight) || p.attackedByStep(sq, by, kingSteps[:], King) {
+		return true
+	}
+
+	return p.attackedByRay(sq, by, bishopRays[:], Bishop) || p.attackedByRay(sq, by, rookRays[:], Rook)
+}
+
+func (p *Position) attackedByStep(sq Square, by Color, steps [][2]int, pt PieceType) bool {
+	for _, s := range steps {
+		if from := offset(sq, s[0], s[1]); from != NoSquare && p.board[from] == (Piece{Type: pt, Color: by}) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// attackedByRay checks the sliding pieces of the type and the queens
+func (p *Position) attackedByRay(sq Square, by Color, rays [][2]int, pt PieceType) bool {
+	for _, r := range rays {
+		for from := offset(sq, r[0], r[1]); from != NoSquare; from = offset(from, r[0], r[1]) {
+			piece := p.board[from]
+			if piece.Type == NoPieceType {
+				continue
+			}
+
+			if piece.Color == by && (piece.Type == pt || piece.Type == Queen) {
+				return true
+			}
+
+			break
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,48 @@
+package board
+
+import "testing"
+
+func perft(p *Position, depth int) int {
+	if depth == 0 {
+		return 1
+	}
+
+	nodes := 0
+
+	for _, m := range p.LegalMoves() {
+		nodes += perft(p.Play(m), depth-1)
+	}
+
+	return nodes
+}
+
+// the perft positions and counts are the ones of the Chess Programming Wiki
+func TestPerft(t *testing.T) {
+	tests := []struct {
+		name  string
+		fen   string
+		depth int
+		nodes int
+	}{
+		{"initial", StartFEN, 4, 197281},
+		{"kiwipete", "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1", 3, 97862},
+		{"en passant", "8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1", 4, 43238},
+		{"promotions", "r3k2r/Pppp1ppp/1b3nbN/nP6/BBP1P3/q4N2/Pp1P2PP/R2Q1RK1 w kq - 0 1", 3, 9467},
+		{"discovered checks", "rnbq1k1r/pp1Pbppp/2p5/8/2B5/8/PPP1NnPP/RNBQK2R w KQ - 1 8", 3, 62379},
+	}
+
+	for _, tt := range tests {
+		p, err := ParseFEN(tt.fen)
+		if err != nil {
+			t.Fatalf("%s: ParseFEN() error = %v", tt.name, err)
+		}
+
+		if got := p.FEN(); got != tt.fen {
+			t.Errorf("%s: FEN() = %s, want %s", tt.name, got, tt.fen)
+		}
+
+		if got := perft(p, tt.depth); got != tt.nodes {
+			t.Errorf("%s: perft(%d) = %d, want %d", tt.name, tt.depth, got, tt.nodes)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package board
+
+type Color int8
+
+const (
+	White Color = iota
+	Black
+)
+
+func (c Color) Other() Color {
+	return c ^ 1
+}
+
+type PieceType int8
+
+const (
+	NoPieceType PieceType = iota
+	Pawn
+	Knight
+	Bishop
+	Rook
+	Queen
+	King
+)
+
+// Piece is a colored piece, the zero value is an empty square
+type Piece struct {
+	Type  PieceType
+	Color Color
+}
+
+// Square is an index from 0 (a1) to 63 (h8)
+type Square int8
+
+const NoSquare Square = -1
+
+func NewSquare(file, rank int) Square {
+	return Square(rank*8 + file)
+}
+
+func (s Square) File() int {
+	return int(s) % 8
+}
+
+func (s Square) Rank() int {
+	return int(s) / 8
+}
+
+func (s Square) String() string {
+	if s == NoSquare {
+		return "-"
+	}
+
+	return string([]byte{byte('a' + s.File()), byte('1' + s.Rank())})
+}
+
+const (
+	castleWhiteKing uint8 = 1 << iota
+	castleWhiteQueen
+	castleBlackKing
+	castleBlackQueen
+)
+
+type Move struct {
+	From      Square
+	To        Square
+	Promotion PieceType
+}
+
+// Position is a value type, Play returns a new position and leaves the receiver untouched
+type Position struct {
+	board     [64]Piece
+	turn      Color
+	castling  uint8
+	enPassant Square
+	halfMoves int
+	fullMoves int
+}
+
+// NewPosition returns the standard starting position
+func NewPosition() *Position {
+	p, _ := ParseFEN(StartFEN)
+
+	return p
+}
+
+func (p *Position) Turn() Color {
+	return p.turn
+}
+
+func (p *Position) At(sq Square) Piece {
+	return p.board[sq]
+}
+
+// Play returns the position after the move, the move is expected to be legal
+func (p *Position) Play(m Move) *Position {
+	next := *p
+	piece := next.board[m.From]
+	captured := next.board[m.To]
+
+	next.board[m.From] = Piece{}
+	next.board[m.To] = piece
+
+	switch piece.Type {
+	case Pawn:
+		//en passant capture removes the pawn behind the target square
+		if m.To == p.enPassant && m.From.File() != m.To.File() {
+			next.board[NewSquare(m.To.File(), m.From.Rank())] = Piece{}
+			captured = Piece{Type: Pawn, Color: piece.Color.Other()}
+		}
+
+		if m.Promotion != NoPieceType {
+			next.board[m.To] = Piece{Type: m.Promotion, Color: piece.Color}
+		}
+	case King:
+		//castling moves the rook over the king
+		if d := m.To.File() - m.From.File(); d == 2 || d == -2 {
+			rank := m.From.Rank()
+			rookFrom, rookTo := NewSquare(7, rank), NewSquare(5, rank)
+
+			if d < 0 {
+				rookFrom, rookTo = NewSquare(0, rank), NewSquare(3, rank)
+			}
+
+			next.board[rookTo] = next.board[rookFrom]
+			next.board[rookFrom] = Piece{}
+		}
+	}
+
+	next.castling &^= castlingLost(m.From) | castlingLost(m.To)
+
+	next.enPassant = NoSquare
+	if piece.Type == Pawn && (m.To.Rank()-m.From.Rank() == 2 || m.From.Rank()-m.To.Rank() == 2) {
+		next.enPassant = NewSquare(m.From.File(), (m.From.Rank()+m.To.Rank())/2)
+	}
+
+	next.halfMoves++
+	if piece.Type == Pawn || captured.Type != NoPieceType {
+		next.halfMoves = 0
+	}
+
+	if p.turn == Black {
+		next.fullMoves++
+	}
+
+	next.turn = p.turn.Other()
+
+	return &next
+}
+
+// castlingLost returns the castling rights lost when a piece moves from or to the square
+func castlingLost(sq Square) uint8 {
+	switch sq {
+	case NewSquare(4, 0):
+		return castleWhiteKing | castleWhiteQueen
+	case NewSquare(7, 0):
+		return castleWhiteKing
+	case NewSquare(0, 0):
+		return castleWhiteQueen
+	case NewSquare(4, 7):
+		return castleBlackKing | castleBlackQueen
+	case NewSquare(7, 7):
+		return castleBlackKing
+	case NewSquare(0, 7):
+		return castleBlackQueen
+	default:
+		return 0
+	}
+}
+
+// InCheck reports whether the side to move is in check
+func (p *Position) InCheck() bool {
+	king := p.king(p.turn)
+
+	return king != NoSquare && p.attacked(king, p.turn.Other())
+}
+
+// IsCheckmate reports whether the side to move is checkmated
+func (p *Position) IsCheckmate() bool {
+	return p.InCheck() && len(p.LegalMoves()) == 0
+}
+
+// IsStalemate reports whether the side to move has no legal move while not in check
+func (p *Position) IsStalemate() bool {
+	return !p.InCheck() && len(p.LegalMoves()) == 0
+}
+
+func (p *Position) king(c Color) Square {
+	for sq := Square(0); sq < 64; sq++ {
+		if p.board[sq] == (Piece{Type: King, Color: c}) {
+			return sq
+		}
+	}
+
+	return NoSquare
+}
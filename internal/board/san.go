@@ -0,0 +1,194 @@
+package board
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var sanPieces = map[byte]PieceType{
+	'N': Knight,
+	'B': Bishop,
+	'R': Rook,
+	'Q': Queen,
+	'K': King,
+}
+
+// ParseSAN finds the legal move written in the Standard Algebraic Notation,
+// the castling may be written with zeros as well, e.g. 0-0-0
+func (p *Position) ParseSAN(san string) (Move, error) {
+	s := strings.TrimRight(san, "+#!?")
+
+	if s == "O-O" || s == "O-O-O" || s == "0-0" || s == "0-0-0" {
+		return p.parseCastling(san, len(s) == len("O-O-O"))
+	}
+
+	if s == "" {
+		return Move{}, errors.Errorf("malformed move %q", san)
+	}
+
+	pt := Pawn
+
+	if s[0] == 'P' {
+		s = s[1:]
+	} else if t, ok := sanPieces[s[0]]; ok {
+		pt = t
+		s = s[1:]
+	}
+
+	promotion := NoPieceType
+
+	if i := strings.IndexByte(s, '='); i >= 0 && i+1 < len(s) {
+		promotion = sanPieces[s[i+1]]
+		s = s[:i]
+	} else if len(s) > 0 && pt == Pawn {
+		if t, ok := sanPieces[s[len(s)-1]]; ok {
+			promotion = t
+			s = s[:len(s)-1]
+		}
+	}
+
+	s = strings.NewReplacer("x", "", "-", "", ":", "").Replace(s)
+
+	if len(s) < 2 || len(s) > 4 {
+		return Move{}, errors.Errorf("malformed move %q", san)
+	}
+
+	to, ok := parseSquare(s[len(s)-2:])
+	if !ok {
+		return Move{}, errors.Errorf("malformed move %q", san)
+	}
+
+	fromFile, fromRank := -1, -1
+
+	for i := 0; i < len(s)-2; i++ {
+		switch c := s[i]; {
+		case c >= 'a' && c <= 'h':
+			fromFile = int(c - 'a')
+		case c >= '1' && c <= '8':
+			fromRank = int(c - '1')
+		default:
+			return Move{}, errors.Errorf("malformed move %q", san)
+		}
+	}
+
+	var found []Move
+
+	for _, m := range p.LegalMoves() {
+		if m.To != to || m.Promotion != promotion || p.board[m.From].Type != pt {
+			continue
+		}
+
+		if (fromFile >= 0 && m.From.File() != fromFile) || (fromRank >= 0 && m.From.Rank() != fromRank) {
+			continue
+		}
+
+		found = append(found, m)
+	}
+
+	switch len(found) {
+	case 0:
+		return Move{}, errors.Errorf("illegal move %s in %s", san, p.FEN())
+	case 1:
+		return found[0], nil
+	default:
+		return Move{}, errors.Errorf("ambiguous move %s in %s", san, p.FEN())
+	}
+}
+
+func (p *Position) parseCastling(san string, queenSide bool) (Move, error) {
+	king := p.king(p.turn)
+	file := 6
+
+	if queenSide {
+		file = 2
+	}
+
+	for _, m := range p.LegalMoves() {
+		if m.From == king && m.To == NewSquare(file, king.Rank()) && king.File() == 4 {
+			return m, nil
+		}
+	}
+
+	return Move{}, errors.Errorf("illegal move %s in %s", san, p.FEN())
+}
+
+// SAN returns the move in the Standard Algebraic Notation, including the check and mate markers
+func (p *Position) SAN(m Move) string {
+	var sb strings.Builder
+
+	piece := p.board[m.From]
+	capture := p.board[m.To].Type != NoPieceType || (piece.Type == Pawn && m.To == p.enPassant)
+
+	switch {
+	case piece.Type == King && m.To.File()-m.From.File() == 2:
+		sb.WriteString("O-O")
+	case piece.Type == King && m.From.File()-m.To.File() == 2:
+		sb.WriteString("O-O-O")
+	case piece.Type == Pawn:
+		if capture {
+			sb.WriteByte(byte('a' + m.From.File()))
+			sb.WriteByte('x')
+		}
+
+		sb.WriteString(m.To.String())
+
+		if m.Promotion != NoPieceType {
+			sb.WriteByte('=')
+			sb.WriteByte(pieceLetters[m.Promotion] - ('a' - 'A'))
+		}
+	default:
+		sb.WriteByte(pieceLetters[piece.Type] - ('a' - 'A'))
+		sb.WriteString(p.disambiguation(m))
+
+		if capture {
+			sb.WriteByte('x')
+		}
+
+		sb.WriteString(m.To.String())
+	}
+
+	next := p.Play(m)
+
+	switch {
+	case next.IsCheckmate():
+		sb.WriteByte('#')
+	case next.InCheck():
+		sb.WriteByte('+')
+	}
+
+	return sb.String()
+}
+
+// disambiguation returns the origin file, rank or square needed when another piece of the type can reach the target
+func (p *Position) disambiguation(m Move) string {
+	piece := p.board[m.From]
+	sameFile, sameRank, ambiguous := false, false, false
+
+	for _, other := range p.LegalMoves() {
+		if other.From == m.From || other.To != m.To || p.board[other.From] != piece {
+			continue
+		}
+
+		ambiguous = true
+
+		if other.From.File() == m.From.File() {
+			sameFile = true
+		}
+
+		if other.From.Rank() == m.From.Rank() {
+			sameRank = true
+		}
+	}
+
+	switch {
+	case !ambiguous:
+		return ""
+	case !sameFile:
+		return m.From.String()[:1]
+	case !sameRank:
+		return m.From.String()[1:]
+	default:
+		return m.From.String()
+	}
+}
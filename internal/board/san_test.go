@@ -0,0 +1,72 @@
+package board
+
+import "testing"
+
+func play(t *testing.T, p *Position, sans ...string) *Position {
+	t.Helper()
+
+	for _, san := range sans {
+		m, err := p.ParseSAN(san)
+		if err != nil {
+			t.Fatalf("ParseSAN(%s) error = %v", san, err)
+		}
+
+		if got := p.SAN(m); got != san {
+			t.Errorf("SAN() = %s, want %s", got, san)
+		}
+
+		p = p.Play(m)
+	}
+
+	return p
+}
+
+func TestSANRoundTrip(t *testing.T) {
+	p := play(t, NewPosition(),
+		"e4", "e5", "Nf3", "Nc6", "Bc4", "Nf6", "Ng5", "d5", "exd5", "Nxd5",
+		"Nxf7", "Kxf7", "Qf3+", "Ke6", "Nc3", "Nb4", "O-O", "c6", "d4", "Kd7",
+	)
+
+	if want := "r1bq1b1r/pp1k2pp/2p5/3np3/1nBP4/2N2Q2/PPP2PPP/R1B2RK1 w - - 1 11"; p.FEN() != want {
+		t.Errorf("FEN() = %s, want %s", p.FEN(), want)
+	}
+}
+
+func TestSANCheckmate(t *testing.T) {
+	p := play(t, NewPosition(), "f3", "e5", "g4", "Qh4#")
+
+	if !p.IsCheckmate() {
+		t.Error("IsCheckmate() = false after the fool's mate")
+	}
+}
+
+func TestParseSANRejectsIllegalMoves(t *testing.T) {
+	p := NewPosition()
+
+	for _, san := range []string{"e5", "Ke2", "Nd4", "O-O", "Nbd2", "xyz"} {
+		_, err := p.ParseSAN(san)
+		if err == nil {
+			t.Errorf("ParseSAN(%s) error = nil, want an error", san)
+		}
+	}
+}
+
+func TestParseSANCastlingWithZeros(t *testing.T) {
+	p, err := ParseFEN("r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1")
+	if err != nil {
+		t.Fatalf("ParseFEN() error = %v", err)
+	}
+
+	for san, want := range map[string]string{"0-0": "O-O", "0-0-0": "O-O-O", "O-O": "O-O", "O-O-O": "O-O-O"} {
+		m, err := p.ParseSAN(san)
+		if err != nil {
+			t.Errorf("ParseSAN(%s) error = %v", san, err)
+
+			continue
+		}
+
+		if got := p.SAN(m); got != want {
+			t.Errorf("SAN() of %s = %s, want %s", san, got, want)
+		}
+	}
+}
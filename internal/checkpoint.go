@@ -0,0 +1,173 @@
+package chessarchive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Checkpoint is the newest game of the user on the source which was acknowledged by every processor
+type Checkpoint struct {
+	Source    Source    `firestore:"source" json:"source"`
+	UserID    string    `firestore:"user_id" json:"user_id"`
+	PlayedAt  int64     `firestore:"played_at" json:"played_at"` //milliseconds
+	GameID    string    `firestore:"game_id" json:"game_id"`
+	UpdatedAt time.Time `firestore:"updated_at" json:"updated_at"`
+}
+
+func checkpointKey(source Source, userID string) string {
+	return fmt.Sprintf("%s_%s", source, userID)
+}
+
+type CheckpointStore interface {
+	//Get returns the checkpoint of the user on the source, nil if there is none yet
+	Get(ctx context.Context, source Source, userID string) (*Checkpoint, error)
+
+	//Save creates or replaces the checkpoint of the user on the source
+	Save(ctx context.Context, cp *Checkpoint) error
+}
+
+type DataStoreCheckpointStore struct {
+	datastoreClient *firestore.Client
+	namespace       string
+}
+
+func NewDataStoreCheckpointStore(datastoreClient *firestore.Client, namespace string) *DataStoreCheckpointStore {
+	return &DataStoreCheckpointStore{datastoreClient: datastoreClient, namespace: namespace}
+}
+
+func (ds *DataStoreCheckpointStore) Get(ctx context.Context, source Source, userID string) (*Checkpoint, error) {
+	var cp Checkpoint
+
+	doc, err := collection(ds.datastoreClient, ds.namespace, "checkpoints").Doc(checkpointKey(source, userID)).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, nil
+		}
+
+		return nil, errors.WithStack(err)
+	}
+
+	err = doc.DataTo(&cp)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &cp, nil
+}
+
+func (ds *DataStoreCheckpointStore) Save(ctx context.Context, cp *Checkpoint) error {
+	_, err := collection(ds.datastoreClient, ds.namespace, "checkpoints").Doc(checkpointKey(cp.Source, cp.UserID)).Set(ctx, cp)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// FileCheckpointStore keeps all checkpoints in a single JSON file, the file is replaced atomically on every save
+type FileCheckpointStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileCheckpointStore(path string) *FileCheckpointStore {
+	return &FileCheckpointStore{path: path}
+}
+
+func (fs *FileCheckpointStore) Get(_ context.Context, source Source, userID string) (*Checkpoint, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	list, err := fs.read()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return list[checkpointKey(source, userID)], nil
+}
+
+func (fs *FileCheckpointStore) Save(_ context.Context, cp *Checkpoint) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	list, err := fs.read()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	list[checkpointKey(cp.Source, cp.UserID)] = cp
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(writeFileAtomic(fs.path, data))
+}
+
+func (fs *FileCheckpointStore) read() (map[string]*Checkpoint, error) {
+	list := map[string]*Checkpoint{}
+
+	data, err := ioutil.ReadFile(fs.path)
+	if os.IsNotExist(err) {
+		return list, nil
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		return nil, errors.Wrapf(err, "corrupted checkpoint file %s", fs.path)
+	}
+
+	return list, nil
+}
+
+// checkpointer persists the cursor position of a single provider, older positions never overwrite newer ones
+type checkpointer struct {
+	mu     sync.Mutex
+	store  CheckpointStore
+	source Source
+	userID string
+	saved  uint64 //sequence number following the last saved position
+}
+
+func newCheckpointer(store CheckpointStore, source Source, userID string) *checkpointer {
+	return &checkpointer{store: store, source: source, userID: userID}
+}
+
+func (c *checkpointer) save(ctx context.Context, seq uint64, g *Game) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if seq < c.saved {
+		return nil
+	}
+
+	err := c.store.Save(ctx, &Checkpoint{
+		Source:    c.source,
+		UserID:    c.userID,
+		PlayedAt:  g.PlayedAt,
+		GameID:    g.ID,
+		UpdatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	c.saved = seq + 1
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package chessarchive
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestFileCheckpointStoreConcurrentSaves(t *testing.T) {
+	ctx := context.Background()
+	store := NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoints.json"))
+
+	var wg sync.WaitGroup
+
+	//every account saves its checkpoints to the shared file at the same time
+	for u := 0; u < 5; u++ {
+		wg.Add(1)
+
+		go func(userID string) {
+			defer wg.Done()
+
+			for i := 1; i <= 20; i++ {
+				err := store.Save(ctx, &Checkpoint{Source: lichessorg, UserID: userID, PlayedAt: int64(i), GameID: fmt.Sprint(i)})
+				if err != nil {
+					t.Errorf("Save(%s) error = %v", userID, err)
+
+					return
+				}
+			}
+		}(fmt.Sprint("user", u))
+	}
+
+	wg.Wait()
+
+	for u := 0; u < 5; u++ {
+		userID := fmt.Sprint("user", u)
+
+		cp, err := store.Get(ctx, lichessorg, userID)
+		if err != nil || cp == nil || cp.GameID != "20" {
+			t.Errorf("Get(%s) = %+v, %v, want the last checkpoint of the user", userID, cp, err)
+		}
+	}
+}
+
+func TestFileCheckpointStoreReload(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+
+	for _, cp := range []*Checkpoint{
+		{Source: lichessorg, UserID: "alice", PlayedAt: 1000, GameID: "l1"},
+		{Source: chessdotcom, UserID: "alice", PlayedAt: 2000, GameID: "c1"},
+	} {
+		err := NewFileCheckpointStore(path).Save(ctx, cp)
+		if err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+
+	//a new store reads the file written before the restart
+	store := NewFileCheckpointStore(path)
+
+	for _, want := range []struct {
+		source Source
+		gameID string
+	}{{lichessorg, "l1"}, {chessdotcom, "c1"}} {
+		cp, err := store.Get(ctx, want.source, "alice")
+		if err != nil || cp == nil || cp.GameID != want.gameID {
+			t.Errorf("Get(%s) = %+v, %v, want game %s", want.source, cp, err, want.gameID)
+		}
+	}
+
+	cp, err := store.Get(ctx, lichessorg, "bob")
+	if err != nil || cp != nil {
+		t.Errorf("Get(bob) = %+v, %v, want none", cp, err)
+	}
+}
@@ -0,0 +1,179 @@
+package chessarchive
+
+import (
+	"bytes"
+	"chess-archive/config"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Command is the JSON payload of the Pub/Sub message triggering a run, the empty payload runs the scheduled sync of every user
+type Command struct {
+	User       string     `json:"user"` //name of the user, every user when empty
+	Since      *time.Time `json:"since"`
+	Until      *time.Time `json:"until"`
+	MaxGames   int        `json:"max_games"`
+	PerfTypes  []string   `json:"perf_types"`
+	Rated      *bool      `json:"rated"`
+	Opponent   string     `json:"opponent"`
+	Processors []string   `json:"processors"` //the PROCESSORS setting when empty
+	DryRun     bool       `json:"dry_run"`
+	GameID     string     `json:"game_id"` //re-archive only this game
+	Source     string     `json:"source"`  //lichess or chesscom, the source of the game to re-archive
+}
+
+// CommandError rejects a payload which is not a valid command
+type CommandError struct {
+	Reason string
+}
+
+func (e *CommandError) Error() string {
+	return "invalid command: " + e.Reason
+}
+
+func invalidCommand(format string, args ...interface{}) error {
+	return &CommandError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// ParseCommand decodes the payload, the unknown fields and the inconsistent settings are rejected
+func ParseCommand(data []byte) (*Command, error) {
+	var c Command
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		return &c, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&c)
+	if err != nil {
+		return nil, invalidCommand("%s", err)
+	}
+
+	if dec.More() {
+		return nil, invalidCommand("unexpected data after the command")
+	}
+
+	err = c.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func (c *Command) validate() error {
+	if c.MaxGames < 0 {
+		return invalidCommand("max_games should not be negative")
+	}
+
+	if c.Since != nil && c.Until != nil && !c.Until.After(*c.Since) {
+		return invalidCommand("until should be after since")
+	}
+
+	for _, p := range c.Processors {
+		switch p {
+		case config.ProcessorDrive, config.ProcessorFirestore, config.ProcessorLocal, config.ProcessorSQLite:
+		default:
+			return invalidCommand("unknown processor %q", p)
+		}
+	}
+
+	if c.Source != "" {
+		if c.GameID == "" {
+			return invalidCommand("source is only used with game_id")
+		}
+
+		if _, err := ParseSource(c.Source); err != nil {
+			return invalidCommand("%s", err)
+		}
+	}
+
+	if c.GameID != "" && c.narrowed() {
+		return invalidCommand("game_id can not be combined with the time window or the filters")
+	}
+
+	return nil
+}
+
+func (c *Command) narrowed() bool {
+	return c.Since != nil || c.Until != nil || c.MaxGames > 0 || len(c.PerfTypes) > 0 || c.Rated != nil || c.Opponent != ""
+}
+
+// Apply returns a copy of the config running the user, the processors and the dry run of the command
+func (c *Command) Apply(cfg *config.Config) (*config.Config, error) {
+	applied := *cfg
+
+	if c.User != "" {
+		err := applied.SelectUsers([]string{c.User})
+		if err != nil {
+			return nil, invalidCommand("%s", err)
+		}
+	}
+
+	if len(c.Processors) > 0 {
+		applied.Archiver.Processors = c.Processors
+
+		err := applied.ValidateBatching()
+		if err != nil {
+			return nil, invalidCommand("%s", err)
+		}
+	}
+
+	if c.DryRun {
+		applied.Archiver.DryRun = true
+	}
+
+	return &applied, nil
+}
+
+// Spec returns the run specification of the command, the RUN_* settings apply to the fields the command leaves empty
+func (c *Command) Spec(cfg *config.Config) (RunSpec, error) {
+	spec, err := NewRunSpec(cfg)
+	if err != nil {
+		return spec, errors.WithStack(err)
+	}
+
+	if c.GameID != "" {
+		spec = RunSpec{GameID: c.GameID}
+
+		if c.Source != "" {
+			spec.Source, err = ParseSource(c.Source)
+			if err != nil {
+				return spec, invalidCommand("%s", err)
+			}
+		}
+
+		return spec, nil
+	}
+
+	if c.Since != nil {
+		spec.Since = *c.Since
+	}
+
+	if c.Until != nil {
+		spec.Until = *c.Until
+	}
+
+	if c.MaxGames > 0 {
+		spec.MaxGames = c.MaxGames
+	}
+
+	if len(c.PerfTypes) > 0 {
+		spec.PerfTypes = c.PerfTypes
+	}
+
+	if c.Rated != nil {
+		spec.Rated = c.Rated
+	}
+
+	if c.Opponent != "" {
+		spec.Opponent = c.Opponent
+	}
+
+	return spec, nil
+}
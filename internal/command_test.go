@@ -0,0 +1,110 @@
+package chessarchive
+
+import (
+	"chess-archive/config"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestParseCommand(t *testing.T) {
+	valid := []string{
+		``,
+		`{}`,
+		`{"user":"a","since":"2021-01-01T00:00:00Z","until":"2021-02-01T00:00:00Z","dry_run":true}`,
+		`{"game_id":"x","source":"chesscom"}`,
+		`{"processors":["drive","sqlite"],"perf_types":["blitz"],"rated":true}`,
+	}
+
+	for _, in := range valid {
+		_, err := ParseCommand([]byte(in))
+		if err != nil {
+			t.Errorf("ParseCommand(%s) error = %v", in, err)
+		}
+	}
+
+	invalid := []string{
+		`{"bogus":1}`,
+		`{"game_id":"x","since":"2021-01-01T00:00:00Z"}`,
+		`{"source":"lichess"}`,
+		`{"game_id":"x","source":"foo"}`,
+		`{"processors":["bad"]}`,
+		`{"since":"2021-02-01T00:00:00Z","until":"2021-01-01T00:00:00Z"}`,
+		`{} {}`,
+		`[1]`,
+		`{"max_games":-1}`,
+	}
+
+	for _, in := range invalid {
+		_, err := ParseCommand([]byte(in))
+
+		var commandErr *CommandError
+		if !errors.As(err, &commandErr) {
+			t.Errorf("ParseCommand(%s) error = %v, want a CommandError", in, err)
+		}
+	}
+}
+
+func TestCommandSpec(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Run.MaxGames = 10
+	cfg.Run.PerfTypes = []string{"rapid"}
+
+	c, err := ParseCommand([]byte(`{"since":"2021-01-01T00:00:00Z","perf_types":["blitz"]}`))
+	if err != nil {
+		t.Fatalf("ParseCommand() error = %v", err)
+	}
+
+	spec, err := c.Spec(cfg)
+	if err != nil {
+		t.Fatalf("Spec() error = %v", err)
+	}
+
+	if !spec.Since.Equal(time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)) || spec.MaxGames != 10 || spec.PerfTypes[0] != "blitz" {
+		t.Errorf("Spec() = %+v, want the command settings over the RUN_* ones", spec)
+	}
+
+	c, err = ParseCommand([]byte(`{"game_id":"x","source":"chesscom"}`))
+	if err != nil {
+		t.Fatalf("ParseCommand() error = %v", err)
+	}
+
+	spec, err = c.Spec(cfg)
+	if err != nil || spec.GameID != "x" || spec.Source != chessdotcom || spec.MaxGames != 0 {
+		t.Errorf("Spec() = %+v, %v, want game x of chess.com only", spec, err)
+	}
+}
+
+func TestArchiverRunRearchivesGame(t *testing.T) {
+	ctx := context.Background()
+	games := lichessGames("u", 3)
+
+	for _, g := range games {
+		g.Players.White.ID = "u"
+	}
+
+	store := NewMemoryGameStore()
+	acc := &Account{
+		Name:        "u",
+		Providers:   []GameProvider{NewMemoryGameProvider(lichessorg, "u", 0, games...)},
+		Checkpoints: NewMemoryCheckpointStore(),
+		Processors:  []Processor{store},
+	}
+	a := newTestArchiver(&config.Config{}, acc)
+
+	_, err := a.Run(ctx, RunSpec{GameID: "1"})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if games := store.Games(); len(games) != 1 || games[0].ID != "1" {
+		t.Errorf("stored games = %+v, want game 1 only", games)
+	}
+
+	_, err = a.Run(ctx, RunSpec{GameID: "unknown"})
+	if err == nil {
+		t.Error("Run() of an unknown game error = nil, want an error")
+	}
+}
@@ -0,0 +1,61 @@
+package chessarchive
+
+import "sync"
+
+// cursor tracks the newest game of a source which was committed together with every game streamed before it.
+// Games are committed out of order, so the position only moves over a contiguous run of committed games.
+type cursor struct {
+	mu      sync.Mutex
+	next    uint64
+	last    uint64
+	pending map[uint64]*Game
+	current *Game
+}
+
+func newCursor() *cursor {
+	return &cursor{pending: map[uint64]*Game{}}
+}
+
+// add registers the next streamed game and returns its sequence number
+func (c *cursor) add() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seq := c.last
+	c.last++
+
+	return seq
+}
+
+// commit marks the game as processed and reports whether the cursor position has advanced,
+// the newest committed game is returned together with its sequence number
+func (c *cursor) commit(seq uint64, g *Game) (*Game, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pending[seq] = g
+
+	advanced := false
+
+	for {
+		game, ok := c.pending[c.next]
+		if !ok {
+			break
+		}
+
+		delete(c.pending, c.next)
+		c.next++
+		c.current = game
+		advanced = true
+	}
+
+	return c.current, c.next - 1, advanced
+}
+
+// position returns the newest committed game, nil if nothing was committed yet
+func (c *cursor) position() *Game {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.current
+}
@@ -0,0 +1,122 @@
+package chessarchive
+
+import (
+	"chess-archive/config"
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestCursorCommitAdvancesOverContiguousGames(t *testing.T) {
+	c := newCursor()
+	games := lichessGames("u", 4)
+
+	for range games {
+		c.add()
+	}
+
+	//the games complete out of order: 2, 1, 0, 3
+	steps := []struct {
+		seq      uint64
+		advanced bool
+		position string
+	}{
+		{2, false, ""},
+		{1, false, ""},
+		{0, true, "2"},
+		{3, true, "3"},
+	}
+
+	for _, step := range steps {
+		pos, posSeq, advanced := c.commit(step.seq, games[step.seq])
+		if advanced != step.advanced {
+			t.Errorf("commit(%d) advanced = %v, want %v", step.seq, advanced, step.advanced)
+		}
+
+		if step.position == "" {
+			if pos != nil {
+				t.Errorf("commit(%d) position = %s, want none", step.seq, pos.ID)
+			}
+
+			continue
+		}
+
+		if pos == nil || pos.ID != step.position || games[posSeq].ID != step.position {
+			t.Errorf("commit(%d) position = %v (%d), want game %s", step.seq, pos, posSeq, step.position)
+		}
+	}
+}
+
+func TestCursorStopsAtAFailedGame(t *testing.T) {
+	c := newCursor()
+	games := lichessGames("u", 4)
+
+	for range games {
+		c.add()
+	}
+
+	//game 1 failed and is never committed
+	for _, seq := range []uint64{0, 2, 3} {
+		c.commit(seq, games[seq])
+	}
+
+	if pos := c.position(); pos == nil || pos.ID != "0" {
+		t.Errorf("position() = %v, want game 0 before the failed game", pos)
+	}
+}
+
+func TestCheckpointerKeepsTheNewestPosition(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryCheckpointStore()
+	cp := newCheckpointer(store, lichessorg, "u")
+	games := lichessGames("u", 3)
+
+	//the save of the position 2 finishes before the save of the position 1
+	for _, seq := range []uint64{2, 1} {
+		err := cp.save(ctx, seq, games[seq])
+		if err != nil {
+			t.Fatalf("save(%d) error = %v", seq, err)
+		}
+	}
+
+	saved, _ := store.Get(ctx, lichessorg, "u")
+	if saved == nil || saved.GameID != "2" {
+		t.Errorf("saved checkpoint = %+v, want game 2", saved)
+	}
+}
+
+// unwritableDeadLetters fails to record the failed games
+type unwritableDeadLetters struct {
+	*MemoryDeadLetterStore
+}
+
+func (s unwritableDeadLetters) Save(context.Context, *DeadLetter) error {
+	return errors.New("dead letters are unavailable")
+}
+
+func TestArchiverRunStopsTheCheckpointAtAnUnrecordedFailure(t *testing.T) {
+	ctx := context.Background()
+	checkpoints := NewMemoryCheckpointStore()
+	acc := &Account{
+		Name:        "u",
+		Providers:   []GameProvider{NewMemoryGameProvider(lichessorg, "u", 7, lichessGames("u", 10)...)},
+		Checkpoints: checkpoints,
+		DeadLetters: unwritableDeadLetters{NewMemoryDeadLetterStore()},
+		Processors:  []Processor{&failingProcessor{games: map[string]bool{"5": true}}},
+	}
+
+	cfg := &config.Config{}
+	cfg.Archiver.MaxInFlight = 1
+
+	_, err := newTestArchiver(cfg, acc).Run(ctx, RunSpec{})
+	if err == nil {
+		t.Fatalf("Run() error = nil, want the failure of game 5")
+	}
+
+	//game 5 is neither archived nor in the dead letters, the next run has to start with it
+	cp, err := checkpoints.Get(ctx, lichessorg, "u")
+	if err != nil || cp == nil || cp.GameID != "4" {
+		t.Errorf("checkpoint = %+v, %v, want game 4", cp, err)
+	}
+}
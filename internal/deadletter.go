@@ -0,0 +1,228 @@
+package chessarchive
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/pkg/errors"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// stageTransform is the dead letter stage of the games which could not be transformed, the other stages are processor names
+const stageTransform = "transform"
+
+// DeadLetter is a game which failed to be transformed or to be handed to a processor, it is retried on the next runs
+type DeadLetter struct {
+	Source   Source    `firestore:"source" json:"source"`
+	UserID   string    `firestore:"user_id" json:"user_id"`
+	GameID   string    `firestore:"game_id" json:"game_id"`
+	Stage    string    `firestore:"stage" json:"stage"` //transform or the processor name
+	Error    string    `firestore:"error" json:"error"`
+	Attempts int       `firestore:"attempts" json:"attempts"`
+	FailedAt time.Time `firestore:"failed_at" json:"failed_at"`
+	Game     *Game     `firestore:"game,omitempty" json:"game,omitempty"` //set for the processor failures
+	Raw      string    `firestore:"raw,omitempty" json:"raw,omitempty"`   //source payload, set for the transform failures
+}
+
+func (dl *DeadLetter) key() string {
+	return fmt.Sprintf("%s_%s_%s_%s", dl.Source, dl.UserID, dl.GameID, dl.Stage)
+}
+
+type DeadLetterStore interface {
+	//List returns the dead letters of the user on the source
+	List(ctx context.Context, source Source, userID string) ([]*DeadLetter, error)
+
+	//Save creates or replaces the dead letter
+	Save(ctx context.Context, dl *DeadLetter) error
+
+	//Delete removes the dead letter, deleting a missing one is not an error
+	Delete(ctx context.Context, dl *DeadLetter) error
+}
+
+type DataStoreDeadLetterStore struct {
+	datastoreClient *firestore.Client
+	namespace       string
+}
+
+func NewDataStoreDeadLetterStore(datastoreClient *firestore.Client, namespace string) *DataStoreDeadLetterStore {
+	return &DataStoreDeadLetterStore{datastoreClient: datastoreClient, namespace: namespace}
+}
+
+func (ds *DataStoreDeadLetterStore) List(ctx context.Context, source Source, userID string) ([]*DeadLetter, error) {
+	var list []*DeadLetter
+
+	iter := collection(ds.datastoreClient, ds.namespace, "dead_letters").
+		Where("source", "==", source).
+		Where("user_id", "==", userID).
+		Documents(ctx)
+	defer iter.Stop()
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return list, nil
+		}
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		var dl DeadLetter
+
+		err = doc.DataTo(&dl)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		list = append(list, &dl)
+	}
+}
+
+func (ds *DataStoreDeadLetterStore) Save(ctx context.Context, dl *DeadLetter) error {
+	_, err := collection(ds.datastoreClient, ds.namespace, "dead_letters").Doc(dl.key()).Set(ctx, dl)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (ds *DataStoreDeadLetterStore) Delete(ctx context.Context, dl *DeadLetter) error {
+	_, err := collection(ds.datastoreClient, ds.namespace, "dead_letters").Doc(dl.key()).Delete(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// FileDeadLetterStore keeps the dead letters in a JSONL file, one dead letter per line.
+// The file is replaced atomically on every change.
+type FileDeadLetterStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileDeadLetterStore(path string) *FileDeadLetterStore {
+	return &FileDeadLetterStore{path: path}
+}
+
+func (fs *FileDeadLetterStore) List(_ context.Context, source Source, userID string) ([]*DeadLetter, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	all, err := fs.read()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var list []*DeadLetter
+
+	for _, dl := range all {
+		if dl.Source == source && dl.UserID == userID {
+			list = append(list, dl)
+		}
+	}
+
+	return list, nil
+}
+
+func (fs *FileDeadLetterStore) Save(_ context.Context, dl *DeadLetter) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	all, err := fs.read()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	all[dl.key()] = dl
+
+	return errors.WithStack(fs.write(all))
+}
+
+func (fs *FileDeadLetterStore) Delete(_ context.Context, dl *DeadLetter) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	all, err := fs.read()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if _, ok := all[dl.key()]; !ok {
+		return nil
+	}
+
+	delete(all, dl.key())
+
+	return errors.WithStack(fs.write(all))
+}
+
+func (fs *FileDeadLetterStore) read() (map[string]*DeadLetter, error) {
+	all := map[string]*DeadLetter{}
+
+	data, err := ioutil.ReadFile(fs.path)
+	if os.IsNotExist(err) {
+		return all, nil
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	//a line holds a whole game
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var dl DeadLetter
+
+		err = json.Unmarshal(scanner.Bytes(), &dl)
+		if err != nil {
+			return nil, errors.Wrapf(err, "corrupted dead letter file %s at line %d", fs.path, line)
+		}
+
+		all[dl.key()] = &dl
+	}
+
+	return all, errors.WithStack(scanner.Err())
+}
+
+func (fs *FileDeadLetterStore) write(all map[string]*DeadLetter) error {
+	keys := make([]string, 0, len(all))
+	for k := range all {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+
+	for _, k := range keys {
+		line, err := json.Marshal(all[k])
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	return errors.WithStack(writeFileAtomic(fs.path, buf.Bytes()))
+}
@@ -0,0 +1,457 @@
+package chessarchive
+
+import (
+	"chess-archive/config"
+	"chess-archive/pkg/google/drive"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// fakeDrive is an in-memory Drive, the files and the folders are kept flat with their parents
+type fakeDrive struct {
+	mu       sync.Mutex
+	next     int
+	files    map[string]*drive.File
+	folders  map[string]bool
+	contents map[string]string
+	lists    int //listing calls, every one of them goes through the rate limit of Drive
+}
+
+func newFakeDrive(folders ...string) *fakeDrive {
+	d := &fakeDrive{files: map[string]*drive.File{}, folders: map[string]bool{}, contents: map[string]string{}}
+
+	for _, id := range folders {
+		d.files[id] = &drive.File{ID: id, Name: id}
+		d.folders[id] = true
+	}
+
+	return d
+}
+
+func (d *fakeDrive) copy(f *drive.File) *drive.File {
+	c := *f
+	c.Media = nil
+	c.Tags = map[string]string{}
+	c.Parents = append([]string(nil), f.Parents...)
+
+	for k, v := range f.Tags {
+		c.Tags[k] = v
+	}
+
+	return &c
+}
+
+// list returns the copies of the files matching the filter, newest first as Drive orders them
+func (d *fakeDrive) list(match func(f *drive.File) bool) []*drive.File {
+	var list []*drive.File
+
+	for id, f := range d.files {
+		if !d.folders[id] && match(f) {
+			list = append(list, d.copy(f))
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].UploadedAt.After(*list[j].UploadedAt)
+	})
+
+	return list
+}
+
+func (d *fakeDrive) Get(_ context.Context, id string) (*drive.File, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f, ok := d.files[id]
+	if !ok {
+		return nil, errors.Errorf("file %s not found", id)
+	}
+
+	return d.copy(f), nil
+}
+
+func (d *fakeDrive) Files(_ context.Context, ids []string) ([]*drive.File, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.list(func(f *drive.File) bool { return stringInSlice(f.ID, ids) }), nil
+}
+
+func (d *fakeDrive) FilesFromFolder(_ context.Context, folderID string, recursively bool) ([]*drive.File, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.lists++
+
+	return d.list(func(f *drive.File) bool {
+		for _, parentID := range f.Parents {
+			if parentID == folderID || (recursively && d.below(parentID, folderID)) {
+				return true
+			}
+		}
+
+		return false
+	}), nil
+}
+
+func (d *fakeDrive) below(folderID, rootID string) bool {
+	for folderID != "" {
+		if folderID == rootID {
+			return true
+		}
+
+		f := d.files[folderID]
+		if f == nil || len(f.Parents) == 0 {
+			return false
+		}
+
+		folderID = f.Parents[0]
+	}
+
+	return false
+}
+
+func (d *fakeDrive) Download(_ context.Context, id string) (io.ReadCloser, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	content, ok := d.contents[id]
+	if !ok {
+		return nil, errors.Errorf("file %s not found", id)
+	}
+
+	return ioutil.NopCloser(strings.NewReader(content)), nil
+}
+
+func (d *fakeDrive) Open(ctx context.Context, id string) (*drive.File, error) {
+	f, err := d.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	f.Media, err = d.Download(ctx, id)
+
+	return f, err
+}
+
+func (d *fakeDrive) Latest(ctx context.Context, folderID string) (*drive.File, error) {
+	files, err := d.FilesFromFolder(ctx, folderID, true)
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+
+	return files[0], nil
+}
+
+func (d *fakeDrive) Folders(_ context.Context) ([]*drive.File, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var folders []*drive.File
+
+	for id := range d.folders {
+		folders = append(folders, d.copy(d.files[id]))
+	}
+
+	return folders, nil
+}
+
+func (d *fakeDrive) SubFolders(_ context.Context, dirID string) ([]*drive.File, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var folders []*drive.File
+
+	for id := range d.folders {
+		if stringInSlice(dirID, d.files[id].Parents) {
+			folders = append(folders, d.copy(d.files[id]))
+		}
+	}
+
+	return folders, nil
+}
+
+func (d *fakeDrive) CreateFolder(_ context.Context, parentID, name string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.next++
+	id := fmt.Sprintf("folder-%d", d.next)
+	d.files[id] = &drive.File{ID: id, Name: name, Parents: []string{parentID}}
+	d.folders[id] = true
+
+	return id, nil
+}
+
+func (d *fakeDrive) Move(_ context.Context, id, folderID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f, ok := d.files[id]
+	if !ok {
+		return errors.Errorf("file %s not found", id)
+	}
+
+	f.Parents = []string{folderID}
+
+	return nil
+}
+
+func (d *fakeDrive) Create(_ context.Context, folder string, file *drive.File) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.next++
+	id := fmt.Sprintf("file-%d", d.next)
+	uploadedAt := time.Unix(int64(d.next), 0)
+
+	f := d.copy(file)
+	f.ID = id
+	f.Parents = []string{folder}
+	f.UploadedAt = &uploadedAt
+
+	return id, d.write(f, file)
+}
+
+func (d *fakeDrive) Update(_ context.Context, id string, file *drive.File) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	existing, ok := d.files[id]
+	if !ok {
+		return errors.Errorf("file %s not found", id)
+	}
+
+	f := d.copy(file)
+	f.ID = id
+	f.Parents = existing.Parents
+	f.UploadedAt = existing.UploadedAt
+
+	return d.write(f, file)
+}
+
+func (d *fakeDrive) write(f, file *drive.File) error {
+	content, err := ioutil.ReadAll(file.Media)
+	if err != nil {
+		return err
+	}
+
+	f.Checksum = fmt.Sprintf("%x", md5.Sum(content))
+	d.files[f.ID] = f
+	d.contents[f.ID] = string(content)
+
+	return nil
+}
+
+func (d *fakeDrive) FindByTag(ctx context.Context, key, value string) ([]*drive.File, error) {
+	return d.FindByTags(ctx, map[string]string{key: value})
+}
+
+func (d *fakeDrive) FindByTags(_ context.Context, tags map[string]string) ([]*drive.File, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.lists++
+
+	return d.list(func(f *drive.File) bool {
+		for key, value := range tags {
+			if f.Tag(key) != value {
+				return false
+			}
+		}
+
+		return true
+	}), nil
+}
+
+func (d *fakeDrive) FindByName(_ context.Context, folderID, name string) ([]*drive.File, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.lists++
+
+	return d.list(func(f *drive.File) bool { return f.Name == name && stringInSlice(folderID, f.Parents) }), nil
+}
+
+func newTestDriveProcessor(d *fakeDrive, folderID, layout, userID string) *GDriveStoreProcessor {
+	logger, _ := test.NewNullLogger()
+
+	l, _ := ParseLayout(layout)
+
+	return NewDriveStoreProcessor(folderID, l, d, NewFileTransformer(userID, ""), logger)
+}
+
+// driveGame is the lichess game of the user, archived by its PGN
+func driveGame(id, userID string) *Game {
+	g := &Game{
+		ID:       id,
+		Source:   lichessorg,
+		UserID:   userID,
+		PlayedAt: time.Date(2021, time.March, 4, 10, 11, 12, 0, time.UTC).UnixNano() / int64(time.Millisecond),
+		PGN: fmt.Sprintf("[Site \"https://lichess.org/%s\"]\n[White \"Alice\"]\n[Black \"Bob\"]\n[Result \"1-0\"]\n"+
+			"[UTCDate \"2021.03.04\"]\n[UTCTime \"10:11:12\"]\n\n1. e4 e5 1-0\n", id),
+		Winner: "white",
+	}
+	g.Players.White = Player{ID: "alice", Name: "Alice"}
+	g.Players.Black = Player{ID: "bob", Name: "Bob"}
+
+	return g
+}
+
+func TestGDriveStoreProcessorKeepsTheFilesOfEveryUser(t *testing.T) {
+	ctx := context.Background()
+	d := newFakeDrive("shared", "bob")
+	alice := newTestDriveProcessor(d, "shared", "{year}", "alice")
+	bobShared := newTestDriveProcessor(d, "shared", "{year}", "bob")
+	bobOwn := newTestDriveProcessor(d, "bob", "", "bob")
+
+	for _, step := range []struct {
+		processor *GDriveStoreProcessor
+		userID    string
+	}{{alice, "alice"}, {bobShared, "bob"}, {bobOwn, "bob"}, {alice, "alice"}} {
+		err := step.processor.Process(ctx, driveGame("g1", step.userID))
+		if err != nil {
+			t.Fatalf("Process() error = %v", err)
+		}
+	}
+
+	files, _ := d.FindByTag(ctx, gameIDTag, "g1")
+	if len(files) != 3 {
+		t.Fatalf("archived files = %d, want one per user and archive folder", len(files))
+	}
+
+	owners := map[string]int{}
+
+	for _, f := range files {
+		owners[f.Tag(userTag)]++
+	}
+
+	if owners["alice"] != 1 || owners["bob"] != 2 {
+		t.Errorf("owners of the files = %v, want alice once and bob twice", owners)
+	}
+
+	own, _ := d.FilesFromFolder(ctx, "bob", false)
+	if len(own) != 1 || own[0].Tag(userTag) != "bob" {
+		t.Errorf("files of the bob folder = %+v, want the file of bob", own)
+	}
+}
+
+func TestGDriveStoreProcessorAdoptsLegacyFiles(t *testing.T) {
+	ctx := context.Background()
+	d := newFakeDrive("archive")
+	p := newTestDriveProcessor(d, "archive", "{year}", "alice")
+	g := driveGame("g1", "alice")
+
+	//a file tagged with the game ID only, before the source and user tags were introduced
+	legacy, _ := p.transformer.TransformToFile(g)
+	delete(legacy.Tags, sourceTag)
+	delete(legacy.Tags, userTag)
+
+	legacyID, _ := d.Create(ctx, "archive", legacy)
+
+	err := p.Process(ctx, g)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	files, _ := d.FindByTag(ctx, gameIDTag, "g1")
+	if len(files) != 1 || files[0].ID != legacyID || files[0].Tag(userTag) != "alice" || files[0].Parents[0] == "archive" {
+		t.Errorf("archived files = %+v, want the legacy file tagged and moved into the layout folder", files)
+	}
+}
+
+func TestGDriveStoreProcessorListsTheLegacyFilesOnce(t *testing.T) {
+	ctx := context.Background()
+	d := newFakeDrive("archive")
+	p := newTestDriveProcessor(d, "archive", "", "alice")
+
+	//a file uploaded flat before tagging
+	legacy, _ := p.transformer.TransformToFile(driveGame("g0", "alice"))
+	legacy.Tags = nil
+
+	legacyID, _ := d.Create(ctx, "archive", legacy)
+
+	d.lists = 0
+
+	for i := 0; i < 5; i++ {
+		err := p.Process(ctx, driveGame(fmt.Sprint("g", i), "alice"))
+		if err != nil {
+			t.Fatalf("Process() error = %v", err)
+		}
+	}
+
+	//one lookup by the tags per game and one listing of the archive
+	if d.lists != 6 {
+		t.Errorf("listing calls = %d, want 6", d.lists)
+	}
+
+	files, _ := d.FindByTag(ctx, gameIDTag, "g0")
+	if len(files) != 1 || files[0].ID != legacyID {
+		t.Errorf("archived files of g0 = %+v, want the legacy file adopted", files)
+	}
+}
+
+func TestGDriveGameStorageLastReadsTheNewestLegacyFileOfTheLayout(t *testing.T) {
+	ctx := context.Background()
+	d := newFakeDrive("archive")
+	files := NewFileTransformer("alice", "")
+
+	year, _ := d.CreateFolder(ctx, "archive", "2021")
+
+	for _, step := range []struct {
+		folderID string
+		gameID   string
+	}{{"archive", "g1"}, {year, "g2"}} {
+		//files uploaded before the tags were introduced
+		f, _ := files.TransformToFile(driveGame(step.gameID, "alice"))
+		f.Tags = nil
+
+		_, _ = d.Create(ctx, step.folderID, f)
+	}
+
+	g, err := NewDriveGameStorage("archive", files, d).Last(ctx, lichessorg, "alice")
+	if err != nil {
+		t.Fatalf("Last() error = %v", err)
+	}
+
+	if g == nil || g.ID != "g2" {
+		t.Errorf("Last() = %+v, want game g2 of the year folder", g)
+	}
+}
+
+func TestNewGameStorageFallsBackToDrive(t *testing.T) {
+	logger, _ := test.NewNullLogger()
+	user := config.User{Name: "u", LichessUserID: "u", ArchiveFolderID: "root"}
+	sqlite := NewMemoryGameStore()
+
+	tests := []struct {
+		processors []string
+		want       string
+	}{
+		{[]string{config.ProcessorDrive, config.ProcessorFirestore}, "*chessarchive.DataStoreGameStorage"},
+		{[]string{config.ProcessorDrive, config.ProcessorSQLite}, "*chessarchive.MemoryGameStore"},
+		{[]string{config.ProcessorDrive}, "*chessarchive.GDriveGameStorage"},
+		{[]string{config.ProcessorLocal}, "<nil>"},
+	}
+
+	for _, tt := range tests {
+		cfg := &config.Config{}
+		cfg.Archiver.Processors = tt.processors
+
+		storage := NewGameStorage(cfg, user, logger, NewFileTransformer("u", ""), nil, newFakeDrive("root"), sqlite)
+		if got := fmt.Sprintf("%T", storage); got != tt.want {
+			t.Errorf("NewGameStorage(%v) = %s, want %s", tt.processors, got, tt.want)
+		}
+	}
+}
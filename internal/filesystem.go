@@ -0,0 +1,319 @@
+package chessarchive
+
+import (
+	"chess-archive/config"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// FileModeGame writes every game into its own PGN file
+	FileModeGame = "game"
+	// FileModeMonthly appends the games into one PGN file per month
+	FileModeMonthly = "monthly"
+
+	// FileNamingName names the files the same way as the Drive archive, see Game.Name
+	FileNamingName = "name"
+	// FileNamingID names the files by the game ID
+	FileNamingID = "id"
+)
+
+// FileSystemProcessor archives the games into a local directory tree.
+// Every PGN file gets a JSON sidecar with the structured game, all files are replaced atomically.
+type FileSystemProcessor struct {
+	mu     sync.Mutex
+	dir    string
+	layout *Layout
+	mode   string
+	naming string
+	logger logrus.FieldLogger
+}
+
+func NewFileSystemProcessor(
+	dir string,
+	layout *Layout,
+	mode string,
+	naming string,
+	logger logrus.FieldLogger,
+) (*FileSystemProcessor, error) {
+	if mode != FileModeGame && mode != FileModeMonthly {
+		return nil, errors.Errorf("unknown local archive mode %q", mode)
+	}
+
+	if naming != FileNamingName && naming != FileNamingID {
+		return nil, errors.Errorf("unknown local archive naming %q", naming)
+	}
+
+	return &FileSystemProcessor{
+		dir:    dir,
+		layout: layout,
+		mode:   mode,
+		naming: naming,
+		logger: logger,
+	}, nil
+}
+
+func (p *FileSystemProcessor) Name() string {
+	return config.ProcessorLocal
+}
+
+func (p *FileSystemProcessor) Process(_ context.Context, g *Game) error {
+	p.logger.Debugf("FileSystemProcessor process game ID: %s", g.ID)
+
+	if p.mode == FileModeMonthly {
+		return errors.WithStack(p.processMonthly(g))
+	}
+
+	base := filepath.Join(p.folder(g), p.fileName(g))
+
+	data, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = writeFileAtomic(base+".pgn", []byte(g.PGN))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(writeFileAtomic(base+".json", data))
+}
+
+func (p *FileSystemProcessor) Plan(_ context.Context, g *Game) (Action, error) {
+	if p.mode == FileModeMonthly {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		games, err := p.readMonthly(p.monthlyBase(g))
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+
+		for _, stored := range games {
+			if stored.ID != g.ID || stored.Source != g.Source {
+				continue
+			}
+
+			if stored.Equal(g) {
+				return ActionSkip, nil
+			}
+
+			return ActionUpdate, nil
+		}
+
+		return ActionCreate, nil
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(p.folder(g), p.fileName(g)) + ".json")
+	if os.IsNotExist(err) {
+		return ActionCreate, nil
+	}
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	var stored Game
+
+	err = json.Unmarshal(data, &stored)
+	if err != nil || !stored.Equal(g) {
+		return ActionUpdate, nil
+	}
+
+	return ActionSkip, nil
+}
+
+// ListGames reads the games back from the JSON sidecars
+func (p *FileSystemProcessor) ListGames(ctx context.Context, source Source, userID string) (GameIterator, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var games []*Game
+
+	err := filepath.Walk(p.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		var stored []*Game
+
+		if p.mode == FileModeMonthly {
+			err = json.Unmarshal(data, &stored)
+		} else {
+			var g Game
+			err = json.Unmarshal(data, &g)
+			stored = append(stored, &g)
+		}
+
+		if err != nil {
+			return errors.Wrapf(err, "corrupted sidecar %s", path)
+		}
+
+		for _, g := range stored {
+			if g.Source == source && g.UserID == strings.ToLower(userID) {
+				games = append(games, g)
+			}
+		}
+
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return gamesIterator(ctx, nil), nil
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sort.SliceStable(games, func(i, j int) bool {
+		return games[i].PlayedAt < games[j].PlayedAt
+	})
+
+	return gamesIterator(ctx, games), nil
+}
+
+// processMonthly upserts the game into the sidecar of the month and renders the monthly PGN from it,
+// so processing the same game again never duplicates it
+func (p *FileSystemProcessor) processMonthly(g *Game) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	base := p.monthlyBase(g)
+
+	games, err := p.readMonthly(base)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	replaced := false
+
+	for i, stored := range games {
+		if stored.ID == g.ID && stored.Source == g.Source {
+			games[i] = g
+			replaced = true
+		}
+	}
+
+	if !replaced {
+		games = append(games, g)
+	}
+
+	sort.SliceStable(games, func(i, j int) bool {
+		return games[i].PlayedAt < games[j].PlayedAt
+	})
+
+	pgns := make([]string, 0, len(games))
+
+	for _, stored := range games {
+		pgns = append(pgns, strings.TrimSpace(stored.PGN))
+	}
+
+	data, err := json.MarshalIndent(games, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = writeFileAtomic(base+".pgn", []byte(strings.Join(pgns, "\n\n")+"\n"))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(writeFileAtomic(base+".json", data))
+}
+
+// monthlyBase returns the path of the monthly files of the game without extension
+func (p *FileSystemProcessor) monthlyBase(g *Game) string {
+	return filepath.Join(p.folder(g), g.PlayedAtTime().Format("2006-01"))
+}
+
+// readMonthly returns the games of the monthly sidecar, none when the month has no file yet
+func (p *FileSystemProcessor) readMonthly(base string) ([]*Game, error) {
+	var games []*Game
+
+	data, err := ioutil.ReadFile(base + ".json")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = json.Unmarshal(data, &games)
+	if err != nil {
+		return nil, errors.Wrapf(err, "corrupted sidecar %s.json", base)
+	}
+
+	return games, nil
+}
+
+func (p *FileSystemProcessor) folder(g *Game) string {
+	return filepath.Join(append([]string{p.dir}, p.layout.Resolve(g)...)...)
+}
+
+// fileName returns the file name of the game without extension
+func (p *FileSystemProcessor) fileName(g *Game) string {
+	if p.naming == FileNamingID {
+		return sanitizeSegment(fmt.Sprintf("%s-%s", g.Source, g.ID))
+	}
+
+	return sanitizeSegment(strings.TrimSuffix(g.Name(), ".pgn"))
+}
+
+// writeFileAtomic writes the data to a temporary file in the same directory and renames it over the target
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+
+		return errors.WithStack(err)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
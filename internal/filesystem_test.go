@@ -0,0 +1,116 @@
+package chessarchive
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func newTestFileSystemProcessor(t *testing.T, mode string) (*FileSystemProcessor, string) {
+	t.Helper()
+
+	logger, _ := test.NewNullLogger()
+	dir := t.TempDir()
+
+	layout, err := ParseLayout("{year}")
+	if err != nil {
+		t.Fatalf("ParseLayout() error = %v", err)
+	}
+
+	p, err := NewFileSystemProcessor(dir, layout, mode, FileNamingID, logger)
+	if err != nil {
+		t.Fatalf("NewFileSystemProcessor() error = %v", err)
+	}
+
+	return p, dir
+}
+
+func fileSystemGame(id string, day int) *Game {
+	return &Game{
+		ID:       id,
+		Source:   lichessorg,
+		UserID:   "u",
+		PlayedAt: time.Date(2021, time.May, day, 12, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond),
+		PGN:      "[Site \"https://lichess.org/" + id + "\"]\n\n1. e4 *\n",
+	}
+}
+
+func TestFileSystemProcessorGameMode(t *testing.T) {
+	ctx := context.Background()
+	p, dir := newTestFileSystemProcessor(t, FileModeGame)
+	g := fileSystemGame("a", 1)
+
+	action, err := p.Plan(ctx, g)
+	if err != nil || action != ActionCreate {
+		t.Fatalf("Plan() = %v, %v, want %v", action, err, ActionCreate)
+	}
+
+	err = p.Process(ctx, g)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	pgn, err := ioutil.ReadFile(filepath.Join(dir, "2021", "lichess-a.pgn"))
+	if err != nil || string(pgn) != g.PGN {
+		t.Errorf("PGN file = %q, %v, want %q", pgn, err, g.PGN)
+	}
+
+	action, err = p.Plan(ctx, g)
+	if err != nil || action != ActionSkip {
+		t.Errorf("Plan() of the archived game = %v, %v, want %v", action, err, ActionSkip)
+	}
+
+	changed := *g
+	changed.Status = "resign"
+
+	action, err = p.Plan(ctx, &changed)
+	if err != nil || action != ActionUpdate {
+		t.Errorf("Plan() of the changed game = %v, %v, want %v", action, err, ActionUpdate)
+	}
+}
+
+func TestFileSystemProcessorMonthlyModeUpserts(t *testing.T) {
+	ctx := context.Background()
+	p, dir := newTestFileSystemProcessor(t, FileModeMonthly)
+
+	for _, g := range []*Game{fileSystemGame("b", 2), fileSystemGame("a", 1), fileSystemGame("b", 2)} {
+		err := p.Process(ctx, g)
+		if err != nil {
+			t.Fatalf("Process(%s) error = %v", g.ID, err)
+		}
+	}
+
+	pgn, err := ioutil.ReadFile(filepath.Join(dir, "2021", "2021-05.pgn"))
+	if err != nil {
+		t.Fatalf("monthly PGN error = %v", err)
+	}
+
+	if n := strings.Count(string(pgn), "[Site "); n != 2 {
+		t.Errorf("monthly PGN holds %d games, want 2", n)
+	}
+
+	if strings.Index(string(pgn), "lichess.org/a") > strings.Index(string(pgn), "lichess.org/b") {
+		t.Error("monthly PGN is not ordered by the game date")
+	}
+
+	var ids []string
+
+	it, err := p.ListGames(ctx, lichessorg, "U")
+	if err != nil {
+		t.Fatalf("ListGames() error = %v", err)
+	}
+
+	err = EachGame(it, func(g *Game) error {
+		ids = append(ids, g.ID)
+
+		return nil
+	})
+	if err != nil || strings.Join(ids, ",") != "a,b" {
+		t.Errorf("ListGames() = %v, %v, want [a b]", ids, err)
+	}
+}
@@ -0,0 +1,692 @@
+package chessarchive
+
+import (
+	"chess-archive/pkg/retry"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus/hooks/test"
+	"google.golang.org/api/option"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fakeFirestore is an in-memory Firestore server, it implements the calls made by the Go client for the documents,
+// the queries, the batches and the transactions. The transactions are not isolated.
+type fakeFirestore struct {
+	pb.UnimplementedFirestoreServer
+
+	mu      sync.Mutex
+	docs    map[string]*pb.Document //full document name => document
+	commits int
+
+	//fail the queries filtering on a field and ordering on another one, as Firestore does without a composite index
+	withoutCompositeIndexes bool
+}
+
+// newFakeFirestore starts the server and returns a client connected to it, both are stopped with the test
+func newFakeFirestore(t *testing.T) (*fakeFirestore, *firestore.Client) {
+	t.Helper()
+
+	f := &fakeFirestore{docs: map[string]*pb.Document{}}
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	pb.RegisterFirestoreServer(srv, f)
+
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+
+	conn, err := grpc.Dial(
+		"bufconn",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+	)
+	if err != nil {
+		t.Fatalf("grpc.Dial() error = %v", err)
+	}
+
+	client, err := firestore.NewClient(context.Background(), "test", option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatalf("firestore.NewClient() error = %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = client.Close()
+		srv.Stop()
+	})
+
+	return f, client
+}
+
+// fields returns the fields of the document at the path below the root, nil when it does not exist
+func (f *fakeFirestore) fields(path string) map[string]*pb.Value {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	doc, ok := f.docs["projects/test/databases/(default)/documents/"+path]
+	if !ok {
+		return nil
+	}
+
+	return doc.Fields
+}
+
+// paths returns the paths of the documents of the collection below the root, in order
+func (f *fakeFirestore) paths(collection string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var paths []string
+
+	prefix := "projects/test/databases/(default)/documents/" + collection + "/"
+
+	for name := range f.docs {
+		if strings.HasPrefix(name, prefix) && !strings.Contains(name[len(prefix):], "/") {
+			paths = append(paths, strings.TrimPrefix(name, "projects/test/databases/(default)/documents/"))
+		}
+	}
+
+	sort.Strings(paths)
+
+	return paths
+}
+
+// put stores the document at the path below the root as it is, e.g. a document written by an older version
+func (f *fakeFirestore) put(path string, fields map[string]*pb.Value) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := timestamppb.Now()
+	name := "projects/test/databases/(default)/documents/" + path
+	f.docs[name] = &pb.Document{Name: name, Fields: fields, CreateTime: now, UpdateTime: now}
+}
+
+func (f *fakeFirestore) BatchGetDocuments(req *pb.BatchGetDocumentsRequest, stream pb.Firestore_BatchGetDocumentsServer) error {
+	f.mu.Lock()
+
+	var responses []*pb.BatchGetDocumentsResponse
+
+	for _, name := range req.Documents {
+		res := &pb.BatchGetDocumentsResponse{ReadTime: timestamppb.Now()}
+
+		if doc, ok := f.docs[name]; ok {
+			res.Result = &pb.BatchGetDocumentsResponse_Found{Found: proto.Clone(doc).(*pb.Document)}
+		} else {
+			res.Result = &pb.BatchGetDocumentsResponse_Missing{Missing: name}
+		}
+
+		responses = append(responses, res)
+	}
+
+	f.mu.Unlock()
+
+	for _, res := range responses {
+		err := stream.Send(res)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *fakeFirestore) BeginTransaction(context.Context, *pb.BeginTransactionRequest) (*pb.BeginTransactionResponse, error) {
+	return &pb.BeginTransactionResponse{Transaction: []byte("tx")}, nil
+}
+
+func (f *fakeFirestore) Rollback(context.Context, *pb.RollbackRequest) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, nil
+}
+
+func (f *fakeFirestore) Commit(_ context.Context, req *pb.CommitRequest) (*pb.CommitResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.commits++
+
+	now := timestamppb.Now()
+	res := &pb.CommitResponse{CommitTime: now}
+
+	//the writes are checked first, so a failed commit writes nothing
+	for _, w := range req.Writes {
+		name := w.GetDelete()
+		if w.GetUpdate() != nil {
+			name = w.GetUpdate().Name
+		}
+
+		_, exists := f.docs[name]
+
+		if pre := w.CurrentDocument; pre != nil {
+			if e, ok := pre.ConditionType.(*pb.Precondition_Exists); ok && e.Exists != exists {
+				if exists {
+					return nil, status.Errorf(codes.AlreadyExists, "document %s exists", name)
+				}
+
+				return nil, status.Errorf(codes.NotFound, "document %s not found", name)
+			}
+		}
+	}
+
+	for _, w := range req.Writes {
+		res.WriteResults = append(res.WriteResults, &pb.WriteResult{UpdateTime: now})
+
+		if name := w.GetDelete(); name != "" {
+			delete(f.docs, name)
+
+			continue
+		}
+
+		update := w.GetUpdate()
+
+		doc, ok := f.docs[update.Name]
+		if !ok {
+			doc = &pb.Document{Name: update.Name, Fields: map[string]*pb.Value{}, CreateTime: now}
+			f.docs[update.Name] = doc
+		}
+
+		doc.UpdateTime = now
+
+		if w.UpdateMask == nil {
+			doc.Fields = proto.Clone(update).(*pb.Document).Fields
+
+			continue
+		}
+
+		for _, path := range w.UpdateMask.FieldPaths {
+			setField(doc.Fields, update.Fields, splitFieldPath(path))
+		}
+	}
+
+	return res, nil
+}
+
+// setField copies the field at the path from the source fields, the field is deleted when the source misses it
+func setField(fields, source map[string]*pb.Value, path []string) {
+	value, ok := source[path[0]]
+
+	if len(path) == 1 {
+		if ok {
+			fields[path[0]] = proto.Clone(value).(*pb.Value)
+		} else {
+			delete(fields, path[0])
+		}
+
+		return
+	}
+
+	child := fields[path[0]].GetMapValue()
+	if child == nil {
+		child = &pb.MapValue{Fields: map[string]*pb.Value{}}
+		fields[path[0]] = &pb.Value{ValueType: &pb.Value_MapValue{MapValue: child}}
+	}
+
+	if child.Fields == nil {
+		child.Fields = map[string]*pb.Value{}
+	}
+
+	setField(child.Fields, value.GetMapValue().GetFields(), path[1:])
+}
+
+func splitFieldPath(path string) []string {
+	parts := strings.Split(path, ".")
+	for i, part := range parts {
+		parts[i] = strings.Trim(part, "`")
+	}
+
+	return parts
+}
+
+func (f *fakeFirestore) RunQuery(req *pb.RunQueryRequest, stream pb.Firestore_RunQueryServer) error {
+	q := req.GetStructuredQuery()
+
+	if len(q.From) != 1 || q.From[0].AllDescendants {
+		return status.Error(codes.Unimplemented, "only the queries of a collection are supported")
+	}
+
+	if f.withoutCompositeIndexes && needsCompositeIndex(q) {
+		return status.Error(codes.FailedPrecondition, "The query requires an index.")
+	}
+
+	prefix := req.Parent + "/" + q.From[0].CollectionId + "/"
+
+	f.mu.Lock()
+
+	var docs []*pb.Document
+
+	for name, doc := range f.docs {
+		if !strings.HasPrefix(name, prefix) || strings.Contains(name[len(prefix):], "/") {
+			continue
+		}
+
+		if matches(doc, q.Where) && ordered(doc, q.OrderBy) {
+			docs = append(docs, proto.Clone(doc).(*pb.Document))
+		}
+	}
+
+	f.mu.Unlock()
+
+	orders := append([]*pb.StructuredQuery_Order(nil), q.OrderBy...)
+	if len(orders) == 0 || orders[len(orders)-1].Field.FieldPath != "__name__" {
+		dir := pb.StructuredQuery_ASCENDING
+		if len(orders) > 0 {
+			dir = orders[len(orders)-1].Direction
+		}
+
+		orders = append(orders, &pb.StructuredQuery_Order{
+			Field:     &pb.StructuredQuery_FieldReference{FieldPath: "__name__"},
+			Direction: dir,
+		})
+	}
+
+	sort.Slice(docs, func(i, j int) bool {
+		return compareOrder(docs[i], orderValues(docs[j], orders), orders) < 0
+	})
+
+	var kept []*pb.Document
+
+	for _, doc := range docs {
+		if start := q.StartAt; start != nil {
+			c := compareOrder(doc, start.Values, orders)
+			if c < 0 || (c == 0 && !start.Before) {
+				continue
+			}
+		}
+
+		if end := q.EndAt; end != nil {
+			c := compareOrder(doc, end.Values, orders)
+			if c > 0 || (c == 0 && end.Before) {
+				continue
+			}
+		}
+
+		kept = append(kept, doc)
+	}
+
+	if offset := int(q.Offset); offset > 0 {
+		if offset > len(kept) {
+			offset = len(kept)
+		}
+
+		kept = kept[offset:]
+	}
+
+	if q.Limit != nil && int(q.Limit.Value) < len(kept) {
+		kept = kept[:q.Limit.Value]
+	}
+
+	for _, doc := range kept {
+		err := stream.Send(&pb.RunQueryResponse{Document: doc, ReadTime: timestamppb.Now()})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// needsCompositeIndex reports whether the query filters on a field and orders by another one
+func needsCompositeIndex(q *pb.StructuredQuery) bool {
+	filtered := map[string]bool{}
+
+	for _, filter := range flatten(q.Where) {
+		filtered[filter.Field.FieldPath] = true
+	}
+
+	for _, order := range q.OrderBy {
+		if len(filtered) > 0 && order.Field.FieldPath != "__name__" && !filtered[order.Field.FieldPath] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func flatten(filter *pb.StructuredQuery_Filter) []*pb.StructuredQuery_FieldFilter {
+	if filter == nil {
+		return nil
+	}
+
+	if ff := filter.GetFieldFilter(); ff != nil {
+		return []*pb.StructuredQuery_FieldFilter{ff}
+	}
+
+	var list []*pb.StructuredQuery_FieldFilter
+
+	for _, child := range filter.GetCompositeFilter().GetFilters() {
+		list = append(list, flatten(child)...)
+	}
+
+	return list
+}
+
+func matches(doc *pb.Document, filter *pb.StructuredQuery_Filter) bool {
+	if filter == nil {
+		return true
+	}
+
+	if cf := filter.GetCompositeFilter(); cf != nil {
+		for _, child := range cf.Filters {
+			if !matches(doc, child) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	if uf := filter.GetUnaryFilter(); uf != nil {
+		value, ok := fieldValue(doc, uf.GetField().FieldPath)
+
+		switch uf.Op {
+		case pb.StructuredQuery_UnaryFilter_IS_NULL:
+			return ok && value.GetValueType() == nil || ok && isNull(value)
+		case pb.StructuredQuery_UnaryFilter_IS_NOT_NULL:
+			return ok && !isNull(value)
+		default:
+			return false
+		}
+	}
+
+	ff := filter.GetFieldFilter()
+
+	value, ok := fieldValue(doc, ff.Field.FieldPath)
+	if !ok {
+		return false
+	}
+
+	c := compareValues(value, ff.Value)
+
+	switch ff.Op {
+	case pb.StructuredQuery_FieldFilter_EQUAL:
+		return c == 0
+	case pb.StructuredQuery_FieldFilter_NOT_EQUAL:
+		return c != 0
+	case pb.StructuredQuery_FieldFilter_LESS_THAN:
+		return c < 0
+	case pb.StructuredQuery_FieldFilter_LESS_THAN_OR_EQUAL:
+		return c <= 0
+	case pb.StructuredQuery_FieldFilter_GREATER_THAN:
+		return c > 0
+	case pb.StructuredQuery_FieldFilter_GREATER_THAN_OR_EQUAL:
+		return c >= 0
+	case pb.StructuredQuery_FieldFilter_IN:
+		for _, candidate := range ff.Value.GetArrayValue().GetValues() {
+			if compareValues(value, candidate) == 0 {
+				return true
+			}
+		}
+
+		return false
+	default:
+		return false
+	}
+}
+
+func isNull(v *pb.Value) bool {
+	_, ok := v.GetValueType().(*pb.Value_NullValue)
+
+	return ok
+}
+
+// ordered reports whether the document has every field of the order, Firestore leaves out the others
+func ordered(doc *pb.Document, orders []*pb.StructuredQuery_Order) bool {
+	for _, order := range orders {
+		if _, ok := fieldValue(doc, order.Field.FieldPath); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func fieldValue(doc *pb.Document, path string) (*pb.Value, bool) {
+	if path == "__name__" {
+		return &pb.Value{ValueType: &pb.Value_ReferenceValue{ReferenceValue: doc.Name}}, true
+	}
+
+	fields := doc.Fields
+
+	parts := splitFieldPath(path)
+	for i, part := range parts {
+		value, ok := fields[part]
+		if !ok {
+			return nil, false
+		}
+
+		if i == len(parts)-1 {
+			return value, true
+		}
+
+		fields = value.GetMapValue().GetFields()
+	}
+
+	return nil, false
+}
+
+func orderValues(doc *pb.Document, orders []*pb.StructuredQuery_Order) []*pb.Value {
+	values := make([]*pb.Value, 0, len(orders))
+
+	for _, order := range orders {
+		value, _ := fieldValue(doc, order.Field.FieldPath)
+		values = append(values, value)
+	}
+
+	return values
+}
+
+// compareOrder compares the document to the cursor values in the order of the query
+func compareOrder(doc *pb.Document, values []*pb.Value, orders []*pb.StructuredQuery_Order) int {
+	for i, value := range values {
+		if i >= len(orders) {
+			break
+		}
+
+		own, _ := fieldValue(doc, orders[i].Field.FieldPath)
+
+		c := compareValues(own, value)
+		if orders[i].Direction == pb.StructuredQuery_DESCENDING {
+			c = -c
+		}
+
+		if c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
+
+// typeOrder is the order of the value types in Firestore
+func typeOrder(v *pb.Value) int {
+	switch v.GetValueType().(type) {
+	case *pb.Value_NullValue:
+		return 0
+	case *pb.Value_BooleanValue:
+		return 1
+	case *pb.Value_IntegerValue, *pb.Value_DoubleValue:
+		return 2
+	case *pb.Value_TimestampValue:
+		return 3
+	case *pb.Value_StringValue:
+		return 4
+	case *pb.Value_BytesValue:
+		return 5
+	case *pb.Value_ReferenceValue:
+		return 6
+	case *pb.Value_GeoPointValue:
+		return 7
+	case *pb.Value_ArrayValue:
+		return 8
+	default:
+		return 9
+	}
+}
+
+func compareValues(a, b *pb.Value) int {
+	if ta, tb := typeOrder(a), typeOrder(b); ta != tb {
+		return ta - tb
+	}
+
+	switch a.GetValueType().(type) {
+	case *pb.Value_BooleanValue:
+		return compareBools(a.GetBooleanValue(), b.GetBooleanValue())
+	case *pb.Value_IntegerValue, *pb.Value_DoubleValue:
+		return compareFloats(number(a), number(b))
+	case *pb.Value_TimestampValue:
+		return compareFloats(float64(a.GetTimestampValue().AsTime().UnixNano()), float64(b.GetTimestampValue().AsTime().UnixNano()))
+	case *pb.Value_StringValue:
+		return strings.Compare(a.GetStringValue(), b.GetStringValue())
+	case *pb.Value_ReferenceValue:
+		return strings.Compare(a.GetReferenceValue(), b.GetReferenceValue())
+	case *pb.Value_NullValue:
+		return 0
+	default:
+		if proto.Equal(a, b) {
+			return 0
+		}
+
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	}
+}
+
+func number(v *pb.Value) float64 {
+	if i, ok := v.GetValueType().(*pb.Value_IntegerValue); ok {
+		return float64(i.IntegerValue)
+	}
+
+	return v.GetDoubleValue()
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareBools(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case !a:
+		return -1
+	default:
+		return 1
+	}
+}
+
+func TestFakeFirestore(t *testing.T) {
+	ctx := context.Background()
+	_, client := newFakeFirestore(t)
+	games := collection(client, "ns", "games")
+
+	for i, id := range []string{"c", "a", "b"} {
+		_, err := games.Doc(id).Set(ctx, map[string]interface{}{"played_at": i, "user_id": "u"})
+		if err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+
+	docs, err := games.Where("user_id", "==", "u").OrderBy("played_at", firestore.Desc).Limit(2).Documents(ctx).GetAll()
+	if err != nil || len(docs) != 2 || docs[0].Ref.ID != "b" || docs[1].Ref.ID != "a" {
+		t.Fatalf("query = %v, %v, want b and a", docs, err)
+	}
+
+	docs, err = games.OrderBy(firestore.DocumentID, firestore.Asc).StartAfter("a").Documents(ctx).GetAll()
+	if err != nil || len(docs) != 2 || docs[0].Ref.ID != "b" || docs[1].Ref.ID != "c" {
+		t.Fatalf("query after a = %v, %v, want b and c", docs, err)
+	}
+
+	_, err = games.Doc("a").Update(ctx, []firestore.Update{{Path: "user_id", Value: firestore.Delete}, {Path: "x", Value: 1}})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	doc, err := games.Doc("a").Get(ctx)
+	if err != nil || doc.Data()["x"] != int64(1) || doc.Data()["user_id"] != nil || doc.Data()["played_at"] != int64(1) {
+		t.Errorf("updated document = %v, %v", doc.Data(), err)
+	}
+}
+
+func newTestDataStoreProcessor(client *firestore.Client, namespace string) *DataStoreProcessor {
+	logger, _ := test.NewNullLogger()
+
+	return NewDataStoreProcessor(logger, client, namespace, retry.Policy{})
+}
+
+func TestDataStoreProcessorKeepsTheGameOfEveryUser(t *testing.T) {
+	ctx := context.Background()
+	_, client := newFakeFirestore(t)
+	processor := newTestDataStoreProcessor(client, "shared")
+
+	//alice and bob played each other and share the namespace
+	for _, userID := range []string{"alice", "bob"} {
+		g := &Game{ID: "g1", Source: lichessorg, UserID: userID, PlayedAt: 1000}
+
+		err := processor.Process(ctx, g)
+		if err != nil {
+			t.Fatalf("Process(%s) error = %v", userID, err)
+		}
+
+		action, err := processor.Plan(ctx, g)
+		if err != nil || action != ActionSkip {
+			t.Errorf("Plan(%s) = %v, %v, want %v", userID, action, err, ActionSkip)
+		}
+	}
+
+	for _, userID := range []string{"alice", "bob"} {
+		it, err := processor.ListGames(ctx, lichessorg, userID)
+		if err != nil {
+			t.Fatalf("ListGames(%s) error = %v", userID, err)
+		}
+
+		if got := listIDs(t, it); got != "g1" {
+			t.Errorf("ListGames(%s) = %s, want g1", userID, got)
+		}
+	}
+}
+
+func TestDataStoreGameStorageLastFailsWithoutTheIndex(t *testing.T) {
+	ctx := context.Background()
+	logger, _ := test.NewNullLogger()
+	f, client := newFakeFirestore(t)
+	f.withoutCompositeIndexes = true
+
+	_, err := collection(client, "", "migrations").Doc("v2").Set(ctx, migrationProgress{Target: 2, Completed: true})
+	if err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	err = newTestDataStoreProcessor(client, "").Process(ctx, &Game{ID: "g1", Source: lichessorg, UserID: "alice", PlayedAt: 1000})
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	g, err := NewDataStoreGameStorage(logger, client, "").Last(ctx, lichessorg, "alice")
+
+	var indexErr *ErrMissingIndex
+	if !errors.As(err, &indexErr) || !IsTransient(err) {
+		t.Errorf("Last() = %v, %v, want a transient missing index error", g, err)
+	}
+}
@@ -0,0 +1,162 @@
+package chessarchive
+
+import (
+	"chess-archive/pkg/google/drive"
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// driveFolders resolves the layout folders into Drive folder IDs, missing folders are created on demand.
+// The IDs are cached, so every folder is listed and created at most once per run.
+type driveFolders struct {
+	mu       sync.Mutex
+	gdClient drive.GDriveClient
+	rootID   string
+	ids      map[string]string //parent ID + "/" + name => folder ID
+	listed   map[string]bool   //parent IDs whose sub folders were loaded
+	inside   map[string]bool   //folder ID => whether the folder is the root or below it
+}
+
+func newDriveFolders(gdClient drive.GDriveClient, rootID string) *driveFolders {
+	return &driveFolders{
+		gdClient: gdClient,
+		rootID:   rootID,
+		ids:      map[string]string{},
+		listed:   map[string]bool{},
+		inside:   map[string]bool{rootID: true},
+	}
+}
+
+// resolve returns the ID of the folder at the path below the root folder
+func (f *driveFolders) resolve(ctx context.Context, path []string) (string, error) {
+	return f.walk(ctx, path, true)
+}
+
+// lookup is resolve without creating the missing folders, the ID is empty when a folder is missing
+func (f *driveFolders) lookup(ctx context.Context, path []string) (string, error) {
+	return f.walk(ctx, path, false)
+}
+
+func (f *driveFolders) walk(ctx context.Context, path []string, create bool) (string, error) {
+	//held for the whole resolution, so concurrent games never create the same folder twice
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	parentID := f.rootID
+
+	for _, name := range path {
+		id, err := f.child(ctx, parentID, name, create)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+
+		if id == "" {
+			return "", nil
+		}
+
+		parentID = id
+	}
+
+	return parentID, nil
+}
+
+func (f *driveFolders) child(ctx context.Context, parentID, name string, create bool) (string, error) {
+	key := parentID + "/" + name
+
+	if id, ok := f.ids[key]; ok {
+		return id, nil
+	}
+
+	if !f.listed[parentID] {
+		folders, err := f.gdClient.SubFolders(ctx, parentID)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+
+		for _, folder := range folders {
+			k := parentID + "/" + folder.Name
+			if _, ok := f.ids[k]; !ok {
+				f.ids[k] = folder.ID
+			}
+		}
+
+		f.listed[parentID] = true
+
+		if id, ok := f.ids[key]; ok {
+			return id, nil
+		}
+	}
+
+	if !create {
+		return "", nil
+	}
+
+	id, err := f.gdClient.CreateFolder(ctx, parentID, name)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	f.ids[key] = id
+
+	return id, nil
+}
+
+// contains reports whether one of the parent folders is the root or a folder below it,
+// the ancestors of the unknown folders are looked up once and cached
+func (f *driveFolders) contains(ctx context.Context, parents []string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, id := range f.ids {
+		f.inside[id] = true
+	}
+
+	for _, parentID := range parents {
+		inside, err := f.below(ctx, parentID, map[string]bool{})
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+
+		if inside {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (f *driveFolders) below(ctx context.Context, folderID string, seen map[string]bool) (bool, error) {
+	if inside, ok := f.inside[folderID]; ok {
+		return inside, nil
+	}
+
+	if seen[folderID] {
+		return false, nil
+	}
+
+	seen[folderID] = true
+
+	folder, err := f.gdClient.Get(ctx, folderID)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	inside := false
+
+	for _, parentID := range folder.Parents {
+		inside, err = f.below(ctx, parentID, seen)
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+
+		if inside {
+			break
+		}
+	}
+
+	f.inside[folderID] = inside
+
+	return inside, nil
+}
@@ -1,9 +1,14 @@
 package chessarchive
 
 import (
+	"bytes"
 	"chess-archive/config"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 type Source int
@@ -12,6 +17,7 @@ type UserResult string
 const (
 	_ = iota
 	lichessorg
+	chessdotcom
 )
 
 const (
@@ -23,39 +29,79 @@ const (
 var format = "2006-01-02 15:04:05"
 
 type Game struct {
-	ID         string     `firestore:"id"`
-	Source     Source     `firestore:"source"`
-	Speed      string     `firestore:"speed"`
-	Duration   uint16     `firestore:"duration"`
-	Status     string     `firestore:"status"`
-	UserResult UserResult `firestore:"result"`
-	PlayedAt   int64      `firestore:"played_at"` //milliseconds
-	Winner     string     `firestore:"winner"`
-	PGN        string     `firestore:"pgn"`
-	Opening    *Opening   `firestore:"opening,omitempty"`
-	Players    struct {
-		White Player `firestore:"white"`
-		Black Player `firestore:"black"`
-	} `firestore:"players"`
+	ID            string            `firestore:"id" json:"id"`
+	Source        Source            `firestore:"source" json:"source"`
+	UserID        string            `firestore:"user_id" json:"user_id"` //archived account on the source
+	Speed         string            `firestore:"speed" json:"speed"`
+	Duration      uint16            `firestore:"duration" json:"duration"`
+	Status        string            `firestore:"status" json:"status"`
+	UserResult    UserResult        `firestore:"result" json:"result"`
+	PlayedAt      int64             `firestore:"played_at" json:"played_at"` //milliseconds
+	Winner        string            `firestore:"winner" json:"winner"`
+	PGN           string            `firestore:"pgn" json:"pgn"`
+	Opening       *Opening          `firestore:"opening,omitempty" json:"opening,omitempty"`
+	Headers       map[string]string `firestore:"headers,omitempty" json:"headers,omitempty"` //PGN tag pairs
+	Moves         []Move            `firestore:"moves,omitempty" json:"moves,omitempty"`     //main line
+	FinalFEN      string            `firestore:"final_fen,omitempty" json:"final_fen,omitempty"`
+	PGNError      string            `firestore:"pgn_error,omitempty" json:"pgn_error,omitempty"` //why the PGN is corrupt or truncated
+	SchemaVersion int               `firestore:"schema_version" json:"schema_version"`           //version of the stored fields, see SchemaVersion
+	Players       struct {
+		White Player `firestore:"white" json:"white"`
+		Black Player `firestore:"black" json:"black"`
+	} `firestore:"players" json:"players"`
 }
 
 type Player struct {
-	ID       string    `firestore:"id"`
-	Name     string    `firestore:"name"`
-	Rating   uint16    `firestore:"rating"`
-	Analysis *Analysis `firestore:"analysis,omitempty"`
+	ID       string    `firestore:"id" json:"id"`
+	Name     string    `firestore:"name" json:"name"`
+	Rating   uint16    `firestore:"rating" json:"rating"`
+	Analysis *Analysis `firestore:"analysis,omitempty" json:"analysis,omitempty"`
 }
 
 type Analysis struct {
-	Inaccuracy uint16 `firestore:"inaccuracy"`
-	Mistake    uint16 `firestore:"mistake"`
-	Blunder    uint16 `firestore:"blunder"`
-	ACPL       uint16 `firestore:"acpl"`
+	Inaccuracy uint16 `firestore:"inaccuracy" json:"inaccuracy"`
+	Mistake    uint16 `firestore:"mistake" json:"mistake"`
+	Blunder    uint16 `firestore:"blunder" json:"blunder"`
+	ACPL       uint16 `firestore:"acpl" json:"acpl"`
+}
+
+type Move struct {
+	Ply     uint16 `firestore:"ply" json:"ply"`
+	SAN     string `firestore:"san" json:"san"`
+	Clock   *int64 `firestore:"clock,omitempty" json:"clock,omitempty"` //milliseconds left after the move
+	Eval    *int   `firestore:"eval,omitempty" json:"eval,omitempty"`   //centipawns, white point of view
+	Mate    *int   `firestore:"mate,omitempty" json:"mate,omitempty"`   //moves to mate, negative when black mates
+	NAGs    []int  `firestore:"nags,omitempty" json:"nags,omitempty"`
+	Comment string `firestore:"comment,omitempty" json:"comment,omitempty"`
+	FEN     string `firestore:"fen,omitempty" json:"fen,omitempty"` //position after the move
 }
 
 type Opening struct {
-	Name    string `firestore:"name"`
-	ECOCode string `firestore:"eco_code"`
+	Name    string `firestore:"name" json:"name"`
+	ECOCode string `firestore:"eco_code" json:"eco_code"`
+}
+
+func (s Source) String() string {
+	switch s {
+	case lichessorg:
+		return "lichess"
+	case chessdotcom:
+		return "chesscom"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseSource returns the source named as by String
+func ParseSource(name string) (Source, error) {
+	switch name {
+	case "lichess":
+		return lichessorg, nil
+	case "chesscom":
+		return chessdotcom, nil
+	default:
+		return 0, errors.Errorf("unknown source %q", name)
+	}
 }
 
 func (g *Game) Name() string {
@@ -84,3 +130,25 @@ func (g *Game) Result() string {
 		return "1/2 - 1/2"
 	}
 }
+
+// playedBy reports whether the user is one of the players
+func (g *Game) playedBy(userID string) bool {
+	return strings.EqualFold(g.Players.White.ID, userID) || strings.EqualFold(g.Players.Black.ID, userID)
+}
+
+// opponent returns the player the archived user played against
+func (g *Game) opponent() Player {
+	if strings.EqualFold(g.Players.White.ID, g.UserID) || strings.EqualFold(g.Players.White.Name, g.UserID) {
+		return g.Players.Black
+	}
+
+	return g.Players.White
+}
+
+// Equal reports whether both games hold the same data
+func (g *Game) Equal(other *Game) bool {
+	a, errA := json.Marshal(g)
+	b, errB := json.Marshal(other)
+
+	return errA == nil && errB == nil && bytes.Equal(a, b)
+}
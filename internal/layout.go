@@ -0,0 +1,95 @@
+package chessarchive
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const unknownSegment = "unknown"
+
+var layoutPlaceholderRegexp = regexp.MustCompile(`{([a-z.]+)}`)
+
+// layoutValues resolves the placeholders available in the archive layout template
+var layoutValues = map[string]func(g *Game) string{
+	"year":   func(g *Game) string { return fmt.Sprintf("%04d", g.PlayedAtTime().Year()) },
+	"month":  func(g *Game) string { return fmt.Sprintf("%02d", int(g.PlayedAtTime().Month())) },
+	"day":    func(g *Game) string { return fmt.Sprintf("%02d", g.PlayedAtTime().Day()) },
+	"source": func(g *Game) string { return g.Source.String() },
+	"speed":  func(g *Game) string { return g.Speed },
+	"status": func(g *Game) string { return g.Status },
+	"result": func(g *Game) string { return string(g.UserResult) },
+	"white":  func(g *Game) string { return g.Players.White.Name },
+	"black":  func(g *Game) string { return g.Players.Black.Name },
+	"opening.eco": func(g *Game) string {
+		if g.Opening == nil {
+			return ""
+		}
+
+		return g.Opening.ECOCode
+	},
+	"opening.name": func(g *Game) string {
+		if g.Opening == nil {
+			return ""
+		}
+
+		return g.Opening.Name
+	},
+}
+
+// Layout is the folder hierarchy of the archive, e.g. "{year}/{month}" or "{speed}/{opening.eco}".
+// An empty layout keeps every game in the archive root.
+type Layout struct {
+	segments []string
+}
+
+func ParseLayout(tmpl string) (*Layout, error) {
+	var segments []string
+
+	for _, s := range strings.Split(tmpl, "/") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		for _, m := range layoutPlaceholderRegexp.FindAllStringSubmatch(s, -1) {
+			if _, ok := layoutValues[m[1]]; !ok {
+				return nil, errors.Errorf("unknown placeholder %s in archive layout %q", m[0], tmpl)
+			}
+		}
+
+		segments = append(segments, s)
+	}
+
+	return &Layout{segments: segments}, nil
+}
+
+// Resolve returns the folder names from the archive root down to the folder of the game
+func (l *Layout) Resolve(g *Game) []string {
+	if l == nil {
+		return nil
+	}
+
+	folders := make([]string, 0, len(l.segments))
+
+	for _, s := range l.segments {
+		name := layoutPlaceholderRegexp.ReplaceAllStringFunc(s, func(p string) string {
+			return sanitizeSegment(layoutValues[p[1:len(p)-1]](g))
+		})
+
+		folders = append(folders, name)
+	}
+
+	return folders
+}
+
+func sanitizeSegment(v string) string {
+	v = strings.TrimSpace(strings.NewReplacer("/", "-", "\\", "-").Replace(v))
+	if v == "" || v == "." || v == ".." {
+		return unknownSegment
+	}
+
+	return v
+}
@@ -0,0 +1,52 @@
+package chessarchive
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLayoutResolve(t *testing.T) {
+	g := &Game{
+		Source:   lichessorg,
+		Speed:    "blitz",
+		PlayedAt: time.Date(2021, time.May, 3, 12, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond),
+		Opening:  &Opening{ECOCode: "B20", Name: "Sicilian Defense"},
+	}
+	g.Players.White.Name = "a/b"
+
+	tests := []struct {
+		layout string
+		want   []string
+	}{
+		{"", nil},
+		{"{year}/{month}", []string{"2021", "05"}},
+		{" {source} / {speed} ", []string{"lichess", "blitz"}},
+		{"{opening.eco}-{opening.name}", []string{"B20-Sicilian Defense"}},
+		{"{white}", []string{"a-b"}},
+		{"{status}", []string{unknownSegment}},
+	}
+
+	for _, tt := range tests {
+		l, err := ParseLayout(tt.layout)
+		if err != nil {
+			t.Fatalf("ParseLayout(%q) error = %v", tt.layout, err)
+		}
+
+		got := l.Resolve(g)
+		if len(got) == 0 && len(tt.want) == 0 {
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseLayout(%q).Resolve() = %q, want %q", tt.layout, got, tt.want)
+		}
+	}
+}
+
+func TestParseLayoutRejectsUnknownPlaceholders(t *testing.T) {
+	_, err := ParseLayout("{year}/{foo}")
+	if err == nil {
+		t.Error("ParseLayout() error = nil, want an unknown placeholder error")
+	}
+}
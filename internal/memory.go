@@ -0,0 +1,271 @@
+package chessarchive
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// MemoryGameProvider is an in-memory GameProvider, it serves the added games in pages of the given size.
+type MemoryGameProvider struct {
+	mu       sync.Mutex
+	source   Source
+	userID   string
+	pageSize int
+	games    []*Game
+}
+
+func NewMemoryGameProvider(source Source, userID string, pageSize int, games ...*Game) *MemoryGameProvider {
+	if pageSize <= 0 {
+		pageSize = len(games) + 1
+	}
+
+	p := &MemoryGameProvider{source: source, userID: userID, pageSize: pageSize}
+	p.Add(games...)
+
+	return p
+}
+
+func (p *MemoryGameProvider) Add(games ...*Game) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.games = append(p.games, games...)
+
+	sort.SliceStable(p.games, func(i, j int) bool {
+		return p.games[i].PlayedAt < p.games[j].PlayedAt
+	})
+}
+
+func (p *MemoryGameProvider) Source() Source {
+	return p.source
+}
+
+func (p *MemoryGameProvider) User() string {
+	return p.userID
+}
+
+func (p *MemoryGameProvider) List(ctx context.Context, opts ListOptions) (GameIterator, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var games []*Game
+
+	for _, g := range p.games {
+		if g.PlayedAt < opts.Since || !opts.before(g.PlayedAt) {
+			continue
+		}
+
+		//the games carry no rated flag, the memory games count as rated
+		if !opts.accepts(g.Speed, true, g.opponent().Name) {
+			continue
+		}
+
+		if opts.Max > 0 && len(games) == opts.Max {
+			break
+		}
+
+		games = append(games, g)
+	}
+
+	return newPageIterator(ctx, func(ctx context.Context) ([]*Game, []*TransformError, bool, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, false, err
+		}
+
+		n := p.pageSize
+		if n > len(games) {
+			n = len(games)
+		}
+
+		page := games[:n]
+		games = games[n:]
+
+		return page, nil, len(games) == 0, nil
+	}), nil
+}
+
+func (p *MemoryGameProvider) Fetch(_ context.Context, id string) (*Game, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, g := range p.games {
+		if g.ID == id {
+			return g, nil
+		}
+	}
+
+	return nil, errors.Errorf("game ID: %s not found", id)
+}
+
+// MemoryGameStore keeps the processed games in memory, it implements both GameStorage and Processor.
+// The games are keyed by their source, user and ID, so the users playing each other keep their own copy.
+type MemoryGameStore struct {
+	mu    sync.Mutex
+	games map[string]*Game
+}
+
+func NewMemoryGameStore() *MemoryGameStore {
+	return &MemoryGameStore{games: map[string]*Game{}}
+}
+
+func (s *MemoryGameStore) Last(_ context.Context, source Source, userID string) (*Game, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var last *Game
+
+	for _, g := range s.games {
+		if g.Source != source || g.UserID != strings.ToLower(userID) {
+			continue
+		}
+
+		if last == nil || g.PlayedAt > last.PlayedAt {
+			last = g
+		}
+	}
+
+	return last, nil
+}
+
+func (s *MemoryGameStore) Name() string {
+	return "memory"
+}
+
+func (s *MemoryGameStore) Plan(_ context.Context, g *Game) (Action, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.games[memoryKey(g)]
+
+	switch {
+	case !ok:
+		return ActionCreate, nil
+	case stored.Equal(g):
+		return ActionSkip, nil
+	default:
+		return ActionUpdate, nil
+	}
+}
+
+func (s *MemoryGameStore) Process(_ context.Context, g *Game) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.games[memoryKey(g)] = g
+
+	return nil
+}
+
+func memoryKey(g *Game) string {
+	return fmt.Sprintf("%s/%s/%s", g.Source, strings.ToLower(g.UserID), g.ID)
+}
+
+func (s *MemoryGameStore) ListGames(ctx context.Context, source Source, userID string) (GameIterator, error) {
+	var games []*Game
+
+	for _, g := range s.Games() {
+		if g.Source == source && g.UserID == strings.ToLower(userID) {
+			games = append(games, g)
+		}
+	}
+
+	return gamesIterator(ctx, games), nil
+}
+
+// Games returns the stored games ordered by the time they were played
+func (s *MemoryGameStore) Games() []*Game {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]*Game, 0, len(s.games))
+
+	for _, g := range s.games {
+		list = append(list, g)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].PlayedAt < list[j].PlayedAt
+	})
+
+	return list
+}
+
+type MemoryCheckpointStore struct {
+	mu          sync.Mutex
+	checkpoints map[string]Checkpoint
+}
+
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{checkpoints: map[string]Checkpoint{}}
+}
+
+func (s *MemoryCheckpointStore) Get(_ context.Context, source Source, userID string) (*Checkpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cp, ok := s.checkpoints[checkpointKey(source, userID)]
+	if !ok {
+		return nil, nil
+	}
+
+	return &cp, nil
+}
+
+func (s *MemoryCheckpointStore) Save(_ context.Context, cp *Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checkpoints[checkpointKey(cp.Source, cp.UserID)] = *cp
+
+	return nil
+}
+
+type MemoryDeadLetterStore struct {
+	mu          sync.Mutex
+	deadLetters map[string]DeadLetter
+}
+
+func NewMemoryDeadLetterStore() *MemoryDeadLetterStore {
+	return &MemoryDeadLetterStore{deadLetters: map[string]DeadLetter{}}
+}
+
+func (s *MemoryDeadLetterStore) List(_ context.Context, source Source, userID string) ([]*DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []*DeadLetter
+
+	for _, dl := range s.deadLetters {
+		if dl.Source == source && dl.UserID == userID {
+			dl := dl
+			list = append(list, &dl)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].key() < list[j].key() })
+
+	return list, nil
+}
+
+func (s *MemoryDeadLetterStore) Save(_ context.Context, dl *DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deadLetters[dl.key()] = *dl
+
+	return nil
+}
+
+func (s *MemoryDeadLetterStore) Delete(_ context.Context, dl *DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.deadLetters, dl.key())
+
+	return nil
+}
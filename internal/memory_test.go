@@ -0,0 +1,132 @@
+package chessarchive
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func listIDs(t *testing.T, it GameIterator) string {
+	t.Helper()
+
+	var ids []string
+
+	err := EachGame(it, func(g *Game) error {
+		ids = append(ids, g.ID)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("EachGame() error = %v", err)
+	}
+
+	return strings.Join(ids, ",")
+}
+
+func TestMemoryGameProviderList(t *testing.T) {
+	games := lichessGames("u", 10)
+	games[3].Speed = "blitz"
+	games[4].Speed = "blitz"
+	games[7].Speed = "blitz"
+
+	p := NewMemoryGameProvider(lichessorg, "u", 3, games...)
+
+	tests := []struct {
+		name string
+		opts ListOptions
+		want string
+	}{
+		{"all", ListOptions{}, "0,1,2,3,4,5,6,7,8,9"},
+		{"window", ListOptions{Since: 2000, Until: 5000}, "2,3,4"},
+		{"max", ListOptions{Since: 6000, Max: 2}, "6,7"},
+		{"speed", ListOptions{PerfTypes: []string{"blitz"}}, "3,4,7"},
+	}
+
+	for _, tt := range tests {
+		it, err := p.List(context.Background(), tt.opts)
+		if err != nil {
+			t.Fatalf("%s: List() error = %v", tt.name, err)
+		}
+
+		if got := listIDs(t, it); got != tt.want {
+			t.Errorf("%s: List() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	g, err := p.Fetch(context.Background(), "4")
+	if err != nil || g != games[4] {
+		t.Errorf("Fetch() = %+v, %v, want game 4", g, err)
+	}
+
+	_, err = p.Fetch(context.Background(), "x")
+	if err == nil {
+		t.Error("Fetch() of an unknown game error = nil, want an error")
+	}
+}
+
+func TestMemoryGameStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryGameStore()
+
+	last, err := s.Last(ctx, lichessorg, "u")
+	if err != nil || last != nil {
+		t.Fatalf("Last() of the empty store = %+v, %v, want nil", last, err)
+	}
+
+	games := lichessGames("u", 3)
+
+	for _, g := range []*Game{games[2], games[0], games[1]} {
+		action, err := s.Plan(ctx, g)
+		if err != nil || action != ActionCreate {
+			t.Errorf("Plan(%s) = %v, %v, want %v", g.ID, action, err, ActionCreate)
+		}
+
+		err = s.Process(ctx, g)
+		if err != nil {
+			t.Fatalf("Process(%s) error = %v", g.ID, err)
+		}
+	}
+
+	last, err = s.Last(ctx, lichessorg, "U")
+	if err != nil || last != games[2] {
+		t.Errorf("Last() = %+v, %v, want game 2", last, err)
+	}
+
+	action, err := s.Plan(ctx, games[1])
+	if err != nil || action != ActionSkip {
+		t.Errorf("Plan() of the stored game = %v, %v, want %v", action, err, ActionSkip)
+	}
+
+	changed := *games[1]
+	changed.Status = "mate"
+
+	action, err = s.Plan(ctx, &changed)
+	if err != nil || action != ActionUpdate {
+		t.Errorf("Plan() of the changed game = %v, %v, want %v", action, err, ActionUpdate)
+	}
+
+	it, err := s.ListGames(ctx, lichessorg, "u")
+	if err != nil {
+		t.Fatalf("ListGames() error = %v", err)
+	}
+
+	if got := listIDs(t, it); got != "0,1,2" {
+		t.Errorf("ListGames() = %s, want 0,1,2", got)
+	}
+}
+
+func TestMemoryGameStoreKeepsTheGameOfEveryUser(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryGameStore()
+
+	for _, userID := range []string{"alice", "bob"} {
+		_ = store.Process(ctx, &Game{ID: "g1", Source: lichessorg, UserID: userID})
+	}
+
+	for _, userID := range []string{"alice", "bob"} {
+		last, err := store.Last(ctx, lichessorg, userID)
+		if err != nil || last == nil || last.UserID != userID {
+			t.Errorf("Last() of %s = %+v, %v, want the game of the user", userID, last, err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package chessarchive
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Metrics collects the throughput of every processor over all runs of the archiver
+type Metrics struct {
+	mu         sync.Mutex
+	processors map[string]*ProcessorStats
+}
+
+// ProcessorStats is the throughput of a processor, the rate is measured between its first and last processed game
+type ProcessorStats struct {
+	Name       string        `json:"name"`
+	Processed  uint64        `json:"processed"`
+	Failed     uint64        `json:"failed"`
+	InFlight   int           `json:"in_flight"`
+	Busy       time.Duration `json:"busy"`       //summed over the concurrent games
+	Throughput float64       `json:"throughput"` //games per second
+
+	first time.Time
+	last  time.Time
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{processors: map[string]*ProcessorStats{}}
+}
+
+// start marks a game entering the processor, the returned function records its outcome
+func (m *Metrics) start(name string) func(err error) {
+	started := time.Now()
+
+	m.mu.Lock()
+	stats := m.stats(name)
+	stats.InFlight++
+
+	if stats.first.IsZero() {
+		stats.first = started
+	}
+	m.mu.Unlock()
+
+	return func(err error) {
+		finished := time.Now()
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		stats.InFlight--
+		stats.Busy += finished.Sub(started)
+		stats.last = finished
+
+		if err != nil {
+			stats.Failed++
+		} else {
+			stats.Processed++
+		}
+	}
+}
+
+func (m *Metrics) stats(name string) *ProcessorStats {
+	stats, ok := m.processors[name]
+	if !ok {
+		stats = &ProcessorStats{Name: name}
+		m.processors[name] = stats
+	}
+
+	return stats
+}
+
+// Snapshot returns the current stats of the processors ordered by name
+func (m *Metrics) Snapshot() []ProcessorStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]ProcessorStats, 0, len(m.processors))
+
+	for _, stats := range m.processors {
+		s := *stats
+
+		if elapsed := s.last.Sub(s.first).Seconds(); elapsed > 0 {
+			s.Throughput = float64(s.Processed+s.Failed) / elapsed
+		}
+
+		list = append(list, s)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list
+}
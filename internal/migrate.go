@@ -0,0 +1,480 @@
+package chessarchive
+
+import (
+	"chess-archive/config"
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SchemaVersion is the version of the games written by the processors, it is the version of the last migration
+const SchemaVersion = 2
+
+// userMigration is the version keying the games by their source, user and ID, see gameKey
+const userMigration = 2
+
+// games read by a query of the migration, the progress is saved after every page
+const migrationPage = 200
+
+// Migration upgrades the stored games from the previous version to its version
+type Migration struct {
+	Version     int
+	Description string
+
+	//Up upgrades the game decoded from the stored fields, the fields give access to the renamed or removed ones.
+	//Every field of the game is written back, a stored field unknown to the game is kept unless Up deletes it
+	//from the fields. Every game is migrated in a transaction which may run again, so Up should not depend
+	//on an earlier attempt. The owners are the configured users of the namespace.
+	Up func(g *Game, fields map[string]interface{}, owners Owners) error
+}
+
+// Owners are the configured users of a namespace by source
+type Owners map[Source][]string
+
+// NewOwners returns the users of the sources enabled for them
+func NewOwners(users []config.User) Owners {
+	owners := Owners{}
+
+	for _, u := range users {
+		if u.LichessEnabled() {
+			owners[lichessorg] = append(owners[lichessorg], strings.ToLower(u.LichessUserID))
+		}
+
+		if u.ChessComEnabled() {
+			owners[chessdotcom] = append(owners[chessdotcom], strings.ToLower(u.ChessComUsername))
+		}
+	}
+
+	return owners
+}
+
+// owner returns the source and the user a game stored without them belongs to, the owner has to be the only
+// configured user of the source or the only one who played the game
+func (o Owners) owner(g *Game) (Source, string, error) {
+	type candidate struct {
+		source Source
+		userID string
+	}
+
+	var all, players []candidate
+
+	for _, source := range []Source{lichessorg, chessdotcom} {
+		if g.Source != 0 && g.Source != source {
+			continue
+		}
+
+		for _, userID := range o[source] {
+			all = append(all, candidate{source, userID})
+
+			if g.playedBy(userID) || strings.EqualFold(g.Players.White.Name, userID) || strings.EqualFold(g.Players.Black.Name, userID) {
+				players = append(players, candidate{source, userID})
+			}
+		}
+	}
+
+	switch {
+	case len(players) == 1:
+		return players[0].source, players[0].userID, nil
+	case len(players) == 0 && len(all) == 1:
+		return all[0].source, all[0].userID, nil
+	case len(players) == 0:
+		return 0, "", errors.Errorf("none of the %d users configured for the namespace played the game", len(all))
+	default:
+		return 0, "", errors.Errorf("the %d users configured for the namespace played the game", len(players))
+	}
+}
+
+// migrations are ordered by version, a change of the stored fields adds the migration of the next version
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "parse the headers and the moves of the games stored before the PGN was parsed",
+		Up: func(g *Game, _ map[string]interface{}, _ Owners) error {
+			if len(g.Moves) > 0 || g.PGNError != "" {
+				return nil
+			}
+
+			return errors.WithStack(parsePGN(g))
+		},
+	},
+	{
+		Version:     userMigration,
+		Description: "record the source and the user of the games stored for a single user and key the games by them",
+		Up: func(g *Game, _ map[string]interface{}, owners Owners) error {
+			if g.Source != 0 && g.UserID != "" {
+				return nil
+			}
+
+			source, userID, err := owners.owner(g)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			g.Source, g.UserID = source, userID
+
+			return nil
+		},
+	},
+}
+
+// Migrations returns the migrations ordered by version
+func Migrations() []Migration {
+	return append([]Migration(nil), migrations...)
+}
+
+// MigrationReport counts the games of a namespace seen by a migration run
+type MigrationReport struct {
+	Namespace    string `json:"namespace"`
+	Target       int    `json:"target"`
+	ResumedAfter string `json:"resumed_after,omitempty"` //ID of the last game of the stopped run
+	Scanned      int    `json:"scanned"`
+	Migrated     int    `json:"migrated"` //to migrate in a dry run
+	Completed    bool   `json:"completed"`
+}
+
+// migrationProgress is saved in the migrations collection, so a stopped run goes on after the last saved game
+type migrationProgress struct {
+	Target    int       `firestore:"target"`
+	LastID    string    `firestore:"last_id"`
+	Completed bool      `firestore:"completed"`
+	UpdatedAt time.Time `firestore:"updated_at"`
+}
+
+type Migrator struct {
+	logger          logrus.FieldLogger
+	datastoreClient *firestore.Client
+	namespace       string
+	owners          Owners
+	dryRun          bool
+}
+
+func NewMigrator(
+	logger logrus.FieldLogger,
+	datastoreClient *firestore.Client,
+	namespace string,
+	owners Owners,
+	dryRun bool,
+) *Migrator {
+	return &Migrator{
+		logger:          logger,
+		datastoreClient: datastoreClient,
+		namespace:       namespace,
+		owners:          owners,
+		dryRun:          dryRun,
+	}
+}
+
+// Migrate upgrades every stored game below the target version, the games are walked in the order of their IDs.
+// A stopped run resumes after the last saved page unless restart is set, the games migrated already are skipped anyway.
+func (m *Migrator) Migrate(ctx context.Context, target int, restart bool) (*MigrationReport, error) {
+	err := checkMigrations(target)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	report := &MigrationReport{Namespace: m.namespace, Target: target}
+	progressRef := collection(m.datastoreClient, m.namespace, "migrations").Doc(fmt.Sprintf("v%d", target))
+
+	var progress migrationProgress
+
+	if !restart {
+		doc, err := progressRef.Get(ctx)
+
+		switch {
+		case status.Code(err) == codes.NotFound:
+		case err != nil:
+			return nil, errors.WithStack(err)
+		default:
+			err = doc.DataTo(&progress)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+	}
+
+	if progress.Completed {
+		m.logger.Infof("the games are migrated to version %d already", target)
+
+		report.Completed = true
+
+		return report, nil
+	}
+
+	report.ResumedAfter = progress.LastID
+	games := collection(m.datastoreClient, m.namespace, "games")
+
+	for {
+		query := games.OrderBy(firestore.DocumentID, firestore.Asc).Limit(migrationPage)
+		if progress.LastID != "" {
+			query = query.StartAfter(progress.LastID)
+		}
+
+		docs, err := query.Documents(ctx).GetAll()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if len(docs) == 0 {
+			break
+		}
+
+		for _, doc := range docs {
+			migrated, err := m.migrate(ctx, doc, target)
+			if err != nil {
+				return nil, errors.Wrapf(err, "migrating game ID: %s", doc.Ref.ID)
+			}
+
+			report.Scanned++
+
+			if migrated {
+				report.Migrated++
+			}
+		}
+
+		progress.LastID = docs[len(docs)-1].Ref.ID
+
+		err = m.save(ctx, progressRef, progress, target)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		m.logger.Infof("%d games scanned, %d migrated to version %d, up to game ID: %s", report.Scanned, report.Migrated, target, progress.LastID)
+	}
+
+	progress.Completed = true
+	report.Completed = true
+
+	return report, errors.WithStack(m.save(ctx, progressRef, progress, target))
+}
+
+// migrate upgrades the game in a transaction, so a game written by the archiver meanwhile is not overwritten.
+// The games stored before the user migration are moved to their new key, the legacy game is only deleted
+// when the archiver stored the game by its new key already. It reports whether the game was below the target version.
+func (m *Migrator) migrate(ctx context.Context, doc *firestore.DocumentSnapshot, target int) (bool, error) {
+	if m.dryRun {
+		_, updates, err := upgrade(doc, target, m.owners)
+
+		return updates != nil, errors.WithStack(err)
+	}
+
+	var migrated bool
+
+	err := m.datastoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		current, err := tx.Get(doc.Ref)
+		//deleted since the listing
+		if status.Code(err) == codes.NotFound {
+			migrated = false
+
+			return nil
+		}
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		g, updates, err := upgrade(current, target, m.owners)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		migrated = updates != nil
+
+		if updates == nil {
+			return nil
+		}
+
+		ref := doc.Ref.Parent.Doc(gameKey(g))
+		if target < userMigration || ref.ID == doc.Ref.ID {
+			//the fields are updated one by one, a Set of the game would drop the fields it does not know
+			return errors.WithStack(tx.Update(doc.Ref, updates))
+		}
+
+		_, err = tx.Get(ref)
+
+		switch {
+		case status.Code(err) == codes.NotFound:
+			err = tx.Set(ref, applyUpdates(current.Data(), updates))
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		case err != nil:
+			return errors.WithStack(err)
+		}
+
+		return errors.WithStack(tx.Delete(doc.Ref))
+	})
+
+	return migrated, errors.WithStack(err)
+}
+
+func (m *Migrator) save(ctx context.Context, ref *firestore.DocumentRef, progress migrationProgress, target int) error {
+	if m.dryRun {
+		return nil
+	}
+
+	progress.Target = target
+	progress.UpdatedAt = time.Now().UTC()
+
+	_, err := ref.Set(ctx, progress)
+
+	return errors.WithStack(err)
+}
+
+// upgrade runs the migrations the stored game misses up to the target version and returns the migrated game
+// with its writes, no writes means the game is up to date
+func upgrade(doc *firestore.DocumentSnapshot, target int, owners Owners) (*Game, []firestore.Update, error) {
+	fields := doc.Data()
+
+	var g Game
+
+	err := doc.DataTo(&g)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	//the games stored before the versioning have no version
+	version, _ := fields["schema_version"].(int64)
+	if int(version) >= target {
+		return &g, nil, nil
+	}
+
+	for _, migration := range migrations {
+		if migration.Version <= int(version) || migration.Version > target {
+			continue
+		}
+
+		err = migration.Up(&g, fields, owners)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "migration %d", migration.Version)
+		}
+	}
+
+	g.SchemaVersion = target
+
+	return &g, gameUpdates(&g, doc.Data(), fields), nil
+}
+
+// applyUpdates returns the stored fields with the updates applied
+func applyUpdates(stored map[string]interface{}, updates []firestore.Update) map[string]interface{} {
+	fields := make(map[string]interface{}, len(stored))
+	for name, value := range stored {
+		fields[name] = value
+	}
+
+	for _, u := range updates {
+		if u.Value == firestore.Delete {
+			delete(fields, u.Path)
+
+			continue
+		}
+
+		fields[u.Path] = u.Value
+	}
+
+	return fields
+}
+
+// userKeyed reports whether the games of the namespace were migrated to the user migration, the games stored
+// before it have no user and are only found by the queries which do not filter by user
+func userKeyed(ctx context.Context, client *firestore.Client, namespace string) (bool, error) {
+	var refs []*firestore.DocumentRef
+
+	for version := userMigration; version <= SchemaVersion; version++ {
+		refs = append(refs, collection(client, namespace, "migrations").Doc(fmt.Sprintf("v%d", version)))
+	}
+
+	docs, err := client.GetAll(ctx, refs)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	for _, doc := range docs {
+		if !doc.Exists() {
+			continue
+		}
+
+		var progress migrationProgress
+
+		err = doc.DataTo(&progress)
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+
+		if progress.Completed {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// gameUpdates sets every field of the game, the empty fields omitted by the game and the stored fields
+// the migrations removed from the fields are deleted
+func gameUpdates(g *Game, stored, fields map[string]interface{}) []firestore.Update {
+	var updates []firestore.Update
+
+	known := map[string]bool{}
+	v := reflect.ValueOf(*g)
+
+	for i := 0; i < v.NumField(); i++ {
+		tag := strings.Split(v.Type().Field(i).Tag.Get("firestore"), ",")
+		if tag[0] == "" || tag[0] == "-" {
+			continue
+		}
+
+		known[tag[0]] = true
+
+		value := v.Field(i)
+		empty := value.IsZero() || ((value.Kind() == reflect.Map || value.Kind() == reflect.Slice) && value.Len() == 0)
+
+		if len(tag) > 1 && tag[1] == "omitempty" && empty {
+			if _, ok := stored[tag[0]]; ok {
+				updates = append(updates, firestore.Update{Path: tag[0], Value: firestore.Delete})
+			}
+
+			continue
+		}
+
+		updates = append(updates, firestore.Update{Path: tag[0], Value: value.Interface()})
+	}
+
+	for name := range stored {
+		if _, ok := fields[name]; !ok && !known[name] {
+			updates = append(updates, firestore.Update{Path: name, Value: firestore.Delete})
+		}
+	}
+
+	sort.Slice(updates, func(i, j int) bool {
+		return updates[i].Path < updates[j].Path
+	})
+
+	return updates
+}
+
+// checkMigrations makes sure the migrations follow each other up to the schema version and the target is one of them
+func checkMigrations(target int) error {
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return errors.Errorf("migration %d is out of order, version %d is expected", migration.Version, i+1)
+		}
+	}
+
+	if len(migrations) != SchemaVersion {
+		return errors.Errorf("the last migration should have the schema version %d", SchemaVersion)
+	}
+
+	if target < 1 || target > SchemaVersion {
+		return errors.Errorf("target version %d should be between 1 and %d", target, SchemaVersion)
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package chessarchive
+
+import (
+	"chess-archive/config"
+	"context"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/sirupsen/logrus/hooks/test"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestGameUpdates(t *testing.T) {
+	g := &Game{ID: "g1", Source: lichessorg, PGN: "1. e4 *", Moves: []Move{}, SchemaVersion: 1}
+	stored := map[string]interface{}{"id": "g1", "moves": []interface{}{}, "pgn_error": "x", "legacy": 1, "renamed": 2}
+	fields := map[string]interface{}{"id": "g1", "moves": []interface{}{}, "pgn_error": "x", "legacy": 1}
+
+	paths := map[string]interface{}{}
+
+	for _, u := range gameUpdates(g, stored, fields) {
+		paths[u.Path] = u.Value
+	}
+
+	for _, path := range []string{"moves", "pgn_error", "renamed"} {
+		if paths[path] != firestore.Delete {
+			t.Errorf("update of %s = %v, want it deleted", path, paths[path])
+		}
+	}
+
+	if _, ok := paths["legacy"]; ok {
+		t.Errorf("the unknown field kept by the migrations is updated")
+	}
+
+	if _, ok := paths["opening"]; ok {
+		t.Errorf("the omitted field which is not stored is deleted")
+	}
+
+	if paths["pgn"] != "1. e4 *" || paths["schema_version"] != 1 {
+		t.Errorf("updates = %v, want every field of the game set", paths)
+	}
+}
+
+// storeLegacyGame writes a game the way the versions before the user migration did, keyed by its ID without a user
+func storeLegacyGame(t *testing.T, client *firestore.Client, id string, playedAt int64) {
+	t.Helper()
+
+	_, err := client.Collection("games").Doc(id).Set(context.Background(), map[string]interface{}{
+		"id":           id,
+		"source":       int64(lichessorg),
+		"played_at":    playedAt,
+		"pgn":          "[Event \"x\"]\n\n1. e4 *",
+		"players":      map[string]interface{}{"white": map[string]interface{}{"id": "Alice"}, "black": map[string]interface{}{"id": "bob"}},
+		"legacy_field": "kept",
+	})
+	if err != nil {
+		t.Fatalf("Set(%s) error = %v", id, err)
+	}
+}
+
+func TestMigratorKeysTheLegacyGamesByUser(t *testing.T) {
+	ctx := context.Background()
+	logger, _ := test.NewNullLogger()
+	f, client := newFakeFirestore(t)
+	storage := NewDataStoreGameStorage(logger, client, "")
+
+	storeLegacyGame(t, client, "g1", 1000)
+	storeLegacyGame(t, client, "g2", 2000)
+
+	//g2 was archived again under its new key before the migration
+	err := newTestDataStoreProcessor(client, "").Process(ctx, &Game{ID: "g2", Source: lichessorg, UserID: "alice", PlayedAt: 2000, SchemaVersion: SchemaVersion})
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	last, err := storage.Last(ctx, lichessorg, "Alice")
+	if err != nil || last == nil || last.ID != "g2" {
+		t.Fatalf("Last() before the migration = %v, %v, want g2", last, err)
+	}
+
+	games, err := newTestDataStoreProcessor(client, "").ListGames(ctx, lichessorg, "alice")
+	if err != nil {
+		t.Fatalf("ListGames() error = %v", err)
+	}
+
+	if got := listIDs(t, games); got != "g1,g2" {
+		t.Errorf("ListGames() before the migration = %s, want g1,g2", got)
+	}
+
+	migrator := NewMigrator(logger, client, "", NewOwners([]config.User{{LichessUserID: "Alice"}}), false)
+
+	report, err := migrator.Migrate(ctx, SchemaVersion, false)
+	if err != nil || !report.Completed || report.Migrated != 2 {
+		t.Fatalf("Migrate() = %+v, %v, want the 2 legacy games migrated", report, err)
+	}
+
+	want := []string{"games/lichess_alice_g1", "games/lichess_alice_g2"}
+	if got := f.paths("games"); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("games after the migration = %v, want %v", got, want)
+	}
+
+	fields := f.fields("games/lichess_alice_g1")
+	if fields["user_id"].GetStringValue() != "alice" || fields["legacy_field"].GetStringValue() != "kept" || len(fields["moves"].GetArrayValue().GetValues()) != 1 {
+		t.Errorf("migrated game = %v, want the user, the moves and the unknown field", fields)
+	}
+
+	last, err = storage.Last(ctx, lichessorg, "alice")
+	if err != nil || last == nil || last.ID != "g2" {
+		t.Errorf("Last() after the migration = %v, %v, want g2", last, err)
+	}
+}
+
+func TestMigratorRunner(t *testing.T) {
+	ctx := context.Background()
+	logger, _ := test.NewNullLogger()
+	f, client := newFakeFirestore(t)
+
+	//stored by the current version, the migrations would write every field of the game
+	versioned := map[string]*pb.Value{
+		"id":             {ValueType: &pb.Value_StringValue{StringValue: "g1"}},
+		"source":         {ValueType: &pb.Value_IntegerValue{IntegerValue: int64(lichessorg)}},
+		"user_id":        {ValueType: &pb.Value_StringValue{StringValue: "alice"}},
+		"schema_version": {ValueType: &pb.Value_IntegerValue{IntegerValue: SchemaVersion}},
+	}
+	f.put("games/lichess_alice_g1", versioned)
+
+	//keyed by the user already but stored before the schema version, the fields are updated in place
+	f.put("games/lichess_alice_g2", map[string]*pb.Value{
+		"id":             {ValueType: &pb.Value_StringValue{StringValue: "g2"}},
+		"source":         {ValueType: &pb.Value_IntegerValue{IntegerValue: int64(lichessorg)}},
+		"user_id":        {ValueType: &pb.Value_StringValue{StringValue: "alice"}},
+		"pgn":            {ValueType: &pb.Value_StringValue{StringValue: "[Event \"x\"]\n\n1. e4 *"}},
+		"schema_version": {ValueType: &pb.Value_IntegerValue{IntegerValue: 1}},
+		"legacy_field":   {ValueType: &pb.Value_StringValue{StringValue: "kept"}},
+	})
+
+	migrator := NewMigrator(logger, client, "", NewOwners([]config.User{{LichessUserID: "alice"}}), false)
+
+	report, err := migrator.Migrate(ctx, SchemaVersion, false)
+	if err != nil || !report.Completed || report.Scanned != 2 || report.Migrated != 1 {
+		t.Fatalf("Migrate() = %+v, %v, want 2 games scanned and g2 migrated", report, err)
+	}
+
+	if got := f.fields("games/lichess_alice_g1"); !proto.Equal(&pb.MapValue{Fields: got}, &pb.MapValue{Fields: versioned}) {
+		t.Errorf("versioned game = %v, want it untouched", got)
+	}
+
+	migrated := f.fields("games/lichess_alice_g2")
+	if migrated["schema_version"].GetIntegerValue() != SchemaVersion || migrated["legacy_field"].GetStringValue() != "kept" {
+		t.Errorf("migrated game = %v, want the schema version and the unknown field", migrated)
+	}
+
+	before := map[string]*pb.MapValue{}
+	for _, path := range f.paths("games") {
+		before[path] = &pb.MapValue{Fields: f.fields(path)}
+	}
+
+	//the completed run is not walked again
+	report, err = migrator.Migrate(ctx, SchemaVersion, false)
+	if err != nil || !report.Completed || report.Scanned != 0 {
+		t.Errorf("Migrate() again = %+v, %v, want the completed run skipped", report, err)
+	}
+
+	//a restarted run finds every game up to date
+	report, err = migrator.Migrate(ctx, SchemaVersion, true)
+	if err != nil || !report.Completed || report.Scanned != 2 || report.Migrated != 0 {
+		t.Errorf("Migrate() restarted = %+v, %v, want 2 games scanned and none migrated", report, err)
+	}
+
+	for _, path := range f.paths("games") {
+		if got := (&pb.MapValue{Fields: f.fields(path)}); !proto.Equal(got, before[path]) {
+			t.Errorf("%s after the restarted run = %v, want %v", path, got, before[path])
+		}
+	}
+}
+
+func TestOwnersOwner(t *testing.T) {
+	owners := NewOwners([]config.User{{LichessUserID: "alice"}, {LichessUserID: "bob", ChessComUsername: "bob"}})
+
+	g := &Game{Source: lichessorg}
+	g.Players.White.ID = "Bob"
+	g.Players.Black.ID = "carol"
+
+	source, userID, err := owners.owner(g)
+	if err != nil || source != lichessorg || userID != "bob" {
+		t.Errorf("owner() = %v, %s, %v, want the only configured player", source, userID, err)
+	}
+
+	g.Players.Black.ID = "alice"
+
+	_, _, err = owners.owner(g)
+	if err == nil {
+		t.Errorf("owner() error = nil, want the game of two configured users rejected")
+	}
+
+	g = &Game{Source: chessdotcom}
+
+	source, userID, err = owners.owner(g)
+	if err != nil || source != chessdotcom || userID != "bob" {
+		t.Errorf("owner() = %v, %s, %v, want the only configured user of the source", source, userID, err)
+	}
+}
@@ -1,40 +1,77 @@
 package chessarchive
 
 import (
+	"chess-archive/config"
 	"chess-archive/pkg/google/drive"
+	"chess-archive/pkg/retry"
 	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"cloud.google.com/go/firestore"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type Processor interface {
+	//Name identifies the processor in the dead letters and in the run summary
+	Name() string
+
 	Process(ctx context.Context, game *Game) error
 }
 
+// Action is what a processor does with a game
+type Action string
+
+const (
+	ActionCreate = Action("create")
+	ActionUpdate = Action("update")
+	ActionSkip   = Action("skip")
+)
+
+// Planner is implemented by the processors able to tell what they would do with a game without writing anything
+type Planner interface {
+	Plan(ctx context.Context, game *Game) (Action, error)
+}
+
 type GDriveStoreProcessor struct {
 	folderID    string
+	layout      *Layout
+	folders     *driveFolders
+	legacy      *legacyFiles
 	gdClient    drive.GDriveClient
-	transformer *LichessTransformer
+	transformer *FileTransformer
 	logger      logrus.FieldLogger
 }
 
 func NewDriveStoreProcessor(
 	folderID string,
+	layout *Layout,
 	gdClient drive.GDriveClient,
-	transformer *LichessTransformer,
+	transformer *FileTransformer,
 	logger logrus.FieldLogger,
 ) *GDriveStoreProcessor {
 	return &GDriveStoreProcessor{
 		folderID:    folderID,
+		layout:      layout,
+		folders:     newDriveFolders(gdClient, folderID),
+		legacy:      newLegacyFiles(gdClient, folderID),
 		gdClient:    gdClient,
 		transformer: transformer,
 		logger:      logger,
 	}
 }
 
+func (d *GDriveStoreProcessor) Name() string {
+	return config.ProcessorDrive
+}
+
 func (d *GDriveStoreProcessor) Process(ctx context.Context, g *Game) error {
 	d.logger.Debugf("GDriveStoreProcessor process game ID: %s", g.ID)
 
@@ -44,40 +81,363 @@ func (d *GDriveStoreProcessor) Process(ctx context.Context, g *Game) error {
 		return errors.WithStack(err)
 	}
 
-	_, err = d.gdClient.Create(ctx, d.folderID, file)
+	folderID, err := d.folders.resolve(ctx, d.layout.Resolve(g))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	existing, err := d.find(ctx, file)
 
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
+	if existing == nil {
+		_, err = d.gdClient.Create(ctx, folderID, file)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		return nil
+	}
+
+	if !stringInSlice(folderID, existing.Parents) {
+		err = d.gdClient.Move(ctx, existing.ID, folderID)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		d.logger.Debugf("GDriveStoreProcessor game ID: %s moved to folder ID: %s", g.ID, folderID)
+	}
+
+	if upToDate(existing, file) {
+		d.logger.Debugf("GDriveStoreProcessor game ID: %s is up to date, skipped", g.ID)
+
+		return nil
+	}
+
+	err = d.gdClient.Update(ctx, existing.ID, file)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	d.logger.Debugf("GDriveStoreProcessor game ID: %s updated", g.ID)
+
 	return nil
 }
 
+// Plan looks the archived game up, the missing layout folders are not created
+func (d *GDriveStoreProcessor) Plan(ctx context.Context, g *Game) (Action, error) {
+	file, err := d.transformer.TransformToFile(g)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	folderID, err := d.folders.lookup(ctx, d.layout.Resolve(g))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	existing, err := d.find(ctx, file)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	switch {
+	case existing == nil:
+		return ActionCreate, nil
+	case folderID == "" || !stringInSlice(folderID, existing.Parents) || !upToDate(existing, file):
+		return ActionUpdate, nil
+	default:
+		return ActionSkip, nil
+	}
+}
+
+// upToDate reports whether the archived file has the name, content and tags of the game,
+// so the files archived before a tag was introduced get it the next time the game is processed
+func upToDate(existing, file *drive.File) bool {
+	if existing.Name != file.Name || existing.Checksum != file.Checksum {
+		return false
+	}
+
+	for key, value := range file.Tags {
+		if existing.Tag(key) != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// find looks the archived game up by its game ID, source and user tags below the archive folder,
+// so the file of the same game archived for another user or into another archive is never taken over.
+// The files tagged before the source and user tags were introduced are matched by the game ID only
+// and the files uploaded flat before tagging by name, both are looked up in the index of the legacy files.
+func (d *GDriveStoreProcessor) find(ctx context.Context, file *drive.File) (*drive.File, error) {
+	files, err := d.gdClient.FindByTags(ctx, map[string]string{
+		gameIDTag: file.Tag(gameIDTag),
+		sourceTag: file.Tag(sourceTag),
+		userTag:   file.Tag(userTag),
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	files, err = d.archived(ctx, files)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if len(files) == 0 {
+		files, err = d.legacy.find(ctx, file)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	if len(files) > 1 {
+		d.logger.Warnf("GDriveStoreProcessor found %d files of game ID: %s", len(files), file.Tag(gameIDTag))
+	}
+
+	return files[0], nil
+}
+
+// archived keeps the files stored below the archive folder
+func (d *GDriveStoreProcessor) archived(ctx context.Context, files []*drive.File) ([]*drive.File, error) {
+	var kept []*drive.File
+
+	for _, f := range files {
+		inside, err := d.folders.contains(ctx, f.Parents)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if inside {
+			kept = append(kept, f)
+		}
+	}
+
+	return kept, nil
+}
+
+// legacyFiles indexes the files of the archive folder archived before the source and user tags, the archive is
+// listed once per run instead of searching every game in the whole Drive by its game ID and by its name
+type legacyFiles struct {
+	mu       sync.Mutex
+	gdClient drive.GDriveClient
+	folderID string
+	loaded   bool
+	byID     map[string][]*drive.File //files below the archive folder tagged with the game ID only
+	byName   map[string][]*drive.File //files of the archive folder itself uploaded before tagging
+}
+
+func newLegacyFiles(gdClient drive.GDriveClient, folderID string) *legacyFiles {
+	return &legacyFiles{gdClient: gdClient, folderID: folderID}
+}
+
+// find returns the legacy files of the game and takes them out of the index, the index is loaded by the first call
+func (l *legacyFiles) find(ctx context.Context, file *drive.File) ([]*drive.File, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.loaded {
+		files, err := l.gdClient.FilesFromFolder(ctx, l.folderID, true)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		l.byID = map[string][]*drive.File{}
+		l.byName = map[string][]*drive.File{}
+
+		for _, f := range files {
+			switch {
+			case f.Tag(gameIDTag) != "" && f.Tag(userTag) == "":
+				l.byID[f.Tag(gameIDTag)] = append(l.byID[f.Tag(gameIDTag)], f)
+			case f.Tag(gameIDTag) == "" && stringInSlice(l.folderID, f.Parents):
+				l.byName[f.Name] = append(l.byName[f.Name], f)
+			}
+		}
+
+		l.loaded = true
+	}
+
+	//a legacy file is taken over by a single game, as the file is tagged with the game once archived
+	if files := l.byID[file.Tag(gameIDTag)]; len(files) > 0 {
+		delete(l.byID, file.Tag(gameIDTag))
+
+		return files, nil
+	}
+
+	files := l.byName[file.Name]
+	delete(l.byName, file.Name)
+
+	return files, nil
+}
+
+// ListGames reads every PGN file of the archive folder back, the files which are not games of the user are skipped
+func (d *GDriveStoreProcessor) ListGames(ctx context.Context, source Source, userID string) (GameIterator, error) {
+	files, err := d.gdClient.FilesFromFolder(ctx, d.folderID, true)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var games []*Game
+
+	for _, f := range files {
+		//only the files archived before the properties were introduced are read to tell their source and user
+		if tagged := f.Tag(sourceTag); tagged != "" && (tagged != source.String() || f.Tag(userTag) != strings.ToLower(userID)) {
+			continue
+		}
+
+		g, err := readDriveGame(ctx, d.gdClient, d.transformer, f.ID)
+		if err != nil {
+			d.logger.WithError(err).Warnf("GDriveStoreProcessor skipped file ID: %s", f.ID)
+
+			continue
+		}
+
+		if g.Source == source && strings.EqualFold(g.UserID, userID) {
+			games = append(games, g)
+		}
+	}
+
+	sort.SliceStable(games, func(i, j int) bool {
+		return games[i].PlayedAt < games[j].PlayedAt
+	})
+
+	return gamesIterator(ctx, games), nil
+}
+
 type DataStoreProcessor struct {
 	logger          logrus.FieldLogger
-	transformer     *LichessTransformer
 	datastoreClient *firestore.Client
+	namespace       string
+	retry           retry.Policy
+	batch           *firestoreBatcher //nil when every game is written on its own
 }
 
 func NewDataStoreProcessor(
 	logger logrus.FieldLogger,
-	transformer *LichessTransformer,
 	datastoreClient *firestore.Client,
+	namespace string,
+	policy retry.Policy,
 ) *DataStoreProcessor {
 	return &DataStoreProcessor{
 		logger:          logger,
-		transformer:     transformer,
 		datastoreClient: datastoreClient,
+		namespace:       namespace,
+		retry:           policy,
 	}
 }
 
+// WithBatching makes the processor write the games of the concurrent Process calls in batches of up to size games,
+// a game waits at most the flush interval for its batch to fill up. Sizes below 2 keep writing every game on its own.
+func (d *DataStoreProcessor) WithBatching(size int, flush time.Duration) *DataStoreProcessor {
+	if size > 1 {
+		d.batch = newFirestoreBatcher(d, size, flush)
+	}
+
+	return d
+}
+
+func (d *DataStoreProcessor) Name() string {
+	return config.ProcessorFirestore
+}
+
 func (d *DataStoreProcessor) Process(ctx context.Context, g *Game) error {
 	d.logger.Debugf("DataStoreProcessor process game ID: %s", g.ID)
 
-	_, err := d.datastoreClient.Collection("games").Doc(g.ID).Set(ctx, g)
+	if d.batch != nil {
+		return errors.WithStack(d.batch.write(ctx, g))
+	}
+
+	return errors.WithStack(d.set(ctx, g))
+}
+
+// Flush writes the buffered games of the batching mode
+func (d *DataStoreProcessor) Flush(ctx context.Context) error {
+	if d.batch == nil {
+		return nil
+	}
+
+	return errors.WithStack(d.batch.Flush(ctx))
+}
+
+func (d *DataStoreProcessor) set(ctx context.Context, g *Game) error {
+	err := d.retry.Do(ctx, func(ctx context.Context) error {
+		_, err := collection(d.datastoreClient, d.namespace, "games").Doc(gameKey(g)).Set(ctx, g)
+
+		return err
+	})
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
 	return nil
 }
+
+func (d *DataStoreProcessor) Plan(ctx context.Context, g *Game) (Action, error) {
+	doc, err := collection(d.datastoreClient, d.namespace, "games").Doc(gameKey(g)).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return ActionCreate, nil
+	}
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	var stored Game
+
+	err = doc.DataTo(&stored)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	if stored.Equal(g) {
+		return ActionSkip, nil
+	}
+
+	return ActionUpdate, nil
+}
+
+// gameKey is the ID of the game document, the users sharing a namespace may have played the same game
+func gameKey(g *Game) string {
+	return fmt.Sprintf("%s_%s_%s", g.Source, strings.ToLower(g.UserID), g.ID)
+}
+
+func (d *DataStoreProcessor) ListGames(ctx context.Context, source Source, userID string) (GameIterator, error) {
+	keyed, err := userKeyed(ctx, d.datastoreClient, d.namespace)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	games := collection(d.datastoreClient, d.namespace, "games")
+
+	if !keyed {
+		d.logger.Warnf("the games are not migrated to version %d yet, the games of the user are searched among all of them", userMigration)
+
+		return newLegacyGames(games.OrderBy("played_at", firestore.Asc).Documents(ctx), source, userID), nil
+	}
+
+	query := games.
+		Where("source", "==", source).
+		Where("user_id", "==", strings.ToLower(userID)).
+		OrderBy("played_at", firestore.Asc)
+
+	return &firestoreGames{iter: query.Documents(ctx)}, nil
+}
+
+func stringInSlice(v string, list []string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+
+	return false
+}
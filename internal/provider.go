@@ -0,0 +1,451 @@
+package chessarchive
+
+import (
+	"bytes"
+	"chess-archive/config"
+	"chess-archive/pkg/chesscom"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/VMAnalytic/lichess-api-client/lichess"
+	"github.com/pkg/errors"
+	"google.golang.org/api/iterator"
+)
+
+type ListOptions struct {
+	Since     int64    //milliseconds, inclusive
+	Until     int64    //milliseconds, exclusive, no upper bound when zero
+	Max       int      //no limit when zero
+	PerfTypes []string //lichess speed names, all when empty
+	Rated     *bool    //rated or casual games only, both when nil
+	Opponent  string   //lower case name of the opponent, any when empty
+}
+
+// before reports whether the game played at the time is inside the upper bound of the options
+func (o ListOptions) before(playedAt int64) bool {
+	return o.Until == 0 || playedAt < o.Until
+}
+
+// accepts reports whether a game passes the filters of the options, the sources without server side filters use it
+func (o ListOptions) accepts(speed string, rated bool, opponent string) bool {
+	if len(o.PerfTypes) > 0 && !stringInSlice(speed, o.PerfTypes) {
+		return false
+	}
+
+	if o.Rated != nil && *o.Rated != rated {
+		return false
+	}
+
+	return o.Opponent == "" || strings.ToLower(opponent) == o.Opponent
+}
+
+type GameProvider interface {
+	//Source is the chess site the games are fetched from
+	Source() Source
+
+	//User is the account on the source whose games are fetched
+	User() string
+
+	//List returns an iterator over the games matching the options, oldest first
+	List(ctx context.Context, opts ListOptions) (GameIterator, error)
+}
+
+type GameIterator interface {
+	//Next returns the next game or iterator.Done when there are no more games
+	Next() (*Game, error)
+
+	//Stop releases the resources held by the iterator
+	Stop()
+}
+
+// GameFetcher is implemented by the providers able to fetch a single game by its ID, it is used to re-archive a game
+type GameFetcher interface {
+	Fetch(ctx context.Context, id string) (*Game, error)
+}
+
+// RawGameDecoder is implemented by the providers able to transform the raw payload of a game again,
+// it is used to retry the games which failed to be transformed
+type RawGameDecoder interface {
+	Decode(raw []byte) (*Game, error)
+}
+
+// TransformError is returned by the game iterators for a game which could not be transformed,
+// the iteration may continue with the next game
+type TransformError struct {
+	GameID   string
+	PlayedAt int64
+	Raw      []byte
+	Err      error
+}
+
+func (e *TransformError) Error() string {
+	return fmt.Sprintf("game ID: %s: %s", e.GameID, e.Err)
+}
+
+func (e *TransformError) Unwrap() error {
+	return e.Err
+}
+
+// pageFunc fetches the next page of games, done reports that there are no pages left.
+// The games of the page which could not be transformed are returned in failed.
+type pageFunc func(ctx context.Context) (games []*Game, failed []*TransformError, done bool, err error)
+
+type pageIterator struct {
+	ctx      context.Context
+	nextPage pageFunc
+	buf      []*Game
+	failed   []*TransformError
+	done     bool
+	err      error
+}
+
+func newPageIterator(ctx context.Context, nextPage pageFunc) *pageIterator {
+	return &pageIterator{ctx: ctx, nextPage: nextPage}
+}
+
+func (it *pageIterator) Next() (*Game, error) {
+	for len(it.buf) == 0 && len(it.failed) == 0 {
+		if it.err != nil {
+			return nil, it.err
+		}
+
+		if it.done {
+			return nil, iterator.Done
+		}
+
+		it.buf, it.failed, it.done, it.err = it.nextPage(it.ctx)
+	}
+
+	if len(it.failed) > 0 {
+		terr := it.failed[0]
+		it.failed = it.failed[1:]
+
+		return nil, terr
+	}
+
+	g := it.buf[0]
+	it.buf = it.buf[1:]
+
+	return g, nil
+}
+
+func (it *pageIterator) Stop() {
+	it.buf = nil
+	it.failed = nil
+	it.done = true
+}
+
+// NewProviders creates the game providers for every source enabled for the user
+func NewProviders(cfg *config.Config, user config.User) ([]GameProvider, error) {
+	var providers []GameProvider
+
+	if user.LichessEnabled() {
+		lichessClient := lichess.NewClient(user.LichessAPIKey, streamingHTTPClient())
+
+		err := lichessClient.SetLimits(1*time.Second, uint(cfg.Lichess.LimitPerSec))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		providers = append(providers, NewLichessProvider(lichessClient, user.LichessUserID, NewLichessTransformer(user.LichessUserID)))
+	}
+
+	if user.ChessComEnabled() {
+		providers = append(providers, NewChessComProvider(chesscom.NewClient(nil), user.ChessComUsername, NewChessComTransformer(user.ChessComUsername)))
+	}
+
+	return providers, nil
+}
+
+// streamingHTTPClient has no overall timeout, so a long game export is not cut off while the body is being read
+func streamingHTTPClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout: time.Second * 5,
+			}).DialContext,
+			ResponseHeaderTimeout: time.Second * 30,
+		},
+	}
+}
+
+type LichessProvider struct {
+	client      *lichess.Client
+	userID      string
+	transformer Transformer
+}
+
+func NewLichessProvider(client *lichess.Client, userID string, transformer Transformer) *LichessProvider {
+	return &LichessProvider{
+		client:      client,
+		userID:      userID,
+		transformer: transformer,
+	}
+}
+
+func (p *LichessProvider) Source() Source {
+	return lichessorg
+}
+
+func (p *LichessProvider) User() string {
+	return p.userID
+}
+
+// List streams the NDJSON game export, games are decoded one by one as they arrive
+func (p *LichessProvider) List(ctx context.Context, opts ListOptions) (GameIterator, error) {
+	u := fmt.Sprintf(
+		"/api/games/user/%s?pgnInJson=true&opening=true&clocks=true&sort=dateAsc&since=%d",
+		p.userID,
+		opts.Since,
+	)
+
+	if opts.Until > 0 {
+		//the lichess bound is inclusive
+		u += fmt.Sprintf("&until=%d", opts.Until-1)
+	}
+
+	if opts.Max > 0 {
+		u += fmt.Sprintf("&max=%d", opts.Max)
+	}
+
+	if len(opts.PerfTypes) > 0 {
+		u += "&perfType=" + url.QueryEscape(strings.Join(opts.PerfTypes, ","))
+	}
+
+	if opts.Rated != nil {
+		u += fmt.Sprintf("&rated=%t", *opts.Rated)
+	}
+
+	if opts.Opponent != "" {
+		u += "&vs=" + url.QueryEscape(opts.Opponent)
+	}
+
+	req, err := p.client.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	req.Header.Set("Accept", "application/x-ndjson")
+
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+
+	go func() {
+		//the client copies the response body into the pipe as it is received
+		_, err := p.client.Do(ctx, req, pw)
+		pw.CloseWithError(err)
+	}()
+
+	return &lichessStream{
+		cancel:   cancel,
+		body:     pr,
+		decoder:  json.NewDecoder(pr),
+		provider: p,
+	}, nil
+}
+
+func (p *LichessProvider) Fetch(ctx context.Context, id string) (*Game, error) {
+	u := fmt.Sprintf("/game/export/%s?pgnInJson=true&opening=true&clocks=true", url.PathEscape(id))
+
+	req, err := p.client.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	var raw bytes.Buffer
+
+	_, err = p.client.Do(ctx, req, &raw)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return p.Decode(raw.Bytes())
+}
+
+func (p *LichessProvider) Decode(raw []byte) (*Game, error) {
+	var lg lichess.Game
+
+	err := json.Unmarshal(raw, &lg)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	g, err := p.transformer.Transform(&lg)
+	if err != nil {
+		return nil, &TransformError{GameID: lg.ID, PlayedAt: lg.CreatedAt, Raw: raw, Err: err}
+	}
+
+	return g, nil
+}
+
+type lichessStream struct {
+	cancel   context.CancelFunc
+	body     *io.PipeReader
+	decoder  *json.Decoder
+	provider *LichessProvider
+}
+
+func (s *lichessStream) Next() (*Game, error) {
+	var raw json.RawMessage
+
+	err := s.decoder.Decode(&raw)
+	if err == io.EOF {
+		return nil, iterator.Done
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return s.provider.Decode(raw)
+}
+
+func (s *lichessStream) Stop() {
+	s.cancel()
+	_ = s.body.Close()
+}
+
+type ChessComProvider struct {
+	client      *chesscom.Client
+	username    string
+	transformer Transformer
+}
+
+func NewChessComProvider(client *chesscom.Client, username string, transformer Transformer) *ChessComProvider {
+	return &ChessComProvider{
+		client:      client,
+		username:    username,
+		transformer: transformer,
+	}
+}
+
+func (p *ChessComProvider) Source() Source {
+	return chessdotcom
+}
+
+func (p *ChessComProvider) User() string {
+	return p.username
+}
+
+func (p *ChessComProvider) List(ctx context.Context, opts ListOptions) (GameIterator, error) {
+	archives, err := p.client.Archives(ctx, p.username)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sinceTime := time.Unix(0, opts.Since*int64(time.Millisecond)).UTC()
+	sinceMonth := time.Date(sinceTime.Year(), sinceTime.Month(), 1, 0, 0, 0, 0, time.UTC)
+	listed := 0
+
+	//every monthly archive is a separate page
+	return newPageIterator(ctx, func(ctx context.Context) ([]*Game, []*TransformError, bool, error) {
+		for len(archives) > 0 && (opts.Max == 0 || listed < opts.Max) {
+			a := archives[0]
+			archives = archives[1:]
+
+			month := time.Date(a.Year, a.Month, 1, 0, 0, 0, 0, time.UTC)
+
+			//skip the monthly archives which ended before the cursor
+			if month.Before(sinceMonth) {
+				continue
+			}
+
+			//the archives are ordered, the later ones are past the upper bound as well
+			if !opts.before(month.UnixNano() / int64(time.Millisecond)) {
+				return nil, nil, true, nil
+			}
+
+			cgames, err := p.client.Games(ctx, p.username, a.Year, a.Month)
+			if err != nil {
+				return nil, nil, false, errors.WithStack(err)
+			}
+
+			var (
+				games  = make([]*Game, 0, len(cgames))
+				failed []*TransformError
+			)
+
+			for _, cg := range cgames {
+				if cg.EndTime*1000 < opts.Since || !opts.before(cg.EndTime*1000) {
+					continue
+				}
+
+				if !opts.accepts(chessComSpeed(cg.TimeClass), cg.Rated, p.opponent(cg)) {
+					continue
+				}
+
+				if opts.Max > 0 && listed == opts.Max {
+					break
+				}
+
+				listed++
+
+				g, err := p.transform(cg)
+				if err != nil {
+					var terr *TransformError
+					if !errors.As(err, &terr) {
+						return nil, nil, false, errors.WithStack(err)
+					}
+
+					failed = append(failed, terr)
+
+					continue
+				}
+
+				games = append(games, g)
+			}
+
+			return games, failed, len(archives) == 0 || (opts.Max > 0 && listed == opts.Max), nil
+		}
+
+		return nil, nil, true, nil
+	}), nil
+}
+
+// opponent returns the username of the other player of the game
+func (p *ChessComProvider) opponent(cg *chesscom.Game) string {
+	if cg.White == nil || cg.Black == nil {
+		return ""
+	}
+
+	if strings.EqualFold(cg.White.Username, p.username) {
+		return cg.Black.Username
+	}
+
+	return cg.White.Username
+}
+
+func (p *ChessComProvider) Decode(raw []byte) (*Game, error) {
+	var cg chesscom.Game
+
+	err := json.Unmarshal(raw, &cg)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return p.transform(&cg)
+}
+
+// transform keeps the raw game in the TransformError, so the game can be retried later
+func (p *ChessComProvider) transform(cg *chesscom.Game) (*Game, error) {
+	g, err := p.transformer.Transform(cg)
+	if err == nil {
+		return g, nil
+	}
+
+	raw, merr := json.Marshal(cg)
+	if merr != nil {
+		return nil, errors.WithStack(merr)
+	}
+
+	return nil, &TransformError{GameID: cg.ID(), PlayedAt: cg.EndTime * 1000, Raw: raw, Err: err}
+}
@@ -0,0 +1,147 @@
+package chessarchive
+
+import (
+	"chess-archive/pkg/chesscom"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serverTransport sends the requests of the chess.com client to the test server
+type serverTransport struct {
+	target *url.URL
+}
+
+func (t serverTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host = t.target.Scheme, t.target.Host
+
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// newTestChessComProvider serves the monthly archives of alice, the archives are the games of each month
+// by path, e.g. 2021/01, and returns the provider with the paths of the requested archives
+func newTestChessComProvider(t *testing.T, archives map[string][]string) (*ChessComProvider, func() []string) {
+	t.Helper()
+
+	var (
+		mu        sync.Mutex
+		requested []string
+		months    []string
+	)
+
+	for month := range archives {
+		months = append(months, month)
+	}
+
+	sort.Strings(months)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/pub/player/alice/games/")
+
+		if path == "archives" {
+			urls := make([]string, 0, len(months))
+			for _, month := range months {
+				urls = append(urls, fmt.Sprintf("%q", "https://api.chess.com/pub/player/alice/games/"+month))
+			}
+
+			_, _ = fmt.Fprintf(w, `{"archives": [%s]}`, strings.Join(urls, ","))
+
+			return
+		}
+
+		games, ok := archives[path]
+		if !ok {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		mu.Lock()
+		requested = append(requested, path)
+		mu.Unlock()
+
+		_, _ = fmt.Fprintf(w, `{"games": [%s]}`, strings.Join(games, ","))
+	}))
+	t.Cleanup(srv.Close)
+
+	target, _ := url.Parse(srv.URL)
+	client := chesscom.NewClient(&http.Client{Transport: serverTransport{target: target}})
+	client.SetLimits(time.Millisecond, 10)
+
+	return NewChessComProvider(client, "alice", NewChessComTransformer("alice")), func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]string(nil), requested...)
+	}
+}
+
+// chessComGame is the JSON of a blitz game of alice which ended at the time
+func chessComGame(id string, end time.Time) string {
+	return fmt.Sprintf(`{
+		"url": "https://www.chess.com/game/live/%s",
+		"pgn": "[Event \"Live Chess\"]\n\n1. e4 e5 1-0",
+		"time_control": "180",
+		"time_class": "blitz",
+		"rated": true,
+		"end_time": %d,
+		"white": {"username": "Alice", "rating": 1500, "result": "win"},
+		"black": {"username": "bob", "rating": 1500, "result": "resigned"}
+	}`, id, end.Unix())
+}
+
+func TestChessComProviderListWalksTheArchivesSinceTheMonth(t *testing.T) {
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2021, month, d, 12, 0, 0, 0, time.UTC)
+	}
+
+	provider, requested := newTestChessComProvider(t, map[string][]string{
+		"2021/01": {chessComGame("1", day(time.January, 10))},
+		"2021/02": {chessComGame("2", day(time.February, 1)), chessComGame("3", day(time.February, 15))},
+		"2021/03": {},
+		"2021/04": {chessComGame("4", day(time.April, 2))},
+	})
+
+	//the cursor is in the middle of February, the January archive ended before it
+	since := day(time.February, 10).UnixNano() / int64(time.Millisecond)
+
+	it, err := provider.List(context.Background(), ListOptions{Since: since})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	defer it.Stop()
+
+	if got := listIDs(t, it); got != "3,4" {
+		t.Errorf("List() = %s, want the games since the cursor across the empty archive", got)
+	}
+
+	if got := strings.Join(requested(), ","); got != "2021/02,2021/03,2021/04" {
+		t.Errorf("requested archives = %s, want the archives from the month of the cursor", got)
+	}
+}
+
+func TestChessComProviderListWithoutGames(t *testing.T) {
+	provider, requested := newTestChessComProvider(t, map[string][]string{"2021/01": {}})
+
+	it, err := provider.List(context.Background(), ListOptions{})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	defer it.Stop()
+
+	if got := listIDs(t, it); got != "" {
+		t.Errorf("List() = %s, want no games", got)
+	}
+
+	if got := strings.Join(requested(), ","); got != "2021/01" {
+		t.Errorf("requested archives = %s, want 2021/01", got)
+	}
+}
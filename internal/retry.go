@@ -0,0 +1,17 @@
+package chessarchive
+
+import (
+	"chess-archive/config"
+	"chess-archive/pkg/retry"
+)
+
+// NewRetryPolicy creates the retry policy of the Google API calls from the config
+func NewRetryPolicy(cfg *config.Config) retry.Policy {
+	policy := retry.DefaultPolicy()
+	policy.MaxAttempts = cfg.Retry.MaxAttempts
+	policy.InitialDelay = cfg.Retry.InitialDelay
+	policy.MaxDelay = cfg.Retry.MaxDelay
+	policy.Deadline = cfg.Retry.Deadline
+
+	return policy
+}
@@ -0,0 +1,79 @@
+package chessarchive
+
+import (
+	"chess-archive/config"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RunSpec narrows down the games of a run, the zero value archives every game played since the checkpoint.
+// The checkpoints are not read when Since is set and not saved when games may be left out by the filters,
+// so a narrowed run never makes the next incremental run skip games.
+type RunSpec struct {
+	Since     time.Time //inclusive
+	Until     time.Time //exclusive
+	MaxGames  int       //per provider, no limit when zero
+	PerfTypes []string  //lichess speed names, e.g. blitz or correspondence, all when empty
+	Rated     *bool     //rated or casual games only, both when nil
+	Opponent  string    //name of the opponent on the source
+
+	GameID string //re-archive only this game, the other settings are ignored
+	Source Source //source of the game to re-archive, every source able to fetch single games when zero
+}
+
+// NewRunSpec creates the run specification from the RUN_* settings
+func NewRunSpec(cfg *config.Config) (RunSpec, error) {
+	spec := RunSpec{
+		MaxGames:  cfg.Run.MaxGames,
+		PerfTypes: cfg.Run.PerfTypes,
+		Opponent:  cfg.Run.Opponent,
+	}
+
+	if cfg.Run.Rated != "" {
+		rated, err := strconv.ParseBool(cfg.Run.Rated)
+		if err != nil {
+			return spec, errors.Wrapf(err, "invalid rated filter %q", cfg.Run.Rated)
+		}
+
+		spec.Rated = &rated
+	}
+
+	return spec, nil
+}
+
+// filtered reports whether games inside the time range may be left out
+func (s RunSpec) filtered() bool {
+	return len(s.PerfTypes) > 0 || s.Rated != nil || s.Opponent != ""
+}
+
+// resumes reports whether the run starts from the checkpoint
+func (s RunSpec) resumes() bool {
+	return s.Since.IsZero()
+}
+
+// savesCheckpoints reports whether every game up to the committed one is archived when the run ends
+func (s RunSpec) savesCheckpoints() bool {
+	return s.resumes() && !s.filtered()
+}
+
+func (s RunSpec) options() ListOptions {
+	opts := ListOptions{
+		Max:       s.MaxGames,
+		PerfTypes: s.PerfTypes,
+		Rated:     s.Rated,
+		Opponent:  strings.ToLower(s.Opponent),
+	}
+
+	if !s.Since.IsZero() {
+		opts.Since = s.Since.UnixNano() / int64(time.Millisecond)
+	}
+
+	if !s.Until.IsZero() {
+		opts.Until = s.Until.UnixNano() / int64(time.Millisecond)
+	}
+
+	return opts
+}
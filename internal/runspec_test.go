@@ -0,0 +1,95 @@
+package chessarchive
+
+import (
+	"chess-archive/config"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestArchiverRunBackfillsWindow(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryGameStore()
+	checkpoints := NewMemoryCheckpointStore()
+	acc := &Account{
+		Name:        "u",
+		Providers:   []GameProvider{NewMemoryGameProvider(lichessorg, "u", 3, lichessGames("u", 10)...)},
+		Checkpoints: checkpoints,
+		Processors:  []Processor{store},
+	}
+
+	_, err := newTestArchiver(&config.Config{}, acc).Run(ctx, RunSpec{Since: time.Unix(2, 0), Until: time.Unix(5, 0)})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	it, err := store.ListGames(ctx, lichessorg, "u")
+	if err != nil {
+		t.Fatalf("ListGames() error = %v", err)
+	}
+
+	if got := listIDs(t, it); got != "2,3,4" {
+		t.Errorf("stored games = %s, want 2,3,4", got)
+	}
+
+	cp, err := checkpoints.Get(ctx, lichessorg, "u")
+	if err != nil || cp != nil {
+		t.Errorf("checkpoint = %+v, %v, want none after a backfill", cp, err)
+	}
+}
+
+func TestArchiverRunLimitsAndFilters(t *testing.T) {
+	ctx := context.Background()
+	games := lichessGames("u", 10)
+
+	for i, g := range games {
+		g.Speed = "blitz"
+		if i%2 == 0 {
+			g.Speed = "rapid"
+		}
+
+		g.Players.White.ID = "u"
+		g.Players.Black.Name = "Opp"
+	}
+
+	store := NewMemoryGameStore()
+	checkpoints := NewMemoryCheckpointStore()
+	acc := &Account{
+		Name:        "u",
+		Providers:   []GameProvider{NewMemoryGameProvider(lichessorg, "u", 3, games...)},
+		GameStorage: store,
+		Checkpoints: checkpoints,
+		Processors:  []Processor{store},
+	}
+
+	cfg := &config.Config{}
+	cfg.Archiver.MaxInFlight = 1
+	a := newTestArchiver(cfg, acc)
+
+	for i := 0; i < 2; i++ {
+		_, err := a.Run(ctx, RunSpec{MaxGames: 3})
+		if err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+	}
+
+	cp, err := checkpoints.Get(ctx, lichessorg, "u")
+	if err != nil || cp == nil || cp.GameID != "5" || len(store.Games()) != 6 {
+		t.Fatalf("checkpoint = %+v, %v with %d games, want game 5 with 6 games", cp, err, len(store.Games()))
+	}
+
+	s, err := a.Run(ctx, RunSpec{PerfTypes: []string{"blitz"}, Opponent: "OPP"})
+	if err != nil {
+		t.Fatalf("filtered Run() error = %v", err)
+	}
+
+	if s.Users[0].Games != 2 {
+		t.Errorf("games of the filtered run = %d, want the blitz games 7 and 9", s.Users[0].Games)
+	}
+
+	//the rapid games left out by the filters are archived by the next incremental run
+	cp, err = checkpoints.Get(ctx, lichessorg, "u")
+	if err != nil || cp == nil || cp.GameID != "5" {
+		t.Errorf("checkpoint = %+v, %v, want game 5", cp, err)
+	}
+}
@@ -0,0 +1,63 @@
+package sqlite
+
+// migrations are applied in order, the schema version is the number of applied migrations (PRAGMA user_version).
+// Never edit an applied migration, append a new one instead.
+var migrations = []string{
+	//the games are keyed by their user as well, so two archived users playing each other keep their own copy
+	`CREATE TABLE openings (
+		id       INTEGER PRIMARY KEY AUTOINCREMENT,
+		eco_code TEXT NOT NULL,
+		name     TEXT NOT NULL,
+		UNIQUE (eco_code, name)
+	);
+
+	CREATE TABLE players (
+		source INTEGER NOT NULL,
+		id     TEXT    NOT NULL,
+		name   TEXT    NOT NULL,
+		PRIMARY KEY (source, id)
+	);
+
+	CREATE TABLE games (
+		source     INTEGER NOT NULL,
+		user_id    TEXT    NOT NULL,
+		id         TEXT    NOT NULL,
+		speed      TEXT    NOT NULL,
+		duration   INTEGER NOT NULL,
+		status     TEXT    NOT NULL,
+		result     TEXT    NOT NULL,
+		played_at  INTEGER NOT NULL,
+		winner     TEXT    NOT NULL,
+		pgn        TEXT    NOT NULL,
+		opening_id INTEGER REFERENCES openings (id),
+		PRIMARY KEY (source, user_id, id)
+	);
+
+	CREATE INDEX games_source_played_at ON games (source, played_at);
+	CREATE INDEX games_source_user_id_played_at ON games (source, user_id, played_at);
+
+	CREATE TABLE game_players (
+		game_source  INTEGER NOT NULL,
+		game_user_id TEXT    NOT NULL,
+		game_id      TEXT    NOT NULL,
+		color        TEXT    NOT NULL CHECK (color IN ('white', 'black')),
+		player_id    TEXT    NOT NULL,
+		rating       INTEGER NOT NULL,
+		PRIMARY KEY (game_source, game_user_id, game_id, color),
+		FOREIGN KEY (game_source, game_user_id, game_id) REFERENCES games (source, user_id, id) ON DELETE CASCADE,
+		FOREIGN KEY (game_source, player_id) REFERENCES players (source, id)
+	);
+
+	CREATE TABLE analysis (
+		game_source  INTEGER NOT NULL,
+		game_user_id TEXT    NOT NULL,
+		game_id      TEXT    NOT NULL,
+		color        TEXT    NOT NULL CHECK (color IN ('white', 'black')),
+		inaccuracy   INTEGER NOT NULL,
+		mistake      INTEGER NOT NULL,
+		blunder      INTEGER NOT NULL,
+		acpl         INTEGER NOT NULL,
+		PRIMARY KEY (game_source, game_user_id, game_id, color),
+		FOREIGN KEY (game_source, game_user_id, game_id) REFERENCES games (source, user_id, id) ON DELETE CASCADE
+	);`,
+}
@@ -0,0 +1,421 @@
+package sqlite
+
+import (
+	"chess-archive/config"
+	chessArchive "chess-archive/internal"
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	//registers the sqlite3 driver
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	white = "white"
+	black = "black"
+)
+
+// Store keeps the archive in a SQLite database, it implements both GameStorage and Processor
+type Store struct {
+	logger logrus.FieldLogger
+	db     *sql.DB
+}
+
+// NewStore opens the database at the path and migrates its schema to the latest version
+func NewStore(ctx context.Context, logger logrus.FieldLogger, path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL", path))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	//sqlite allows a single writer, concurrent processors wait for the connection instead of failing as busy
+	db.SetMaxOpenConns(1)
+
+	s := &Store{logger: logger, db: db}
+
+	err = s.Migrate(ctx)
+	if err != nil {
+		_ = db.Close()
+
+		return nil, errors.WithStack(err)
+	}
+
+	return s, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Migrate applies the migrations which are not applied yet, each one in its own transaction
+func (s *Store) Migrate(ctx context.Context) error {
+	var version int
+
+	err := s.db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if version > len(migrations) {
+		return errors.Errorf("database schema version %d is newer than supported %d", version, len(migrations))
+	}
+
+	for i := version; i < len(migrations); i++ {
+		err = s.tx(ctx, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, migrations[i])
+			if err != nil {
+				return errors.Wrapf(err, "migration %d", i+1)
+			}
+
+			//PRAGMA does not support placeholders
+			_, err = tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", i+1))
+
+			return errors.WithStack(err)
+		})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		s.logger.Infof("sqlite schema migrated to version %d", i+1)
+	}
+
+	return nil
+}
+
+func (s *Store) Last(ctx context.Context, source chessArchive.Source, userID string) (*chessArchive.Game, error) {
+	var id string
+
+	err := s.db.QueryRowContext(
+		ctx,
+		"SELECT id FROM games WHERE source = ? AND user_id = ? ORDER BY played_at DESC LIMIT 1",
+		source,
+		strings.ToLower(userID),
+	).Scan(&id)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return s.Get(ctx, source, userID, id)
+}
+
+// ListGames loads the stored games of the user one by one as the iterator advances
+func (s *Store) ListGames(ctx context.Context, source chessArchive.Source, userID string) (chessArchive.GameIterator, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		"SELECT id FROM games WHERE source = ? AND user_id = ? ORDER BY played_at",
+		source,
+		strings.ToLower(userID),
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	var ids []string
+
+	for rows.Next() {
+		var id string
+
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &games{ctx: ctx, store: s, source: source, userID: userID, ids: ids}, nil
+}
+
+type games struct {
+	ctx    context.Context
+	store  *Store
+	source chessArchive.Source
+	userID string
+	ids    []string
+}
+
+func (it *games) Next() (*chessArchive.Game, error) {
+	for len(it.ids) > 0 {
+		id := it.ids[0]
+		it.ids = it.ids[1:]
+
+		g, err := it.store.Get(it.ctx, it.source, it.userID, id)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		//deleted since the listing
+		if g != nil {
+			return g, nil
+		}
+	}
+
+	return nil, iterator.Done
+}
+
+func (it *games) Stop() {
+	it.ids = nil
+}
+
+// Get returns the stored game of the user, nil if there is no such game.
+// SchemaVersion is left zero, it versions the Firestore documents while the rows follow the database migrations.
+func (s *Store) Get(ctx context.Context, source chessArchive.Source, userID, id string) (*chessArchive.Game, error) {
+	var (
+		g           chessArchive.Game
+		result      string
+		openingName sql.NullString
+		openingECO  sql.NullString
+	)
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT g.id, g.source, g.user_id, g.speed, g.duration, g.status, g.result, g.played_at, g.winner, g.pgn, o.name, o.eco_code
+		FROM games g
+		LEFT JOIN openings o ON o.id = g.opening_id
+		WHERE g.source = ? AND g.user_id = ? AND g.id = ?`,
+		source,
+		strings.ToLower(userID),
+		id,
+	).Scan(
+		&g.ID, &g.Source, &g.UserID, &g.Speed, &g.Duration, &g.Status, &result, &g.PlayedAt, &g.Winner, &g.PGN,
+		&openingName, &openingECO,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	g.UserResult = chessArchive.UserResult(result)
+
+	if openingName.Valid {
+		g.Opening = &chessArchive.Opening{Name: openingName.String, ECOCode: openingECO.String}
+	}
+
+	err = s.loadPlayers(ctx, &g)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &g, nil
+}
+
+func (s *Store) loadPlayers(ctx context.Context, g *chessArchive.Game) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT gp.color, gp.player_id, p.name, gp.rating, a.inaccuracy, a.mistake, a.blunder, a.acpl
+		FROM game_players gp
+		JOIN players p ON p.source = gp.game_source AND p.id = gp.player_id
+		LEFT JOIN analysis a ON a.game_source = gp.game_source AND a.game_user_id = gp.game_user_id
+			AND a.game_id = gp.game_id AND a.color = gp.color
+		WHERE gp.game_source = ? AND gp.game_user_id = ? AND gp.game_id = ?`,
+		g.Source,
+		g.UserID,
+		g.ID,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			color                              string
+			p                                  chessArchive.Player
+			inaccuracy, mistake, blunder, acpl sql.NullInt64
+		)
+
+		err = rows.Scan(&color, &p.ID, &p.Name, &p.Rating, &inaccuracy, &mistake, &blunder, &acpl)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if inaccuracy.Valid {
+			p.Analysis = &chessArchive.Analysis{
+				Inaccuracy: uint16(inaccuracy.Int64),
+				Mistake:    uint16(mistake.Int64),
+				Blunder:    uint16(blunder.Int64),
+				ACPL:       uint16(acpl.Int64),
+			}
+		}
+
+		if color == white {
+			g.Players.White = p
+		} else {
+			g.Players.Black = p
+		}
+	}
+
+	return errors.WithStack(rows.Err())
+}
+
+func (s *Store) Name() string {
+	return config.ProcessorSQLite
+}
+
+// Plan compares the game to the stored one, the parsed PGN and the Firestore schema version are not stored
+// so they are left out of the comparison
+func (s *Store) Plan(ctx context.Context, g *chessArchive.Game) (chessArchive.Action, error) {
+	stored, err := s.Get(ctx, g.Source, g.UserID, g.ID)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	if stored == nil {
+		return chessArchive.ActionCreate, nil
+	}
+
+	projected := *g
+	projected.Headers = nil
+	projected.Moves = nil
+	projected.FinalFEN = ""
+	projected.PGNError = ""
+	projected.SchemaVersion = 0
+
+	if stored.Equal(&projected) {
+		return chessArchive.ActionSkip, nil
+	}
+
+	return chessArchive.ActionUpdate, nil
+}
+
+// Process upserts the game together with its players, analysis and opening
+func (s *Store) Process(ctx context.Context, g *chessArchive.Game) error {
+	s.logger.Debugf("SQLiteStore process game ID: %s", g.ID)
+
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		openingID, err := s.upsertOpening(ctx, tx, g.Opening)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO games (source, user_id, id, speed, duration, status, result, played_at, winner, pgn, opening_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (source, user_id, id) DO UPDATE SET
+				speed = excluded.speed,
+				duration = excluded.duration,
+				status = excluded.status,
+				result = excluded.result,
+				played_at = excluded.played_at,
+				winner = excluded.winner,
+				pgn = excluded.pgn,
+				opening_id = excluded.opening_id`,
+			g.Source, g.UserID, g.ID, g.Speed, g.Duration, g.Status, string(g.UserResult), g.PlayedAt, g.Winner, g.PGN, openingID,
+		)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		err = s.upsertPlayer(ctx, tx, g, white, &g.Players.White)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		return errors.WithStack(s.upsertPlayer(ctx, tx, g, black, &g.Players.Black))
+	})
+}
+
+func (s *Store) upsertOpening(ctx context.Context, tx *sql.Tx, o *chessArchive.Opening) (sql.NullInt64, error) {
+	var id sql.NullInt64
+
+	if o == nil {
+		return id, nil
+	}
+
+	_, err := tx.ExecContext(
+		ctx,
+		"INSERT INTO openings (eco_code, name) VALUES (?, ?) ON CONFLICT (eco_code, name) DO NOTHING",
+		o.ECOCode,
+		o.Name,
+	)
+	if err != nil {
+		return id, errors.WithStack(err)
+	}
+
+	err = tx.QueryRowContext(
+		ctx,
+		"SELECT id FROM openings WHERE eco_code = ? AND name = ?",
+		o.ECOCode,
+		o.Name,
+	).Scan(&id)
+
+	return id, errors.WithStack(err)
+}
+
+func (s *Store) upsertPlayer(ctx context.Context, tx *sql.Tx, g *chessArchive.Game, color string, p *chessArchive.Player) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO players (source, id, name) VALUES (?, ?, ?)
+		ON CONFLICT (source, id) DO UPDATE SET name = excluded.name`,
+		g.Source, p.ID, p.Name,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO game_players (game_source, game_user_id, game_id, color, player_id, rating) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (game_source, game_user_id, game_id, color) DO UPDATE SET
+			player_id = excluded.player_id,
+			rating = excluded.rating`,
+		g.Source, g.UserID, g.ID, color, p.ID, p.Rating,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if p.Analysis == nil {
+		_, err = tx.ExecContext(
+			ctx,
+			"DELETE FROM analysis WHERE game_source = ? AND game_user_id = ? AND game_id = ? AND color = ?",
+			g.Source, g.UserID, g.ID, color,
+		)
+
+		return errors.WithStack(err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO analysis (game_source, game_user_id, game_id, color, inaccuracy, mistake, blunder, acpl)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (game_source, game_user_id, game_id, color) DO UPDATE SET
+			inaccuracy = excluded.inaccuracy,
+			mistake = excluded.mistake,
+			blunder = excluded.blunder,
+			acpl = excluded.acpl`,
+		g.Source, g.UserID, g.ID, color, p.Analysis.Inaccuracy, p.Analysis.Mistake, p.Analysis.Blunder, p.Analysis.ACPL,
+	)
+
+	return errors.WithStack(err)
+}
+
+func (s *Store) tx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = fn(tx)
+	if err != nil {
+		_ = tx.Rollback()
+
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(tx.Commit())
+}
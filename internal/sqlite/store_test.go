@@ -0,0 +1,160 @@
+package sqlite
+
+import (
+	"chess-archive/config"
+	chessArchive "chess-archive/internal"
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func newTestStore(t *testing.T, path string) *Store {
+	t.Helper()
+
+	logger, _ := test.NewNullLogger()
+
+	s, err := NewStore(context.Background(), logger, path)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+
+	return s
+}
+
+func lichessSource(t *testing.T) chessArchive.Source {
+	t.Helper()
+
+	source, err := chessArchive.ParseSource("lichess")
+	if err != nil {
+		t.Fatalf("ParseSource() error = %v", err)
+	}
+
+	return source
+}
+
+func TestStoreProcessUpsertsGame(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "archive.db")
+	s := newTestStore(t, path)
+
+	g := &chessArchive.Game{
+		ID:       "a",
+		Source:   lichessSource(t),
+		UserID:   "w",
+		Speed:    "blitz",
+		PlayedAt: 5,
+		PGN:      "1. e4 *",
+		Opening:  &chessArchive.Opening{Name: "King's Pawn", ECOCode: "B00"},
+	}
+	g.Players.White = chessArchive.Player{ID: "w", Name: "W", Rating: 1500, Analysis: &chessArchive.Analysis{ACPL: 3}}
+	g.Players.Black = chessArchive.Player{ID: "b", Name: "B", Rating: 1400}
+
+	for i := 0; i < 2; i++ {
+		err := s.Process(ctx, g)
+		if err != nil {
+			t.Fatalf("Process() error = %v", err)
+		}
+	}
+
+	action, err := s.Plan(ctx, g)
+	if err != nil || action != chessArchive.ActionSkip {
+		t.Errorf("Plan() of the stored game = %v, %v, want %v", action, err, chessArchive.ActionSkip)
+	}
+
+	//the migrations run again when the database is opened
+	reopened := newTestStore(t, path)
+
+	got, err := reopened.Last(ctx, g.Source, "W")
+	if err != nil {
+		t.Fatalf("Last() error = %v", err)
+	}
+
+	if got == nil || !got.Equal(g) {
+		t.Errorf("Last() = %+v, want %+v", got, g)
+	}
+}
+
+func TestStoreArchivesProviderGames(t *testing.T) {
+	ctx := context.Background()
+	logger, _ := test.NewNullLogger()
+	s := newTestStore(t, filepath.Join(t.TempDir(), "archive.db"))
+	source := lichessSource(t)
+
+	var games []*chessArchive.Game
+
+	for i := 0; i < 25; i++ {
+		games = append(games, &chessArchive.Game{ID: fmt.Sprint(i), Source: source, UserID: "u", PlayedAt: int64(i)})
+	}
+
+	cfg := &config.Config{}
+	cfg.Archiver.MaxInFlight = 4
+
+	acc := &chessArchive.Account{
+		Name:        "u",
+		Providers:   []chessArchive.GameProvider{chessArchive.NewMemoryGameProvider(source, "u", 10, games...)},
+		GameStorage: s,
+		Checkpoints: chessArchive.NewMemoryCheckpointStore(),
+		Processors:  []chessArchive.Processor{s},
+	}
+
+	summary, err := chessArchive.NewArchiver(logger, cfg, []*chessArchive.Account{acc}).Run(ctx, chessArchive.RunSpec{})
+	if err != nil || summary.Failures() != 0 {
+		t.Fatalf("Run() error = %v, failures = %d", err, summary.Failures())
+	}
+
+	it, err := s.ListGames(ctx, source, "u")
+	if err != nil {
+		t.Fatalf("ListGames() error = %v", err)
+	}
+
+	var stored int
+
+	err = chessArchive.EachGame(it, func(g *chessArchive.Game) error {
+		if g.ID != fmt.Sprint(stored) {
+			return fmt.Errorf("game %s listed at position %d", g.ID, stored)
+		}
+
+		stored++
+
+		return nil
+	})
+	if err != nil || stored != len(games) {
+		t.Errorf("ListGames() listed %d games, %v, want %d", stored, err, len(games))
+	}
+
+	last, err := s.Last(ctx, source, "u")
+	if err != nil || last == nil || last.ID != "24" {
+		t.Errorf("Last() = %+v, %v, want game 24", last, err)
+	}
+}
+
+func TestStoreKeepsTheGameOfEveryUser(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t, filepath.Join(t.TempDir(), "archive.db"))
+
+	//both users archive the game they played against each other
+	for _, userID := range []string{"w", "b"} {
+		g := &chessArchive.Game{ID: "a", Source: lichessSource(t), UserID: userID, PlayedAt: 5, PGN: "1. e4 *"}
+		g.Players.White = chessArchive.Player{ID: "w", Rating: 1500}
+		g.Players.Black = chessArchive.Player{ID: "b", Rating: 1400}
+
+		err := s.Process(ctx, g)
+		if err != nil {
+			t.Fatalf("Process() error = %v", err)
+		}
+	}
+
+	for _, userID := range []string{"w", "b"} {
+		got, err := s.Get(ctx, lichessSource(t), userID, "a")
+		if err != nil || got == nil || got.UserID != userID || got.Players.Black.Rating != 1400 {
+			t.Errorf("Get() of user %s = %+v, %v, want the game of the user", userID, got, err)
+		}
+	}
+}
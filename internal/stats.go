@@ -0,0 +1,59 @@
+package chessarchive
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// GameStats summarizes the archived games of a user
+type GameStats struct {
+	Source     string         `json:"source"`
+	UserID     string         `json:"user_id"`
+	Games      int            `json:"games"`
+	Speeds     map[string]int `json:"speeds"`
+	Results    map[string]int `json:"results"`     //win, lose or draw
+	InvalidPGN int            `json:"invalid_pgn"` //games archived with a corrupt or truncated PGN
+	First      int64          `json:"first"`       //milliseconds, when the oldest game was played
+	Last       int64          `json:"last"`        //milliseconds, when the newest game was played
+}
+
+// CollectStats summarizes the games of the user kept by the store
+func CollectStats(ctx context.Context, source Source, userID string, lister GameLister) (*GameStats, error) {
+	stats := &GameStats{
+		Source:  source.String(),
+		UserID:  userID,
+		Speeds:  map[string]int{},
+		Results: map[string]int{},
+	}
+
+	it, err := lister.ListGames(ctx, source, userID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = EachGame(it, func(g *Game) error {
+		stats.Games++
+		stats.Speeds[g.Speed]++
+		stats.Results[string(g.UserResult)]++
+
+		if g.PGNError != "" {
+			stats.InvalidPGN++
+		}
+
+		if stats.First == 0 || g.PlayedAt < stats.First {
+			stats.First = g.PlayedAt
+		}
+
+		if g.PlayedAt > stats.Last {
+			stats.Last = g.PlayedAt
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return stats, nil
+}
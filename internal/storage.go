@@ -1,32 +1,170 @@
 package chessarchive
 
 import (
+	"chess-archive/config"
 	"chess-archive/pkg/google/drive"
 	"context"
+	"strconv"
+	"strings"
 
 	"cloud.google.com/go/firestore"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type GameStorage interface {
-	Last(ctx context.Context) (*Game, error)
+	//Last returns the most recently played game of the user stored for the source
+	Last(ctx context.Context, source Source, userID string) (*Game, error)
 }
 
+// NewGameStorage returns the first store archiving every game of the user, the newest game is looked up in it,
+// so Drive is only queried when it is the only one. The SQLite store is shared by the users and created by the caller.
+func NewGameStorage(
+	cfg *config.Config,
+	user config.User,
+	logger logrus.FieldLogger,
+	files *FileTransformer,
+	datastoreClient *firestore.Client,
+	gdClient drive.GDriveClient,
+	sqliteStore GameStorage,
+) GameStorage {
+	switch {
+	case cfg.ProcessorEnabled(config.ProcessorFirestore):
+		return NewDataStoreGameStorage(logger, datastoreClient, user.Namespace)
+	case cfg.ProcessorEnabled(config.ProcessorSQLite):
+		return sqliteStore
+	case cfg.ProcessorEnabled(config.ProcessorDrive):
+		return NewDriveGameStorage(user.ArchiveFolderID, files, gdClient)
+	default:
+		return nil
+	}
+}
+
+// GameLister is implemented by the stores able to list the archived games, it is used to verify, export and summarize the archive
+type GameLister interface {
+	//ListGames returns an iterator over the stored games of the user on the source, oldest first
+	ListGames(ctx context.Context, source Source, userID string) (GameIterator, error)
+}
+
+// EachGame calls the function with every game of the iterator and stops it afterwards
+func EachGame(it GameIterator, fn func(g *Game) error) error {
+	defer it.Stop()
+
+	for {
+		g, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		err = fn(g)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+}
+
+// gamesIterator iterates over games already loaded in memory
+func gamesIterator(ctx context.Context, games []*Game) GameIterator {
+	return newPageIterator(ctx, func(ctx context.Context) ([]*Game, []*TransformError, bool, error) {
+		return games, nil, true, nil
+	})
+}
+
+// collection returns the Firestore collection of the namespace, the root collection when the namespace is empty
+func collection(client *firestore.Client, namespace, name string) *firestore.CollectionRef {
+	if namespace == "" {
+		return client.Collection(name)
+	}
+
+	return client.Collection("namespaces").Doc(namespace).Collection(name)
+}
+
+// GDriveGameStorage finds the newest game in the Drive archive by the properties of the files
 type GDriveGameStorage struct {
 	folderID     string
-	transformer  *LichessTransformer
+	transformer  *FileTransformer
 	gDriveClient drive.GDriveClient
 }
 
-func (gds *GDriveGameStorage) Last(ctx context.Context) (*Game, error) {
-	f, err := gds.gDriveClient.Latest(ctx, gds.folderID)
+func NewDriveGameStorage(
+	folderID string,
+	transformer *FileTransformer,
+	gDriveClient drive.GDriveClient,
+) *GDriveGameStorage {
+	return &GDriveGameStorage{
+		folderID:     folderID,
+		transformer:  transformer,
+		gDriveClient: gDriveClient,
+	}
+}
+
+// Last reads the file of the user on the source with the highest played_at property,
+// the last uploaded file of the archive folder is read when no file has the properties yet
+func (gds *GDriveGameStorage) Last(ctx context.Context, source Source, userID string) (*Game, error) {
+	files, err := gds.gDriveClient.FindByTags(ctx, map[string]string{
+		sourceTag: source.String(),
+		userTag:   strings.ToLower(userID),
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var (
+		latest   *drive.File
+		playedAt int64
+	)
+
+	for _, f := range files {
+		at, err := strconv.ParseInt(f.Tag(playedAtTag), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		if latest == nil || at > playedAt {
+			latest, playedAt = f, at
+		}
+	}
+
+	if latest == nil {
+		latest, err = gds.gDriveClient.Latest(ctx, gds.folderID)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if latest == nil {
+		return nil, nil
+	}
+
+	game, err := readDriveGame(ctx, gds.gDriveClient, gds.transformer, latest.ID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	//the last uploaded file may hold a game of another source or user
+	if game.Source != source || !strings.EqualFold(game.UserID, userID) {
+		return nil, nil
+	}
+
+	return game, nil
+}
+
+// readDriveGame downloads the file and parses the archived game
+func readDriveGame(ctx context.Context, gdClient drive.GDriveClient, transformer *FileTransformer, fileID string) (*Game, error) {
+	f, err := gdClient.Open(ctx, fileID)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	defer f.Close()
 
-	game, err := gds.transformer.Transform(f)
+	game, err := transformer.Transform(f)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -37,38 +175,142 @@ func (gds *GDriveGameStorage) Last(ctx context.Context) (*Game, error) {
 type DataStoreGameStorage struct {
 	logger          logrus.FieldLogger
 	datastoreClient *firestore.Client
+	namespace       string
 }
 
 func NewDataStoreGameStorage(
 	logger logrus.FieldLogger,
 	datastoreClient *firestore.Client,
+	namespace string,
 ) *DataStoreGameStorage {
 	return &DataStoreGameStorage{
 		logger:          logger,
 		datastoreClient: datastoreClient,
+		namespace:       namespace,
 	}
 }
 
-func (ds *DataStoreGameStorage) Last(ctx context.Context) (*Game, error) {
-	var g Game
+func (ds *DataStoreGameStorage) Last(ctx context.Context, source Source, userID string) (*Game, error) {
+	keyed, err := userKeyed(ctx, ds.datastoreClient, ds.namespace)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 
-	query := ds.datastoreClient.Collection("games").OrderBy("played_at", firestore.Desc).Limit(1)
-	iter := query.Documents(ctx)
-	doc, err := iter.Next()
+	games := collection(ds.datastoreClient, ds.namespace, "games")
+
+	var it GameIterator
+
+	if keyed {
+		it = &firestoreGames{iter: games.
+			Where("source", "==", source).
+			Where("user_id", "==", strings.ToLower(userID)).
+			OrderBy("played_at", firestore.Desc).
+			Limit(1).
+			Documents(ctx)}
+	} else {
+		ds.logger.Warnf("the games are not migrated to version %d yet, the last game is searched among all of them", userMigration)
+
+		it = newLegacyGames(games.OrderBy("played_at", firestore.Desc).Documents(ctx), source, userID)
+	}
+	defer it.Stop()
+
+	g, err := it.Next()
+	if err == iterator.Done {
+		return nil, nil
+	}
 
 	if err != nil {
-		if err == iterator.Done {
-			return nil, nil
-		}
+		return nil, errors.WithStack(err)
+	}
+
+	return g, nil
+}
+
+// ErrMissingIndex is returned by the queries of the games of a user when Firestore has no composite index
+// on (source, user_id, played_at), see the terraform configuration. It is transient as the index may still be building.
+type ErrMissingIndex struct {
+	err error
+}
+
+func (e ErrMissingIndex) Error() string {
+	return "the Firestore index of the games on (source, user_id, played_at) is missing: " + e.err.Error()
+}
+
+func (e ErrMissingIndex) Unwrap() error {
+	return e.err
+}
 
+// Cause implements the causer of github.com/pkg/errors
+func (e ErrMissingIndex) Cause() error {
+	return e.err
+}
+
+type firestoreGames struct {
+	iter *firestore.DocumentIterator
+}
+
+func (it *firestoreGames) Next() (*Game, error) {
+	doc, err := it.iter.Next()
+	if err == iterator.Done {
+		return nil, iterator.Done
+	}
+
+	//the filtered queries fail without the composite index of the games
+	if status.Code(err) == codes.FailedPrecondition {
+		return nil, errors.WithStack(&ErrMissingIndex{err: err})
+	}
+
+	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	err = doc.DataTo(&g)
+	var g Game
 
+	err = doc.DataTo(&g)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
 	return &g, nil
 }
+
+func (it *firestoreGames) Stop() {
+	it.iter.Stop()
+}
+
+// legacyGames keeps the games of the user on the source from a query over every game, the games stored before
+// the user migration have no user and are kept for every user of their source. The games archived again under
+// their new key meanwhile are returned once.
+type legacyGames struct {
+	firestoreGames
+	source Source
+	userID string
+	seen   map[string]bool
+}
+
+func newLegacyGames(iter *firestore.DocumentIterator, source Source, userID string) *legacyGames {
+	return &legacyGames{
+		firestoreGames: firestoreGames{iter: iter},
+		source:         source,
+		userID:         strings.ToLower(userID),
+		seen:           map[string]bool{},
+	}
+}
+
+func (it *legacyGames) Next() (*Game, error) {
+	for {
+		g, err := it.firestoreGames.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if g.Source != it.source || (g.UserID != "" && g.UserID != it.userID) || it.seen[g.ID] {
+			continue
+		}
+
+		it.seen[g.ID] = true
+		g.UserID = it.userID
+
+		return g, nil
+	}
+}
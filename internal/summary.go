@@ -0,0 +1,140 @@
+package chessarchive
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Summary is the outcome of a run
+type Summary struct {
+	Users      []*UserSummary   `json:"users"`
+	Processors []ProcessorStats `json:"processors"` //throughput since the archiver was created
+}
+
+// Failures returns the number of failed games of all users
+func (s *Summary) Failures() int {
+	var n int
+
+	for _, u := range s.Users {
+		for _, c := range u.Failed {
+			n += c
+		}
+	}
+
+	return n
+}
+
+// Games returns the number of games fetched for all users
+func (s *Summary) Games() int {
+	var n int
+
+	for _, u := range s.Users {
+		n += u.Games
+	}
+
+	return n
+}
+
+// Report is the outcome of a run returned by the HTTP endpoints
+type Report struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Summary  *Summary  `json:"summary,omitempty"` //missing when the run could not start
+	Error    string    `json:"error,omitempty"`
+	err      error
+}
+
+func NewReport(started time.Time, summary *Summary, err error) *Report {
+	r := &Report{Started: started, Finished: time.Now(), Summary: summary, err: err}
+
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	return r
+}
+
+// Status returns the HTTP status of the report, the caller may try again later when the run failed on a transient error
+func (r *Report) Status() int {
+	var cmdErr *CommandError
+
+	switch {
+	case r.err == nil:
+		return http.StatusOK
+	case errors.As(r.err, &cmdErr):
+		return http.StatusBadRequest
+	case IsTransient(r.err):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// UserSummary counts the outcomes of the games of the user by stage,
+// the stage is either the processor name or transform for the games which could not be transformed
+type UserSummary struct {
+	mu        sync.Mutex
+	Name      string         `json:"name"`
+	Games     int            `json:"games"` //games fetched from the sources
+	Succeeded map[string]int `json:"succeeded"`
+	Failed    map[string]int `json:"failed"`
+	Retried   int            `json:"retried"`   //dead letters retried
+	Recovered int            `json:"recovered"` //dead letters retried successfully
+	Error     string         `json:"error,omitempty"`
+
+	Planned map[string]map[string]int `json:"planned,omitempty"` //dry run, processor => action => games
+}
+
+func newUserSummary(name string) *UserSummary {
+	return &UserSummary{Name: name, Succeeded: map[string]int{}, Failed: map[string]int{}}
+}
+
+func (s *UserSummary) game() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Games++
+}
+
+func (s *UserSummary) succeed(stage string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Succeeded[stage]++
+}
+
+func (s *UserSummary) fail(stage string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Failed[stage]++
+}
+
+func (s *UserSummary) plan(stage, action string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Planned == nil {
+		s.Planned = map[string]map[string]int{}
+	}
+
+	if s.Planned[stage] == nil {
+		s.Planned[stage] = map[string]int{}
+	}
+
+	s.Planned[stage][action]++
+}
+
+func (s *UserSummary) retry(recovered bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Retried++
+
+	if recovered {
+		s.Recovered++
+	}
+}
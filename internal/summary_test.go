@@ -0,0 +1,30 @@
+package chessarchive
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestReportStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, http.StatusOK},
+		{"invalid command", errors.WithStack(&CommandError{Reason: "x"}), http.StatusBadRequest},
+		{"transient failure", errors.WithStack(status.Error(codes.Unavailable, "x")), http.StatusServiceUnavailable},
+		{"failure", errors.New("boom"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		if got := NewReport(time.Now(), nil, tt.err).Status(); got != tt.want {
+			t.Errorf("%s: Status() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
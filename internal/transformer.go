@@ -1,20 +1,44 @@
 package chessarchive
 
 import (
+	"chess-archive/internal/board"
+	"chess-archive/pkg/chesscom"
 	"chess-archive/pkg/google/drive"
+	"chess-archive/pkg/pgn"
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/VMAnalytic/lichess-api-client/lichess"
 	"github.com/fatih/structs"
 	"github.com/pkg/errors"
 )
 
+// Drive file properties describing the archived game, the Drive game storage queries them
+const (
+	gameIDTag   = "game_id"
+	playedAtTag = "played_at" //milliseconds
+	sourceTag   = "source"
+	userTag     = "user" //archived account on the source
+)
+
+// Transformer maps the games decoded from a source onto Game, every source has its own implementation
+type Transformer interface {
+	//Transform maps the game of the source, the values of the other sources are rejected
+	Transform(v interface{}) (*Game, error)
+}
+
+// LichessTransformer maps the games of the lichess export
 type LichessTransformer struct {
 	userID string
 }
 
-func NewGameTransformer(lichessUserID string) *LichessTransformer {
-	return &LichessTransformer{userID: lichessUserID}
+func NewLichessTransformer(userID string) *LichessTransformer {
+	return &LichessTransformer{userID: strings.ToLower(userID)}
 }
 
 func (t *LichessTransformer) Transform(v interface{}) (*Game, error) {
@@ -23,7 +47,7 @@ func (t *LichessTransformer) Transform(v interface{}) (*Game, error) {
 		return t.transformLichess(game)
 
 	default:
-		return nil, errors.New("unknown type")
+		return nil, errors.Errorf("unknown type %T of a lichess game", v)
 	}
 }
 
@@ -36,12 +60,14 @@ func (t *LichessTransformer) transformLichess(lg *lichess.Game) (*Game, error) {
 
 	g.ID = lg.ID
 	g.Source = lichessorg
+	g.UserID = t.userID
 	g.Speed = lg.Speed
 	g.PlayedAt = lg.CreatedAt
 	g.Winner = lg.Winner
 	g.Status = lg.Status
 	g.UserResult = t.getState(lg)
 	g.PGN = lg.Pgn
+	g.SchemaVersion = SchemaVersion
 	g.Duration = uint16(lg.Clock.TotalTime)
 
 	g.Players.White.ID = lg.Players.White.User.ID
@@ -75,15 +101,305 @@ func (t *LichessTransformer) transformLichess(lg *lichess.Game) (*Game, error) {
 		ECOCode: lg.Opening.Eco,
 	}
 
+	err := parsePGN(&g)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &g, nil
+}
+
+// ChessComTransformer maps the games of the chess.com monthly archives
+type ChessComTransformer struct {
+	username string
+}
+
+func NewChessComTransformer(username string) *ChessComTransformer {
+	return &ChessComTransformer{username: strings.ToLower(username)}
+}
+
+func (t *ChessComTransformer) Transform(v interface{}) (*Game, error) {
+	switch game := v.(type) {
+	case *chesscom.Game:
+		return t.transformChessCom(game)
+
+	default:
+		return nil, errors.Errorf("unknown type %T of a chess.com game", v)
+	}
+}
+
+func (t *ChessComTransformer) transformChessCom(cg *chesscom.Game) (*Game, error) {
+	if cg == nil {
+		return nil, errors.New("game should not be nil")
+	}
+
+	if cg.White == nil || cg.Black == nil {
+		return nil, errors.Errorf("game %s has no players", cg.URL)
+	}
+
+	var g Game
+
+	g.ID = cg.ID()
+	g.Source = chessdotcom
+	g.UserID = t.username
+	g.Speed = chessComSpeed(cg.TimeClass)
+	g.PlayedAt = cg.EndTime * 1000
+	g.PGN = cg.PGN
+	g.SchemaVersion = SchemaVersion
+	g.Duration = uint16(cg.TotalTime())
+
+	switch {
+	case cg.White.Result == "win":
+		g.Winner = "white"
+		g.Status = chessComStatus(cg.Black.Result)
+	case cg.Black.Result == "win":
+		g.Winner = "black"
+		g.Status = chessComStatus(cg.White.Result)
+	default:
+		g.Status = chessComStatus(cg.White.Result)
+	}
+
+	g.UserResult = t.getChessComState(cg, g.Winner)
+
+	g.Players.White.ID = strings.ToLower(cg.White.Username)
+	g.Players.White.Name = cg.White.Username
+	g.Players.White.Rating = uint16(cg.White.Rating)
+
+	g.Players.Black.ID = strings.ToLower(cg.Black.Username)
+	g.Players.Black.Name = cg.Black.Username
+	g.Players.Black.Rating = uint16(cg.Black.Rating)
+
+	err := parsePGN(&g)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	g.Opening = &Opening{
+		Name:    chessComOpeningName(g.Headers["ECOUrl"]),
+		ECOCode: g.Headers["ECO"],
+	}
+
+	return &g, nil
+}
+
+// FileTransformer maps the games onto the PGN files of the Drive archive and restores the games from the files,
+// the files of both sources are told apart by their PGN headers
+type FileTransformer struct {
+	users map[Source]string //archived account of every source
+}
+
+func NewFileTransformer(lichessUserID, chessComUsername string) *FileTransformer {
+	return &FileTransformer{users: map[Source]string{
+		lichessorg:  strings.ToLower(lichessUserID),
+		chessdotcom: strings.ToLower(chessComUsername),
+	}}
+}
+
+func (t *FileTransformer) Transform(v interface{}) (*Game, error) {
+	switch file := v.(type) {
+	case *drive.File:
+		return t.transformFile(file)
+
+	default:
+		return nil, errors.Errorf("unknown type %T of an archived file", v)
+	}
+}
+
+// transformFile restores the game archived as a PGN file, the file content is read but not closed.
+// The data missing from the PGN, like the analysis of the players, is left empty.
+func (t *FileTransformer) transformFile(f *drive.File) (*Game, error) {
+	if f == nil || f.Media == nil {
+		return nil, errors.New("file content should not be nil")
+	}
+
+	data, err := ioutil.ReadAll(f.Media)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	parsed, err := pgn.ParseGame(string(data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "file %s is not a PGN", f.Name)
+	}
+
+	var g Game
+
+	g.PGN = string(data)
+	g.SchemaVersion = SchemaVersion
+	g.Source, g.ID = pgnSource(parsed)
+
+	userID, ok := t.users[g.Source]
+	if !ok {
+		return nil, errors.Errorf("file %s holds a game of an unknown site %q", f.Name, parsed.Tag("Site"))
+	}
+
+	//the tags are set by the Drive processor, the configured user and the site link are used for the files uploaded before tagging
+	if tagged := f.Tag(userTag); tagged != "" {
+		userID = strings.ToLower(tagged)
+	}
+
+	g.UserID = userID
+
+	if id := f.Tag(gameIDTag); id != "" {
+		g.ID = id
+	}
+
+	if g.ID == "" {
+		return nil, errors.Errorf("file %s holds a game without ID", f.Name)
+	}
+
+	g.PlayedAt, err = pgnPlayedAt(parsed, g.Source)
+	if err != nil {
+		return nil, errors.Wrapf(err, "file %s", f.Name)
+	}
+
+	g.Duration = uint16(timeControlTotal(parsed.Tag("TimeControl")))
+	g.Speed = pgnSpeed(parsed.Tag("TimeControl"))
+
+	switch parsed.Tag("Result") {
+	case "1-0":
+		g.Winner = "white"
+	case "0-1":
+		g.Winner = "black"
+	}
+
+	g.Players.White.ID = strings.ToLower(parsed.Tag("White"))
+	g.Players.White.Name = parsed.Tag("White")
+	g.Players.White.Rating = pgnRating(parsed.Tag("WhiteElo"))
+
+	g.Players.Black.ID = strings.ToLower(parsed.Tag("Black"))
+	g.Players.Black.Name = parsed.Tag("Black")
+	g.Players.Black.Rating = pgnRating(parsed.Tag("BlackElo"))
+
+	switch g.Winner {
+	case "white":
+		g.UserResult = pgnUserResult(g.Players.White, g.UserID)
+	case "black":
+		g.UserResult = pgnUserResult(g.Players.Black, g.UserID)
+	default:
+		g.UserResult = draw
+	}
+
+	g.Opening = &Opening{
+		Name:    parsed.Tag("Opening"),
+		ECOCode: parsed.Tag("ECO"),
+	}
+
+	if g.Source == chessdotcom {
+		g.Opening.Name = chessComOpeningName(parsed.Tag("ECOUrl"))
+	}
+
+	g.Status = pgnStatus(parsed, g.Source, g.Winner)
+
+	err = parsePGN(&g)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
 	return &g, nil
 }
 
-func (t *LichessTransformer) TransformToFile(game *Game) (*drive.File, error) {
+// parsePGN fills the headers and the main line moves of the game from its PGN and replays them.
+// Malformed PGNs do not fail the transformation, the game is flagged with PGNError instead.
+func parsePGN(g *Game) error {
+	if g.PGN == "" {
+		return nil
+	}
+
+	parsed, err := pgn.ParseGame(g.PGN)
+	if err != nil {
+		g.PGNError = errors.Wrap(err, "malformed PGN").Error()
+
+		return nil
+	}
+
+	g.Headers = parsed.Headers()
+	g.Moves = make([]Move, 0, len(parsed.Moves))
+
+	for _, pm := range parsed.Moves {
+		m := Move{
+			Ply:     uint16(pm.Ply),
+			SAN:     pm.SAN,
+			NAGs:    pm.NAGs,
+			Comment: strings.Join(pm.Comments, " "),
+		}
+
+		if pm.Clock != nil {
+			clock := pm.Clock.Milliseconds()
+			m.Clock = &clock
+		}
+
+		if pm.Eval != nil && pm.Eval.Mate != 0 {
+			mate := pm.Eval.Mate
+			m.Mate = &mate
+		} else if pm.Eval != nil {
+			eval := pm.Eval.Centipawns
+			m.Eval = &eval
+		}
+
+		g.Moves = append(g.Moves, m)
+	}
+
+	replay(g)
+
+	return nil
+}
+
+// replay plays the moves from the initial position, it stores the position after every ply
+// and flags the games with illegal moves or with a final position contradicting the game status
+func replay(g *Game) {
+	//only the standard chess rules are supported
+	if variant := g.Headers["Variant"]; variant != "" && variant != "Standard" && variant != "From Position" {
+		return
+	}
+
+	pos := board.NewPosition()
+
+	if fen := g.Headers["FEN"]; fen != "" {
+		var err error
+
+		pos, err = board.ParseFEN(fen)
+		if err != nil {
+			g.PGNError = err.Error()
+
+			return
+		}
+	}
+
+	for i := range g.Moves {
+		m, err := pos.ParseSAN(g.Moves[i].SAN)
+		if err != nil {
+			g.PGNError = errors.Wrapf(err, "ply %d", g.Moves[i].Ply).Error()
+
+			return
+		}
+
+		pos = pos.Play(m)
+		g.Moves[i].FEN = pos.FEN()
+	}
+
+	g.FinalFEN = pos.FEN()
+
+	switch {
+	case g.Status == "mate" && !pos.IsCheckmate():
+		g.PGNError = "truncated PGN: the game ended by mate but the final position is not a checkmate"
+	case g.Status == "stalemate" && !pos.IsStalemate():
+		g.PGNError = "truncated PGN: the game ended by stalemate but the final position is not a stalemate"
+	}
+}
+
+// TransformToFile returns the PGN file of the game tagged with the properties the Drive processor finds it by
+func (t *FileTransformer) TransformToFile(game *Game) (*drive.File, error) {
 	var f drive.File
 
 	f.Name = game.Name()
 	f.Media = strings.NewReader(game.PGN)
 	f.Description = "Test"
+	f.Checksum = fmt.Sprintf("%x", md5.Sum([]byte(game.PGN)))
+	f.AddTag(gameIDTag, game.ID)
+	f.AddTag(playedAtTag, strconv.FormatInt(game.PlayedAt, 10))
+	f.AddTag(sourceTag, game.Source.String())
+	f.AddTag(userTag, strings.ToLower(game.UserID))
 
 	return &f, nil
 }
@@ -94,6 +410,25 @@ func (t *LichessTransformer) TransformToMap(game *Game) map[string]interface{} {
 	return data
 }
 
+func (t *ChessComTransformer) getChessComState(cg *chesscom.Game, winner string) UserResult {
+	switch winner {
+	case "black":
+		if strings.EqualFold(cg.Black.Username, t.username) {
+			return win
+		}
+
+		return lose
+	case "white":
+		if strings.EqualFold(cg.White.Username, t.username) {
+			return win
+		}
+
+		return lose
+	default:
+		return draw
+	}
+}
+
 func (t *LichessTransformer) getState(lg *lichess.Game) UserResult {
 	switch lg.Winner {
 	case "black":
@@ -112,3 +447,176 @@ func (t *LichessTransformer) getState(lg *lichess.Game) UserResult {
 		return draw
 	}
 }
+
+// chessComSpeed maps chess.com time classes onto the lichess speed names
+func chessComSpeed(timeClass string) string {
+	if timeClass == "daily" {
+		return "correspondence"
+	}
+
+	return timeClass
+}
+
+// chessComStatus maps the chess.com result code of the player who did not win onto the lichess status names
+func chessComStatus(result string) string {
+	switch result {
+	case "checkmated":
+		return "mate"
+	case "resigned":
+		return "resign"
+	case "timeout":
+		return "outoftime"
+	case "abandoned":
+		return "timeout"
+	case "stalemate":
+		return "stalemate"
+	case "agreed", "repetition", "insufficient", "50move", "timevsinsufficient":
+		return "draw"
+	default:
+		return result
+	}
+}
+
+// pgnSource tells the site of the game from the Site and Link tags and returns the game ID of the site link
+func pgnSource(g *pgn.Game) (Source, string) {
+	site := g.Tag("Site")
+
+	switch {
+	case strings.Contains(site, "lichess.org"):
+		return lichessorg, path.Base(site)
+	case strings.Contains(strings.ToLower(site), "chess.com"):
+		if link := g.Tag("Link"); link != "" {
+			return chessdotcom, path.Base(link)
+		}
+
+		return chessdotcom, ""
+	default:
+		return 0, ""
+	}
+}
+
+// pgnPlayedAt returns the time in milliseconds of the game as the providers do,
+// lichess games are timed by their start and chess.com games by their end
+func pgnPlayedAt(g *pgn.Game, source Source) (int64, error) {
+	date, clock := g.Tag("UTCDate"), g.Tag("UTCTime")
+
+	if source == chessdotcom && g.Tag("EndDate") != "" && g.Tag("EndTime") != "" {
+		date, clock = g.Tag("EndDate"), g.Tag("EndTime")
+	}
+
+	t, err := time.Parse("2006.01.02 15:04:05", date+" "+clock)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid date of the game")
+	}
+
+	return t.UnixNano() / int64(time.Millisecond), nil
+}
+
+// timeControlTotal estimates the game duration in seconds of the PGN time control (initial + 40 * increment),
+// the correspondence games have no duration
+func timeControlTotal(tc string) int {
+	parts := strings.SplitN(tc, "+", 2)
+
+	initial, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0
+	}
+
+	if len(parts) == 1 {
+		return initial
+	}
+
+	inc, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return initial
+	}
+
+	return initial + 40*inc
+}
+
+// pgnSpeed names the speed of the time control with the lichess thresholds
+func pgnSpeed(tc string) string {
+	total := timeControlTotal(tc)
+
+	switch {
+	case total == 0:
+		return "correspondence"
+	case total < 30:
+		return "ultraBullet"
+	case total < 180:
+		return "bullet"
+	case total < 480:
+		return "blitz"
+	case total < 1500:
+		return "rapid"
+	default:
+		return "classical"
+	}
+}
+
+func pgnUserResult(winner Player, userID string) UserResult {
+	if winner.ID == userID {
+		return win
+	}
+
+	return lose
+}
+
+func pgnRating(v string) uint16 {
+	rating, _ := strconv.Atoi(v)
+
+	return uint16(rating)
+}
+
+// pgnStatus maps the Termination tag onto the lichess status names,
+// lichess only tells the normal ends apart from the time forfeits so the last move is checked for a mate
+func pgnStatus(g *pgn.Game, source Source, winner string) string {
+	termination := strings.ToLower(g.Tag("Termination"))
+
+	if source == chessdotcom {
+		for phrase, result := range chessComTerminations {
+			if strings.HasSuffix(termination, phrase) {
+				return chessComStatus(result)
+			}
+		}
+
+		return termination
+	}
+
+	switch {
+	case termination == "time forfeit":
+		return "outoftime"
+	case termination == "abandoned":
+		return "timeout"
+	case termination == "rules infraction":
+		return "cheat"
+	case winner == "":
+		return "draw"
+	case len(g.Moves) > 0 && strings.HasSuffix(g.Moves[len(g.Moves)-1].SAN, "#"):
+		return "mate"
+	default:
+		return "resign"
+	}
+}
+
+// chessComTerminations maps the endings of the chess.com Termination tag onto the chess.com result codes
+var chessComTerminations = map[string]string{
+	"by checkmate":                        "checkmated",
+	"by resignation":                      "resigned",
+	"on time":                             "timeout",
+	"game abandoned":                      "abandoned",
+	"by stalemate":                        "stalemate",
+	"by agreement":                        "agreed",
+	"by repetition":                       "repetition",
+	"by insufficient material":            "insufficient",
+	"by 50-move rule":                     "50move",
+	"by timeout vs insufficient material": "timevsinsufficient",
+}
+
+func chessComOpeningName(ecoURL string) string {
+	if ecoURL == "" {
+		return ""
+	}
+
+	return strings.ReplaceAll(path.Base(ecoURL), "-", " ")
+}
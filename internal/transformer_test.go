@@ -0,0 +1,168 @@
+package chessarchive
+
+import (
+	"chess-archive/pkg/chesscom"
+	"chess-archive/pkg/google/drive"
+	"strings"
+	"testing"
+
+	"github.com/VMAnalytic/lichess-api-client/lichess"
+)
+
+func TestParsePGNReplaysMoves(t *testing.T) {
+	tests := []struct {
+		name     string
+		game     Game
+		finalFEN string
+		pgnError string
+	}{
+		{
+			name:     "checkmate",
+			game:     Game{Status: "mate", PGN: "[Event \"x\"]\n\n1. e4 e5 2. Bc4 Nc6 3. Qh5 Nf6 4. Qxf7# 1-0"},
+			finalFEN: "r1bqkb1r/pppp1Qpp/2n2n2/4p3/2B1P3/8/PPPP1PPP/RNB1K1NR b KQkq - 0 4",
+		},
+		{
+			name:     "truncated",
+			game:     Game{Status: "mate", PGN: "1. e4 e5 2. Bc4 Nc6 3. Qh5 1-0"},
+			pgnError: "truncated PGN",
+		},
+		{
+			name:     "illegal move",
+			game:     Game{PGN: "1. e4 e5 2. Ke3 *"},
+			pgnError: "ply 3: illegal move Ke3",
+		},
+		{
+			name:     "malformed",
+			game:     Game{PGN: "1. e4 { open"},
+			pgnError: "malformed PGN",
+		},
+	}
+
+	for _, tt := range tests {
+		g := tt.game
+
+		err := parsePGN(&g)
+		if err != nil {
+			t.Fatalf("%s: parsePGN() error = %v", tt.name, err)
+		}
+
+		if tt.pgnError == "" && g.PGNError != "" {
+			t.Errorf("%s: PGNError = %q, want none", tt.name, g.PGNError)
+		}
+
+		if !strings.HasPrefix(g.PGNError, tt.pgnError) {
+			t.Errorf("%s: PGNError = %q, want prefix %q", tt.name, g.PGNError, tt.pgnError)
+		}
+
+		if tt.finalFEN == "" {
+			continue
+		}
+
+		if g.FinalFEN != tt.finalFEN || g.Moves[len(g.Moves)-1].FEN != tt.finalFEN {
+			t.Errorf("%s: FinalFEN = %s, want %s", tt.name, g.FinalFEN, tt.finalFEN)
+		}
+	}
+}
+
+const lichessFilePGN = `[Event "Rated Blitz game"]
+[Site "https://lichess.org/AbCdEfGh"]
+[Date "2021.03.04"]
+[White "Foo"]
+[Black "Baz"]
+[Result "1-0"]
+[UTCDate "2021.03.04"]
+[UTCTime "10:11:12"]
+[WhiteElo "1500"]
+[BlackElo "1490"]
+[TimeControl "180+2"]
+[ECO "C20"]
+[Opening "King's Pawn Game"]
+[Termination "Normal"]
+
+1. e4 e5 2. Qh5 Nc6 3. Bc4 Nf6 4. Qxf7# 1-0
+`
+
+const chessComFilePGN = `[Event "Live Chess"]
+[Site "Chess.com"]
+[Date "2021.03.04"]
+[White "Bar"]
+[Black "Qux"]
+[Result "1/2-1/2"]
+[WhiteElo "1200"]
+[BlackElo "1210"]
+[TimeControl "600"]
+[EndDate "2021.03.04"]
+[EndTime "11:00:00"]
+[Termination "Game drawn by repetition"]
+[ECOUrl "https://www.chess.com/openings/Kings-Pawn-Opening"]
+[Link "https://www.chess.com/game/live/12345"]
+
+1. e4 e5 1/2-1/2
+`
+
+func TestTransformDriveFile(t *testing.T) {
+	tr := NewFileTransformer("Foo", "bar")
+
+	g, err := tr.Transform(&drive.File{Name: "lichess", Media: strings.NewReader(lichessFilePGN)})
+	if err != nil {
+		t.Fatalf("Transform() of the lichess file error = %v", err)
+	}
+
+	if g.ID != "AbCdEfGh" || g.Source != lichessorg || g.UserID != "foo" || g.Speed != "blitz" || g.Status != "mate" ||
+		g.UserResult != "win" || g.Winner != "white" || g.PlayedAt != 1614852672000 || g.Duration != 260 {
+		t.Errorf("lichess game = %+v", g)
+	}
+
+	if g.Opening == nil || g.Opening.ECOCode != "C20" || g.Players.Black.Rating != 1490 || len(g.Moves) != 7 || g.PGNError != "" {
+		t.Errorf("lichess game details = %+v", g)
+	}
+
+	file := &drive.File{Name: "chesscom", Media: strings.NewReader(chessComFilePGN)}
+	file.AddTag(gameIDTag, "12345")
+
+	g, err = tr.Transform(file)
+	if err != nil {
+		t.Fatalf("Transform() of the chess.com file error = %v", err)
+	}
+
+	if g.ID != "12345" || g.Source != chessdotcom || g.UserID != "bar" || g.Speed != "rapid" || g.Status != "draw" ||
+		g.UserResult != "draw" || g.Winner != "" || g.PlayedAt != 1614855600000 || g.Duration != 600 {
+		t.Errorf("chess.com game = %+v", g)
+	}
+
+	//archived for another user sharing the folder
+	file = &drive.File{Name: "chesscom", Media: strings.NewReader(chessComFilePGN)}
+	file.AddTag(gameIDTag, "12345")
+	file.AddTag(userTag, "Baz")
+
+	g, err = tr.Transform(file)
+	if err != nil || g.UserID != "baz" {
+		t.Errorf("Transform() of the file tagged with its user = %+v, %v, want the user baz", g, err)
+	}
+}
+
+func TestTransformersRejectOtherSources(t *testing.T) {
+	games := []interface{}{&lichess.Game{}, &chesscom.Game{}, &drive.File{}}
+
+	tests := []struct {
+		transformer Transformer
+		accepted    interface{}
+	}{
+		{NewLichessTransformer("u"), games[0]},
+		{NewChessComTransformer("u"), games[1]},
+		{NewFileTransformer("u", "u"), games[2]},
+	}
+
+	for _, tt := range tests {
+		for _, v := range games {
+			if v == tt.accepted {
+				continue
+			}
+
+			_, err := tt.transformer.Transform(v)
+			if err == nil {
+				t.Errorf("%T.Transform(%T) error = nil, want an error", tt.transformer, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package chessarchive
+
+import (
+	"chess-archive/pkg/chesscom"
+	"chess-archive/pkg/retry"
+	"net/http"
+
+	"github.com/VMAnalytic/lichess-api-client/lichess"
+	"github.com/pkg/errors"
+)
+
+// IsTransient reports whether a failed run may succeed when it is run again, the invalid commands and settings fail
+// the same way every time. A run in which some accounts failed is transient when one of them failed on a transient error,
+// the checkpoints keep the archived games from being processed twice.
+func IsTransient(err error) bool {
+	var cmdErr *CommandError
+	if errors.As(err, &cmdErr) {
+		return false
+	}
+
+	var runErr *RunError
+	if errors.As(err, &runErr) {
+		for _, userErr := range runErr.Users {
+			if transient(userErr) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return transient(err)
+}
+
+// transient reports whether the Google services or the chess sites may succeed later
+func transient(err error) bool {
+	if retry.IsRetryable(err) {
+		return true
+	}
+
+	var indexErr *ErrMissingIndex
+	if errors.As(err, &indexErr) {
+		return true
+	}
+
+	var rateErr *lichess.RateLimitError
+	if errors.As(err, &rateErr) {
+		return true
+	}
+
+	var lichessErr *lichess.ErrorResponse
+	if errors.As(err, &lichessErr) && lichessErr.Response != nil {
+		return retryableStatus(lichessErr.Response.StatusCode)
+	}
+
+	var chessComErr chesscom.ErrChessCom
+	if errors.As(err, &chessComErr) {
+		return retryableStatus(chessComErr.StatusCode)
+	}
+
+	return false
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
@@ -0,0 +1,39 @@
+package chessarchive
+
+import (
+	"chess-archive/pkg/chesscom"
+	"chess-archive/pkg/google/drive"
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"invalid command", errors.WithStack(&CommandError{Reason: "x"}), false},
+		{"permanent run", &RunError{Users: map[string]error{"a": errors.New("boom")}}, false},
+		{"transient run", errors.WithStack(&RunError{Users: map[string]error{
+			"a": errors.New("boom"),
+			"b": errors.WithStack(status.Error(codes.Unavailable, "x")),
+		}}), true},
+		{"chess.com unavailable", errors.WithStack(chesscom.ErrChessCom{StatusCode: 503}), true},
+		{"chess.com not found", errors.WithStack(chesscom.ErrChessCom{StatusCode: 404}), false},
+		{"drive unavailable", errors.WithStack(drive.NewErrGDrive(&googleapi.Error{Code: http.StatusServiceUnavailable})), true},
+		{"missing index", errors.WithStack(&ErrMissingIndex{err: status.Error(codes.FailedPrecondition, "x")}), true},
+		{"drive not found", errors.WithStack(drive.NewErrGDrive(&googleapi.Error{Code: http.StatusNotFound})), false},
+	}
+
+	for _, tt := range tests {
+		if got := IsTransient(tt.err); got != tt.want {
+			t.Errorf("%s: IsTransient() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package chessarchive
+
+import (
+	"chess-archive/config"
+	"chess-archive/pkg/google/drive"
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// VerifyReport compares the games of a user kept by the stores
+type VerifyReport struct {
+	Source  string              `json:"source"`
+	UserID  string              `json:"user_id"`
+	Games   map[string]int      `json:"games"`             //store => stored games
+	Missing map[string][]string `json:"missing,omitempty"` //store => IDs of the games kept by the other stores only
+}
+
+// Consistent reports whether every store keeps the same games
+func (r *VerifyReport) Consistent() bool {
+	return len(r.Missing) == 0
+}
+
+// VerifyStores lists the games of the user in every processor able to list them and reports the games some of them miss,
+// the processors which can not list their games are left out
+func VerifyStores(ctx context.Context, source Source, userID string, processors []Processor) (*VerifyReport, error) {
+	report := &VerifyReport{
+		Source:  source.String(),
+		UserID:  userID,
+		Games:   map[string]int{},
+		Missing: map[string][]string{},
+	}
+
+	stored := map[string]map[string]bool{}
+	all := map[string]bool{}
+
+	for _, p := range processors {
+		lister, ok := p.(GameLister)
+		if !ok {
+			continue
+		}
+
+		it, err := lister.ListGames(ctx, source, userID)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		ids := map[string]bool{}
+
+		err = EachGame(it, func(g *Game) error {
+			ids[g.ID] = true
+			all[g.ID] = true
+
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "listing the %s games", p.Name())
+		}
+
+		stored[p.Name()] = ids
+		report.Games[p.Name()] = len(ids)
+	}
+
+	for name, ids := range stored {
+		for id := range all {
+			if !ids[id] {
+				report.Missing[name] = append(report.Missing[name], id)
+			}
+		}
+
+		sort.Strings(report.Missing[name])
+	}
+
+	return report, nil
+}
+
+// DriveReport joins the Firestore games of a user with the files of the Drive archive folder
+type DriveReport struct {
+	User               string              `json:"user"`
+	Firestore          int                 `json:"firestore"`                      //stored games
+	Drive              int                 `json:"drive"`                          //files in the archive folder
+	MissingInDrive     []string            `json:"missing_in_drive,omitempty"`     //IDs of the games without file
+	MissingInFirestore []string            `json:"missing_in_firestore,omitempty"` //game ID tags of the files without game
+	Duplicates         map[string][]string `json:"duplicates,omitempty"`           //game ID => IDs of its files
+	Mismatched         []string            `json:"mismatched,omitempty"`           //IDs of the games whose file has another PGN
+	Untracked          []string            `json:"untracked,omitempty"`            //names of the untagged files matching no game
+	Repaired           []string            `json:"repaired,omitempty"`
+	Unrepaired         map[string]string   `json:"unrepaired,omitempty"` //game ID => reason
+}
+
+// Consistent reports whether both sides keep the same games with the same PGN once the repairs are done,
+// the duplicates are never removed and the untracked files may not be games
+func (r *DriveReport) Consistent() bool {
+	return len(r.Duplicates) == 0 && len(r.Repaired) == len(r.MissingInDrive)+len(r.MissingInFirestore)+len(r.Mismatched)
+}
+
+func (r *DriveReport) repaired(id string, err error) {
+	if err != nil {
+		r.Unrepaired[id] = err.Error()
+
+		return
+	}
+
+	r.Repaired = append(r.Repaired, id)
+}
+
+// VerifyDrive joins the Firestore games of the account with the files of its Drive archive folder on the game ID tag,
+// the files uploaded before tagging are joined by name. With repair the games missing from Drive or having another PGN
+// there are archived again from Firestore, the games missing from Firestore are restored from their Drive PGN
+// whatever their source, the data missing from the PGN like the analysis of the players is left empty.
+func VerifyDrive(ctx context.Context, acc *Account, repair bool) (*DriveReport, error) {
+	var (
+		ds *DataStoreProcessor
+		gd *GDriveStoreProcessor
+	)
+
+	for _, p := range acc.Processors {
+		switch p := p.(type) {
+		case *DataStoreProcessor:
+			ds = p
+		case *GDriveStoreProcessor:
+			gd = p
+		}
+	}
+
+	if ds == nil || gd == nil {
+		return nil, errors.Errorf("the %s and %s processors of %s should be enabled", config.ProcessorFirestore, config.ProcessorDrive, acc.Name)
+	}
+
+	files, err := gd.gdClient.FilesFromFolder(ctx, gd.folderID, true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing the Drive archive of %s", acc.Name)
+	}
+
+	//the archive folder may be shared with other users
+	files = ownFiles(files, acc.Providers)
+
+	byID := map[string][]*drive.File{}
+	byName := map[string][]*drive.File{}
+
+	for _, f := range files {
+		if id := f.Tag(gameIDTag); id != "" {
+			byID[id] = append(byID[id], f)
+		} else {
+			byName[f.Name] = append(byName[f.Name], f)
+		}
+	}
+
+	report := &DriveReport{
+		User:       acc.Name,
+		Drive:      len(files),
+		Duplicates: map[string][]string{},
+		Unrepaired: map[string]string{},
+	}
+
+	var outdated []*Game
+
+	stored := map[string]bool{}
+
+	for _, provider := range acc.Providers {
+		it, err := ds.ListGames(ctx, provider.Source(), provider.User())
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		err = EachGame(it, func(g *Game) error {
+			report.Firestore++
+			stored[g.ID] = true
+
+			expected, err := gd.transformer.TransformToFile(g)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			matched, ok := byID[g.ID]
+			if !ok {
+				matched = byName[expected.Name]
+				delete(byName, expected.Name)
+			}
+
+			switch {
+			case len(matched) == 0:
+				report.MissingInDrive = append(report.MissingInDrive, g.ID)
+				outdated = append(outdated, g)
+			case matched[0].Checksum != expected.Checksum:
+				//the processor updates the first file it finds, as here
+				report.Mismatched = append(report.Mismatched, g.ID)
+				outdated = append(outdated, g)
+			}
+
+			if len(matched) > 1 {
+				report.Duplicates[g.ID] = fileIDs(matched)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "listing the %s games of %s", provider.Source(), acc.Name)
+		}
+	}
+
+	for id, matched := range byID {
+		if stored[id] {
+			continue
+		}
+
+		report.MissingInFirestore = append(report.MissingInFirestore, id)
+
+		if len(matched) > 1 {
+			report.Duplicates[id] = fileIDs(matched)
+		}
+	}
+
+	for name := range byName {
+		report.Untracked = append(report.Untracked, name)
+	}
+
+	sort.Strings(report.MissingInFirestore)
+	sort.Strings(report.Untracked)
+
+	if !repair {
+		return report, nil
+	}
+
+	for _, g := range outdated {
+		gd.logger.Infof("archiving game ID: %s in Drive again", g.ID)
+
+		report.repaired(g.ID, gd.Process(ctx, g))
+	}
+
+	for _, id := range report.MissingInFirestore {
+		gd.logger.Infof("restoring game ID: %s missing in Firestore from Drive", id)
+
+		g, err := readDriveGame(ctx, gd.gdClient, gd.transformer, byID[id][0].ID)
+		if err == nil {
+			err = ds.Process(ctx, g)
+		}
+
+		report.repaired(id, err)
+	}
+
+	sort.Strings(report.Repaired)
+
+	return report, nil
+}
+
+// ownFiles keeps the files archived for the providers, the files archived before the source and user tags are kept
+func ownFiles(files []*drive.File, providers []GameProvider) []*drive.File {
+	owners := map[string]bool{}
+
+	for _, provider := range providers {
+		owners[provider.Source().String()+"/"+strings.ToLower(provider.User())] = true
+	}
+
+	var kept []*drive.File
+
+	for _, f := range files {
+		if f.Tag(sourceTag) == "" || owners[f.Tag(sourceTag)+"/"+f.Tag(userTag)] {
+			kept = append(kept, f)
+		}
+	}
+
+	return kept
+}
+
+func fileIDs(files []*drive.File) []string {
+	ids := make([]string, 0, len(files))
+	for _, f := range files {
+		ids = append(ids, f.ID)
+	}
+
+	return ids
+}
@@ -0,0 +1,32 @@
+package chessarchive
+
+import (
+	"chess-archive/pkg/google/drive"
+	"testing"
+)
+
+func TestOwnFiles(t *testing.T) {
+	file := func(id, source, userID string) *drive.File {
+		f := &drive.File{ID: id}
+
+		if source != "" {
+			f.AddTag(sourceTag, source)
+			f.AddTag(userTag, userID)
+		}
+
+		return f
+	}
+
+	files := []*drive.File{
+		file("own", "lichess", "alice"),
+		file("legacy", "", ""),
+		file("teammate", "lichess", "bob"),
+		file("other source", "chesscom", "alice"),
+	}
+	providers := []GameProvider{NewMemoryGameProvider(lichessorg, "Alice", 1)}
+
+	kept := fileIDs(ownFiles(files, providers))
+	if len(kept) != 2 || kept[0] != "own" || kept[1] != "legacy" {
+		t.Errorf("ownFiles() = %v, want [own legacy]", kept)
+	}
+}
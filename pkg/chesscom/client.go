@@ -0,0 +1,165 @@
+package chesscom
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultBaseURL = "https://api.chess.com/pub/"
+	userAgent      = "chess-archiver"
+	contentType    = "application/json"
+)
+
+type ErrChessCom struct {
+	StatusCode int
+	URL        string
+}
+
+func (e ErrChessCom) Error() string {
+	return fmt.Sprintf("chess.com API request %s failed with status %d", e.URL, e.StatusCode)
+}
+
+// Archive is a reference to the monthly games archive of the player
+type Archive struct {
+	Year  int
+	Month time.Month
+	URL   string
+}
+
+type Client struct {
+	client      *http.Client
+	baseURL     *url.URL
+	rateLimiter *rate.Limiter
+}
+
+func NewClient(httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = defaultHTTPClient()
+	}
+
+	baseURL, _ := url.Parse(defaultBaseURL)
+
+	//chess.com recommends serial access to the published data API
+	rl := rate.NewLimiter(rate.Every(1*time.Second), 3)
+
+	return &Client{client: httpClient, baseURL: baseURL, rateLimiter: rl}
+}
+
+func defaultHTTPClient() *http.Client {
+	var transport = &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: time.Second * 5,
+		}).DialContext}
+
+	return &http.Client{
+		Timeout:   time.Second * 30,
+		Transport: transport,
+	}
+}
+
+func (c *Client) SetLimits(limit time.Duration, burst uint) {
+	c.rateLimiter = rate.NewLimiter(rate.Every(limit), int(burst))
+}
+
+// Archives returns the list of monthly archives available for the player, oldest first
+func (c *Client) Archives(ctx context.Context, username string) ([]*Archive, error) {
+	var resp struct {
+		Archives []string `json:"archives"`
+	}
+
+	err := c.get(ctx, fmt.Sprintf("player/%s/games/archives", strings.ToLower(username)), &resp)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	list := make([]*Archive, 0, len(resp.Archives))
+
+	for _, u := range resp.Archives {
+		a, err := parseArchiveURL(u)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		list = append(list, a)
+	}
+
+	return list, nil
+}
+
+// Games returns all games played by the player in the given month
+func (c *Client) Games(ctx context.Context, username string, year int, month time.Month) ([]*Game, error) {
+	var resp struct {
+		Games []*Game `json:"games"`
+	}
+
+	u := fmt.Sprintf("player/%s/games/%04d/%02d", strings.ToLower(username), year, int(month))
+
+	err := c.get(ctx, u, &resp)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return resp.Games, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, v interface{}) error {
+	err := c.rateLimiter.Wait(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	u, err := c.baseURL.Parse(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	req.Header.Set("Accept", contentType)
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return ErrChessCom{StatusCode: resp.StatusCode, URL: u.String()}
+	}
+
+	return errors.WithStack(json.NewDecoder(resp.Body).Decode(v))
+}
+
+func parseArchiveURL(u string) (*Archive, error) {
+	parts := strings.Split(strings.TrimSuffix(u, "/"), "/")
+	if len(parts) < 2 {
+		return nil, errors.Errorf("unexpected archive url: %s", u)
+	}
+
+	year, err := strconv.Atoi(parts[len(parts)-2])
+	if err != nil {
+		return nil, errors.Wrapf(err, "unexpected archive url: %s", u)
+	}
+
+	month, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil {
+		return nil, errors.Wrapf(err, "unexpected archive url: %s", u)
+	}
+
+	return &Archive{Year: year, Month: time.Month(month), URL: u}, nil
+}
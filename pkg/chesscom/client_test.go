@@ -0,0 +1,82 @@
+package chesscom
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// newTestClient returns a client of the chess.com API served by the handler
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	c := NewClient(srv.Client())
+	c.baseURL, _ = url.Parse(srv.URL + "/pub/")
+	c.SetLimits(time.Millisecond, 10)
+
+	return c
+}
+
+func TestClientArchives(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pub/player/alice/games/archives" {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		_, _ = fmt.Fprint(w, `{"archives": [
+			"https://api.chess.com/pub/player/alice/games/2020/12",
+			"https://api.chess.com/pub/player/alice/games/2021/01"
+		]}`)
+	})
+
+	archives, err := c.Archives(context.Background(), "Alice")
+	if err != nil {
+		t.Fatalf("Archives() error = %v", err)
+	}
+
+	if len(archives) != 2 || archives[0].Year != 2020 || archives[0].Month != time.December ||
+		archives[1].Year != 2021 || archives[1].Month != time.January {
+		t.Errorf("Archives() = %+v, %+v, want 2020/12 and 2021/01", archives[0], archives[1])
+	}
+}
+
+func TestClientGames(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/pub/player/alice/games/2021/01":
+			_, _ = fmt.Fprint(w, `{"games": [{"url": "https://www.chess.com/game/live/123", "end_time": 1610000000}]}`)
+		case "/pub/player/alice/games/2021/02":
+			_, _ = fmt.Fprint(w, `{"games": []}`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	games, err := c.Games(context.Background(), "alice", 2021, time.January)
+	if err != nil || len(games) != 1 || games[0].ID() != "123" || games[0].EndTime != 1610000000 {
+		t.Errorf("Games(2021/01) = %v, %v, want the game 123", games, err)
+	}
+
+	games, err = c.Games(context.Background(), "alice", 2021, time.February)
+	if err != nil || len(games) != 0 {
+		t.Errorf("Games(2021/02) = %v, %v, want no games", games, err)
+	}
+
+	_, err = c.Games(context.Background(), "alice", 2021, time.March)
+
+	var chessComErr ErrChessCom
+	if !errors.As(err, &chessComErr) || chessComErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Games(2021/03) error = %v, want the 404 of chess.com", err)
+	}
+}
@@ -0,0 +1,60 @@
+package chesscom
+
+import (
+	"path"
+	"strconv"
+	"strings"
+)
+
+type Game struct {
+	URL         string  `json:"url"`
+	UUID        string  `json:"uuid"`
+	PGN         string  `json:"pgn"`
+	TimeControl string  `json:"time_control"`
+	TimeClass   string  `json:"time_class"`
+	Rules       string  `json:"rules"`
+	Rated       bool    `json:"rated"`
+	EndTime     int64   `json:"end_time"` //seconds
+	FEN         string  `json:"fen"`
+	ECO         string  `json:"eco"` //url of the opening page
+	White       *Player `json:"white"`
+	Black       *Player `json:"black"`
+}
+
+type Player struct {
+	Username string `json:"username"`
+	Rating   int    `json:"rating"`
+	Result   string `json:"result"`
+	UUID     string `json:"uuid"`
+}
+
+// ID returns the numeric identifier from the game url
+func (g *Game) ID() string {
+	return path.Base(g.URL)
+}
+
+// TotalTime estimates the game duration in seconds the same way as lichess does (initial + 40 * increment)
+func (g *Game) TotalTime() int {
+	//daily games are formatted as "1/86400"
+	if strings.Contains(g.TimeControl, "/") {
+		return 0
+	}
+
+	parts := strings.SplitN(g.TimeControl, "+", 2)
+
+	initial, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0
+	}
+
+	if len(parts) == 1 {
+		return initial
+	}
+
+	inc, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return initial
+	}
+
+	return initial + 40*inc
+}
@@ -1,15 +1,23 @@
 package drive
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
 	"strings"
 	"time"
 
+	"chess-archive/pkg/retry"
+
 	"golang.org/x/time/rate"
 
 	"github.com/pkg/errors"
 	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 )
 
@@ -19,16 +27,26 @@ const (
 	DefaultPageSize = 200                //should be less than 1000
 )
 
+// ErrGDrive wraps the error of a request to the Drive API, the cause is kept so it can still be classified
 type ErrGDrive struct {
-	reason string
+	err error
 }
 
 func NewErrGDrive(err error) *ErrGDrive {
-	return &ErrGDrive{reason: errors.Wrap(err, "error during the request to GDrive API:").Error()}
+	return &ErrGDrive{err: err}
 }
 
 func (e ErrGDrive) Error() string {
-	return e.reason
+	return "error during the request to GDrive API: " + e.err.Error()
+}
+
+func (e ErrGDrive) Unwrap() error {
+	return e.err
+}
+
+// Cause implements the causer of github.com/pkg/errors
+func (e ErrGDrive) Cause() error {
+	return e.err
 }
 
 type GDriveClient interface {
@@ -42,22 +60,51 @@ type GDriveClient interface {
 	//recursively option provide possibility to get all files from sub folders
 	FilesFromFolder(ctx context.Context, folderName string, recursively bool) ([]*File, error)
 
-	//Latest will return the file in the folder which was last uploaded
+	//Download returns the content of the file, it should be closed by the caller
+	Download(ctx context.Context, ID string) (io.ReadCloser, error)
+
+	//Open returns the file with its content as Media, it should be closed by the caller
+	Open(ctx context.Context, ID string) (*File, error)
+
+	//Latest will return the file in the folder or its subfolders which was last uploaded
 	Latest(ctx context.Context, folderID string) (*File, error)
 
 	//Folders return the list of the folders
 	Folders(ctx context.Context) ([]*File, error)
 
+	//SubFolders returns the folders directly inside the folder
+	SubFolders(ctx context.Context, dirID string) ([]*File, error)
+
+	//CreateFolder creates the folder inside the parent folder and returns its ID
+	CreateFolder(ctx context.Context, parentID, name string) (string, error)
+
+	//Move moves the file from all its current folders into the folder
+	Move(ctx context.Context, ID, folderID string) error
+
 	//Create create file
 	Create(ctx context.Context, folder string, file *File) (string, error)
+
+	//Update replaces the name, description, tags and content of the file
+	Update(ctx context.Context, ID string, file *File) error
+
+	//FindByTag returns the files having the tag (custom file property) with the value
+	FindByTag(ctx context.Context, key, value string) ([]*File, error)
+
+	//FindByTags returns the files having every tag with its value
+	FindByTags(ctx context.Context, tags map[string]string) ([]*File, error)
+
+	//FindByName returns the files in the folder with exactly the given name
+	FindByName(ctx context.Context, folderID, name string) ([]*File, error)
 }
 
 type HTTPClient struct {
 	ds          *drive.Service
 	rateLimiter *rate.Limiter
+	retry       retry.Policy
 }
 
-func NewHTTPtClient(ctx context.Context) (*HTTPClient, error) {
+// NewHTTPtClient creates the client, every request is retried on the transient errors according to the policy
+func NewHTTPtClient(ctx context.Context, policy retry.Policy) (*HTTPClient, error) {
 	client, err := drive.NewService(
 		ctx,
 		option.WithScopes(drive.DriveScope),
@@ -68,15 +115,26 @@ func NewHTTPtClient(ctx context.Context) (*HTTPClient, error) {
 
 	rl := rate.NewLimiter(rate.Every(1*time.Second), 10)
 
-	return &HTTPClient{ds: client, rateLimiter: rl}, nil
+	return &HTTPClient{ds: client, rateLimiter: rl, retry: policy}, nil
+}
+
+// do runs the request under the retry policy
+func (m HTTPClient) do(ctx context.Context, request func(ctx context.Context) error) error {
+	return m.retry.Do(ctx, request)
 }
 
 func (m HTTPClient) Get(ctx context.Context, ID string) (*File, error) {
-	f, err := m.ds.Files.
-		Get(ID).
-		Context(ctx).
-		Fields("id, name, createdTime, modifiedTime, sharingUser, lastModifyingUser").
-		Do()
+	var f *drive.File
+
+	err := m.do(ctx, func(ctx context.Context) (err error) {
+		f, err = m.ds.Files.
+			Get(ID).
+			Context(ctx).
+			Fields("id, name, description, properties, md5Checksum, parents, createdTime, modifiedTime, sharingUser, lastModifyingUser").
+			Do()
+
+		return err
+	})
 
 	if err != nil {
 		return nil, NewErrGDrive(err)
@@ -85,6 +143,39 @@ func (m HTTPClient) Get(ctx context.Context, ID string) (*File, error) {
 	return newFileFromOrigin(f)
 }
 
+func (m HTTPClient) Download(ctx context.Context, ID string) (io.ReadCloser, error) {
+	var resp *http.Response
+
+	err := m.do(ctx, func(ctx context.Context) (err error) {
+		resp, err = m.ds.Files.
+			Get(ID).
+			SupportsAllDrives(true).
+			Context(ctx).
+			Download()
+
+		return err
+	})
+	if err != nil {
+		return nil, NewErrGDrive(err)
+	}
+
+	return resp.Body, nil
+}
+
+func (m HTTPClient) Open(ctx context.Context, ID string) (*File, error) {
+	f, err := m.Get(ctx, ID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	f.Media, err = m.Download(ctx, ID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return f, nil
+}
+
 func (m *HTTPClient) Files(ctx context.Context, IDs []string) ([]*File, error) {
 	var (
 		next      = true
@@ -93,18 +184,24 @@ func (m *HTTPClient) Files(ctx context.Context, IDs []string) ([]*File, error) {
 	)
 
 	for next {
-		r, err := m.ds.Files.
-			List().
-			SupportsAllDrives(true).
-			IncludeItemsFromAllDrives(true).
-			Context(ctx).
-			Corpora("allDrives").
-			Fields("nextPageToken, files(id, name, createdTime, modifiedTime, sharingUser, lastModifyingUser)").
-			PageSize(DefaultPageSize).
-			OrderBy(OrderDirection).
-			PageToken(pageToken).
-			Q(fmt.Sprintf("mimeType!='%s'", MimeTypeFolder)).
-			Do()
+		var r *drive.FileList
+
+		err := m.do(ctx, func(ctx context.Context) (err error) {
+			r, err = m.ds.Files.
+				List().
+				SupportsAllDrives(true).
+				IncludeItemsFromAllDrives(true).
+				Context(ctx).
+				Corpora("allDrives").
+				Fields("nextPageToken, files(id, name, createdTime, modifiedTime, sharingUser, lastModifyingUser)").
+				PageSize(DefaultPageSize).
+				OrderBy(OrderDirection).
+				PageToken(pageToken).
+				Q(fmt.Sprintf("mimeType!='%s'", MimeTypeFolder)).
+				Do()
+
+			return err
+		})
 		if err != nil {
 			return nil, NewErrGDrive(err)
 		}
@@ -140,12 +237,18 @@ func (m *HTTPClient) Path(ctx context.Context, ID string) (string, error) {
 }
 
 func (m *HTTPClient) path(ctx context.Context, ID string, path string) (string, error) {
-	f, err := m.ds.Files.
-		Get(ID).
-		SupportsAllDrives(true).
-		Context(ctx).
-		Fields("id, name, parents").
-		Do()
+	var f *drive.File
+
+	err := m.do(ctx, func(ctx context.Context) (err error) {
+		f, err = m.ds.Files.
+			Get(ID).
+			SupportsAllDrives(true).
+			Context(ctx).
+			Fields("id, name, parents").
+			Do()
+
+		return err
+	})
 
 	if err != nil {
 		return "", NewErrGDrive(err)
@@ -158,12 +261,19 @@ func (m *HTTPClient) path(ctx context.Context, ID string, path string) (string,
 	var r string
 
 	p := f.Parents[0]
-	p2, err := m.ds.Files.
-		Get(ID).
-		SupportsAllDrives(true).
-		Context(ctx).
-		Fields("id, name, parents").
-		Do()
+
+	var p2 *drive.File
+
+	err = m.do(ctx, func(ctx context.Context) (err error) {
+		p2, err = m.ds.Files.
+			Get(ID).
+			SupportsAllDrives(true).
+			Context(ctx).
+			Fields("id, name, parents").
+			Do()
+
+		return err
+	})
 
 	if err != nil {
 		return "", NewErrGDrive(err)
@@ -189,17 +299,23 @@ func (m HTTPClient) FilesFromFolder(ctx context.Context, folderName string, recu
 		)
 
 		for next {
-			r, err := m.ds.Files.
-				List().
-				SupportsAllDrives(true).
-				IncludeItemsFromAllDrives(true).
-				Context(ctx).
-				Fields("nextPageToken, files(id, name, createdTime, modifiedTime, sharingUser, lastModifyingUser)").
-				PageSize(DefaultPageSize).
-				OrderBy(OrderDirection).
-				PageToken(pageToken).
-				Q(fmt.Sprintf("mimeType!='%s' and '%s' in parents", MimeTypeFolder, folderName)).
-				Do()
+			var r *drive.FileList
+
+			err := m.do(ctx, func(ctx context.Context) (err error) {
+				r, err = m.ds.Files.
+					List().
+					SupportsAllDrives(true).
+					IncludeItemsFromAllDrives(true).
+					Context(ctx).
+					Fields("nextPageToken, files(id, name, description, properties, md5Checksum, parents, createdTime, modifiedTime, sharingUser, lastModifyingUser)").
+					PageSize(DefaultPageSize).
+					OrderBy(OrderDirection).
+					PageToken(pageToken).
+					Q(fmt.Sprintf("mimeType!='%s' and '%s' in parents and trashed=false", MimeTypeFolder, folderName)).
+					Do()
+
+				return err
+			})
 			if err != nil {
 				return nil, NewErrGDrive(err)
 			}
@@ -224,74 +340,27 @@ func (m HTTPClient) FilesFromFolder(ctx context.Context, folderName string, recu
 		return list, nil
 	}
 
-	// Get all files from directory with subdirectories
-	if recursively {
-		folders, err := m.SubFolders(ctx, folderName)
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
+	// Get all files from directory with subdirectories, every level of the tree is walked
+	list, err := m.FilesFromFolder(ctx, folderName, false)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 
-		var (
-			sb        strings.Builder
-			list      []*File
-			pageToken string
-			next      = true
-		)
+	folders, err := m.SubFolders(ctx, folderName)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 
-		list, err = m.FilesFromFolder(ctx, folderName, false)
+	for _, f := range folders {
+		files, err := m.FilesFromFolder(ctx, f.ID, true)
 		if err != nil {
-			return nil, NewErrGDrive(err)
-		}
-
-		if len(folders) == 0 {
-			return list, nil
-		}
-
-		sb.WriteString(fmt.Sprintf("'%s' in parents", folders[0].ID))
-
-		for _, f := range folders[1:] {
-			sb.WriteString(fmt.Sprintf(" or '%s' in parents", f.ID))
-		}
-
-		s := sb.String()
-
-		for next {
-			r, err := m.ds.Files.
-				List().
-				SupportsAllDrives(true).
-				IncludeItemsFromAllDrives(true).
-				Context(ctx).
-				Fields("nextPageToken, files(*)").
-				PageSize(DefaultPageSize).
-				OrderBy(OrderDirection).
-				PageToken(pageToken).
-				Q(fmt.Sprintf("mimeType!='%s' and %s", MimeTypeFolder, s)).
-				Do()
-			if err != nil {
-				return nil, NewErrGDrive(err)
-			}
-
-			originList := r.Files
-
-			for _, f := range originList {
-				file, err := newFileFromOrigin(f)
-				if err != nil {
-					return nil, errors.WithStack(err)
-				}
-
-				list = append(list, file)
-			}
-
-			pageToken = r.NextPageToken
-			if pageToken == "" {
-				next = false
-			}
+			return nil, errors.WithStack(err)
 		}
 
-		return list, nil
+		list = append(list, files...)
 	}
 
-	return nil, nil
+	return list, nil
 }
 
 func (m HTTPClient) SubFolders(ctx context.Context, dirID string) ([]*File, error) {
@@ -302,23 +371,29 @@ func (m HTTPClient) SubFolders(ctx context.Context, dirID string) ([]*File, erro
 		list      []*File
 	)
 
-	sb.WriteString(fmt.Sprintf("mimeType='%s'", MimeTypeFolder))
+	sb.WriteString(fmt.Sprintf("mimeType='%s' and trashed=false", MimeTypeFolder))
 
 	if dirID != "" {
 		sb.WriteString(fmt.Sprintf(" and '%s' in parents", dirID))
 	}
 
 	for next {
-		r, err := m.ds.Files.
-			List().
-			SupportsAllDrives(true).
-			IncludeItemsFromAllDrives(true).
-			Context(ctx).
-			Fields("nextPageToken, files(id, name, createdTime, modifiedTime)").
-			PageSize(DefaultPageSize).
-			PageToken(pageToken).
-			Q(sb.String()).
-			Do()
+		var r *drive.FileList
+
+		err := m.do(ctx, func(ctx context.Context) (err error) {
+			r, err = m.ds.Files.
+				List().
+				SupportsAllDrives(true).
+				IncludeItemsFromAllDrives(true).
+				Context(ctx).
+				Fields("nextPageToken, files(id, name, createdTime, modifiedTime)").
+				PageSize(DefaultPageSize).
+				PageToken(pageToken).
+				Q(sb.String()).
+				Do()
+
+			return err
+		})
 		if err != nil {
 			return nil, NewErrGDrive(err)
 		}
@@ -365,18 +440,25 @@ func (m HTTPClient) All(ctx context.Context) ([]*File, error) {
 	}
 
 	s := sb.String()
-	r, err := m.ds.Files.
-		List().
-		SupportsAllDrives(true).
-		IncludeItemsFromAllDrives(true).
-		Context(ctx).
-		Fields("*").
-		PageSize(DefaultPageSize).
-		OrderBy(OrderDirection).
-		//PageToken(pageToken).
-		Q(s).
-		Q(fmt.Sprintf("mimeType!='%s'", MimeTypeFolder)).
-		Do()
+
+	var r *drive.FileList
+
+	err = m.do(ctx, func(ctx context.Context) (err error) {
+		r, err = m.ds.Files.
+			List().
+			SupportsAllDrives(true).
+			IncludeItemsFromAllDrives(true).
+			Context(ctx).
+			Fields("*").
+			PageSize(DefaultPageSize).
+			OrderBy(OrderDirection).
+			//PageToken(pageToken).
+			Q(s).
+			Q(fmt.Sprintf("mimeType!='%s'", MimeTypeFolder)).
+			Do()
+
+		return err
+	})
 
 	if err != nil {
 		return nil, NewErrGDrive(err)
@@ -404,16 +486,22 @@ func (m HTTPClient) Folders(ctx context.Context) ([]*File, error) {
 	)
 
 	for next {
-		r, err := m.ds.Files.
-			List().
-			SupportsAllDrives(true).
-			IncludeItemsFromAllDrives(true).
-			Context(ctx).
-			Fields("nextPageToken, files(id, name, createdTime, modifiedTime)").
-			PageSize(DefaultPageSize).
-			PageToken(pageToken).
-			Q(fmt.Sprintf("mimeType='%s'", MimeTypeFolder)).
-			Do()
+		var r *drive.FileList
+
+		err := m.do(ctx, func(ctx context.Context) (err error) {
+			r, err = m.ds.Files.
+				List().
+				SupportsAllDrives(true).
+				IncludeItemsFromAllDrives(true).
+				Context(ctx).
+				Fields("nextPageToken, files(id, name, createdTime, modifiedTime)").
+				PageSize(DefaultPageSize).
+				PageToken(pageToken).
+				Q(fmt.Sprintf("mimeType='%s'", MimeTypeFolder)).
+				Do()
+
+			return err
+		})
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
@@ -449,30 +537,295 @@ func (m HTTPClient) Create(ctx context.Context, folder string, file *File) (stri
 	f.Properties = file.Tags
 	f.Description = file.Description
 
-	r, err := m.ds.Files.
-		Create(f).
-		Media(file.Media).
-		Context(ctx).
-		Do()
+	media, err := readMedia(file.Media)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	//the ID is generated up front, so an upload committed by Drive before its response got lost is not created twice
+	var ids *drive.GeneratedIds
+
+	err = m.do(ctx, func(ctx context.Context) (err error) {
+		ids, err = m.ds.Files.
+			GenerateIds().
+			Count(1).
+			Space("drive").
+			Context(ctx).
+			Do()
+
+		return err
+	})
 
+	if err != nil {
+		return "", NewErrGDrive(err)
+	}
+
+	if len(ids.Ids) == 0 {
+		return "", errors.New("GDrive API generated no file ID")
+	}
+
+	f.Id = ids.Ids[0]
+
+	var attempts int
+
+	err = m.do(ctx, func(ctx context.Context) error {
+		attempts++
+
+		_, err := m.ds.Files.
+			Create(f).
+			Media(bytes.NewReader(media)).
+			Context(ctx).
+			Do()
+
+		//the file was created by an earlier attempt
+		var apiErr *googleapi.Error
+		if attempts > 1 && errors.As(err, &apiErr) && apiErr.Code == http.StatusConflict {
+			return nil
+		}
+
+		return err
+	})
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return f.Id, nil
+}
+
+func (m HTTPClient) CreateFolder(ctx context.Context, parentID, name string) (string, error) {
+	err := m.rateLimiter.Wait(ctx)
 	if err != nil {
 		return "", errors.WithStack(err)
 	}
 
+	f := &drive.File{Name: name, MimeType: MimeTypeFolder, Parents: []string{parentID}}
+
+	var r *drive.File
+
+	err = m.do(ctx, func(ctx context.Context) (err error) {
+		r, err = m.ds.Files.
+			Create(f).
+			SupportsAllDrives(true).
+			Context(ctx).
+			Fields("id").
+			Do()
+
+		return err
+	})
+
+	if err != nil {
+		return "", NewErrGDrive(err)
+	}
+
 	return r.Id, nil
 }
 
+func (m HTTPClient) Move(ctx context.Context, ID, folderID string) error {
+	err := m.rateLimiter.Wait(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var f *drive.File
+
+	err = m.do(ctx, func(ctx context.Context) (err error) {
+		f, err = m.ds.Files.
+			Get(ID).
+			SupportsAllDrives(true).
+			Context(ctx).
+			Fields("id, parents").
+			Do()
+
+		return err
+	})
+
+	if err != nil {
+		return NewErrGDrive(err)
+	}
+
+	err = m.do(ctx, func(ctx context.Context) error {
+		_, err := m.ds.Files.
+			Update(ID, &drive.File{}).
+			SupportsAllDrives(true).
+			AddParents(folderID).
+			RemoveParents(strings.Join(f.Parents, ",")).
+			Context(ctx).
+			Do()
+
+		return err
+	})
+
+	if err != nil {
+		return NewErrGDrive(err)
+	}
+
+	return nil
+}
+
+func (m HTTPClient) Update(ctx context.Context, ID string, file *File) error {
+	err := m.rateLimiter.Wait(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	f := &drive.File{Name: file.Name, Description: file.Description, Properties: file.Tags}
+
+	media, err := readMedia(file.Media)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = m.do(ctx, func(ctx context.Context) error {
+		call := m.ds.Files.
+			Update(ID, f).
+			SupportsAllDrives(true).
+			Context(ctx)
+
+		if media != nil {
+			call = call.Media(bytes.NewReader(media))
+		}
+
+		_, err := call.Do()
+
+		return err
+	})
+	if err != nil {
+		return NewErrGDrive(err)
+	}
+
+	return nil
+}
+
+func (m HTTPClient) FindByTag(ctx context.Context, key, value string) ([]*File, error) {
+	return m.FindByTags(ctx, map[string]string{key: value})
+}
+
+func (m HTTPClient) FindByTags(ctx context.Context, tags map[string]string) ([]*File, error) {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	var sb strings.Builder
+
+	for _, key := range keys {
+		sb.WriteString(fmt.Sprintf(
+			"properties has { key='%s' and value='%s' } and ",
+			escapeQuery(key),
+			escapeQuery(tags[key]),
+		))
+	}
+
+	sb.WriteString("trashed=false")
+
+	return m.find(ctx, sb.String())
+}
+
+func (m HTTPClient) FindByName(ctx context.Context, folderID, name string) ([]*File, error) {
+	q := fmt.Sprintf(
+		"name='%s' and '%s' in parents and mimeType!='%s' and trashed=false",
+		escapeQuery(name),
+		escapeQuery(folderID),
+		MimeTypeFolder,
+	)
+
+	return m.find(ctx, q)
+}
+
+func (m HTTPClient) find(ctx context.Context, q string) ([]*File, error) {
+	var (
+		next      = true
+		pageToken string
+		list      []*File
+	)
+
+	for next {
+		err := m.rateLimiter.Wait(ctx)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		var r *drive.FileList
+
+		err = m.do(ctx, func(ctx context.Context) (err error) {
+			r, err = m.ds.Files.
+				List().
+				SupportsAllDrives(true).
+				IncludeItemsFromAllDrives(true).
+				Context(ctx).
+				Fields("nextPageToken, files(id, name, description, properties, md5Checksum, parents, createdTime, modifiedTime)").
+				PageSize(DefaultPageSize).
+				OrderBy(OrderDirection).
+				PageToken(pageToken).
+				Q(q).
+				Do()
+
+			return err
+		})
+		if err != nil {
+			return nil, NewErrGDrive(err)
+		}
+
+		for _, f := range r.Files {
+			file, err := newFileFromOrigin(f)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+
+			list = append(list, file)
+		}
+
+		pageToken = r.NextPageToken
+		if pageToken == "" {
+			next = false
+		}
+	}
+
+	return list, nil
+}
+
 func (m HTTPClient) Latest(ctx context.Context, folderID string) (*File, error) {
-	files, err := m.FilesFromFolder(ctx, folderID, false)
+	files, err := m.FilesFromFolder(ctx, folderID, true)
 	if err != nil {
-		return nil, NewErrGDrive(err)
+		return nil, errors.WithStack(err)
+	}
+
+	//every subfolder is listed on its own, so the files are only ordered within their folder
+	var latest *File
+
+	for _, f := range files {
+		if f.UploadedAt == nil {
+			continue
+		}
+
+		if latest == nil || f.UploadedAt.After(*latest.UploadedAt) {
+			latest = f
+		}
 	}
 
-	if len(files) == 0 {
+	return latest, nil
+}
+
+// readMedia buffers the content, so it can be uploaded again by a retried request
+func readMedia(r io.Reader) ([]byte, error) {
+	if r == nil {
 		return nil, nil
 	}
 
-	return files[0], err
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return data, nil
+}
+
+// escapeQuery escapes a value used inside a quoted string of the files query
+func escapeQuery(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v)
 }
 
 func stringInSlice(ID string, IDList []string) bool {
@@ -0,0 +1,63 @@
+package drive
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"chess-archive/pkg/retry"
+
+	"golang.org/x/time/rate"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+)
+
+func TestHTTPClientCreateRetriesWithTheGeneratedID(t *testing.T) {
+	var uploads []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/generateIds") {
+			_, _ = fmt.Fprint(w, `{"ids": ["generated"]}`)
+
+			return
+		}
+
+		body := new(strings.Builder)
+		_ = r.Write(body)
+		uploads = append(uploads, body.String())
+
+		//the first upload is committed but its response is lost, the retry finds the file created
+		if len(uploads) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusConflict)
+		}
+
+		_, _ = fmt.Fprint(w, `{"error": {"code": 0, "message": "x"}}`)
+	}))
+	defer srv.Close()
+
+	ds, err := drive.NewService(context.Background(), option.WithEndpoint(srv.URL+"/"), option.WithHTTPClient(srv.Client()))
+	if err != nil {
+		t.Fatalf("NewService() error = %v", err)
+	}
+
+	m := HTTPClient{ds: ds, rateLimiter: rate.NewLimiter(rate.Inf, 1), retry: retry.Policy{MaxAttempts: 3}}
+
+	id, err := m.Create(context.Background(), "folder", &File{Name: "game.pgn", Media: strings.NewReader("1. e4 *")})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if id != "generated" {
+		t.Errorf("Create() = %s, want the generated ID", id)
+	}
+
+	if len(uploads) != 2 || !strings.Contains(uploads[0], `"id":"generated"`) || !strings.Contains(uploads[1], `"id":"generated"`) {
+		t.Errorf("uploads = %d, want 2 with the generated ID", len(uploads))
+	}
+}
@@ -14,6 +14,8 @@ type File struct {
 	Description string
 	Tags        map[string]string
 	Media       io.Reader
+	Checksum    string //md5 of the content
+	Parents     []string
 
 	UploadedAt *time.Time
 	ModifiedAt *time.Time
@@ -27,8 +29,12 @@ func newFileFromOrigin(file *drive.File) (*File, error) {
 	}
 
 	f := &File{
-		ID:   file.Id,
-		Name: file.Name,
+		ID:          file.Id,
+		Name:        file.Name,
+		Description: file.Description,
+		Tags:        file.Properties,
+		Checksum:    file.Md5Checksum,
+		Parents:     file.Parents,
 	}
 
 	if file.CreatedTime != "" {
@@ -65,5 +71,22 @@ func (f File) CreatedAt() *time.Time {
 }
 
 func (f *File) AddTag(key, value string) {
+	if f.Tags == nil {
+		f.Tags = map[string]string{}
+	}
+
 	f.Tags[key] = value
 }
+
+func (f File) Tag(key string) string {
+	return f.Tags[key]
+}
+
+// Close closes the content of the opened file
+func (f *File) Close() error {
+	if c, ok := f.Media.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package pgn
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ResultWhiteWins  = "1-0"
+	ResultBlackWins  = "0-1"
+	ResultDraw       = "1/2-1/2"
+	ResultUnfinished = "*"
+)
+
+// Standard NAGs of the move suffix annotations
+const (
+	NAGGood        = 1 // !
+	NAGMistake     = 2 // ?
+	NAGBrilliant   = 3 // !!
+	NAGBlunder     = 4 // ??
+	NAGInteresting = 5 // !?
+	NAGDubious     = 6 // ?!
+)
+
+var suffixNAGs = map[string]int{
+	"!":  NAGGood,
+	"?":  NAGMistake,
+	"!!": NAGBrilliant,
+	"??": NAGBlunder,
+	"!?": NAGInteresting,
+	"?!": NAGDubious,
+}
+
+var commandRegexp = regexp.MustCompile(`\[%(\w+)\s+([^\]]*)\]`)
+
+type Tag struct {
+	Name  string
+	Value string
+}
+
+type Game struct {
+	Tags []Tag
+	//Comment is the comment placed before the first move
+	Comment string
+	//Moves is the main line
+	Moves  []*Move
+	Result string
+}
+
+// Tag returns the value of the tag pair, empty if the game has no such tag
+func (g *Game) Tag(name string) string {
+	for _, t := range g.Tags {
+		if t.Name == name {
+			return t.Value
+		}
+	}
+
+	return ""
+}
+
+// Headers returns the tag pairs as a map
+func (g *Game) Headers() map[string]string {
+	headers := make(map[string]string, len(g.Tags))
+
+	for _, t := range g.Tags {
+		headers[t.Name] = t.Value
+	}
+
+	return headers
+}
+
+type Move struct {
+	//Ply is the half-move number, 1 is the first move of white
+	Ply int
+	SAN string
+	//NAGs are the numeric annotation glyphs, including the ones of the suffix annotations (!, ?, ...)
+	NAGs []int
+	//Comments are the comments following the move with the embedded commands removed
+	Comments []string
+	//Commands are the embedded commands like [%clk 0:03:00], keyed by name
+	Commands map[string]string
+	//Clock is the remaining time on the clock of the player after the move (%clk)
+	Clock *time.Duration
+	//Eval is the engine evaluation after the move (%eval)
+	Eval *Eval
+	//Variations are the alternatives to this move
+	Variations [][]*Move
+}
+
+// Eval is an engine evaluation from the white point of view
+type Eval struct {
+	Centipawns int
+	//Mate is the number of moves to mate, negative when black mates, zero when there is no forced mate
+	Mate int
+}
+
+// MoveNumber returns the full move number of the move
+func (m *Move) MoveNumber() int {
+	return (m.Ply + 1) / 2
+}
+
+// White reports whether the move was made by white
+func (m *Move) White() bool {
+	return m.Ply%2 == 1
+}
+
+func (m *Move) addComment(comment string) {
+	for _, c := range commandRegexp.FindAllStringSubmatch(comment, -1) {
+		if m.Commands == nil {
+			m.Commands = map[string]string{}
+		}
+
+		name, value := c[1], strings.TrimSpace(c[2])
+		m.Commands[name] = value
+
+		switch name {
+		case "clk":
+			if d, ok := parseClock(value); ok {
+				m.Clock = &d
+			}
+		case "eval":
+			if e, ok := parseEval(value); ok {
+				m.Eval = e
+			}
+		}
+	}
+
+	text := strings.TrimSpace(commandRegexp.ReplaceAllString(comment, ""))
+	if text != "" {
+		m.Comments = append(m.Comments, strings.Join(strings.Fields(text), " "))
+	}
+}
+
+// parseClock parses h:mm:ss with optional fractions of a second
+func parseClock(v string) (time.Duration, bool) {
+	parts := strings.Split(v, ":")
+	if len(parts) != 3 {
+		return 0, false
+	}
+
+	h, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, false
+	}
+
+	m, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, false
+	}
+
+	s, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s*float64(time.Second)), true
+}
+
+// parseEval parses pawns like 0.17 or -1.5 and mates like #3 or #-2, a trailing depth (",20") is ignored
+func parseEval(v string) (*Eval, bool) {
+	v = strings.SplitN(v, ",", 2)[0]
+
+	if strings.HasPrefix(v, "#") {
+		mate, err := strconv.Atoi(v[1:])
+		if err != nil {
+			return nil, false
+		}
+
+		return &Eval{Mate: mate}, true
+	}
+
+	pawns, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return nil, false
+	}
+
+	if pawns < 0 {
+		return &Eval{Centipawns: int(pawns*100 - 0.5)}, true
+	}
+
+	return &Eval{Centipawns: int(pawns*100 + 0.5)}, true
+}
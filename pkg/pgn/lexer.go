@@ -0,0 +1,199 @@
+package pgn
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type tokenType int
+
+const (
+	tokenEOF tokenType = iota
+	tokenTagOpen
+	tokenTagClose
+	tokenString
+	tokenSymbol
+	tokenPeriod
+	tokenComment
+	tokenNAG
+	tokenSuffix
+	tokenVariationOpen
+	tokenVariationClose
+	tokenAsterisk
+)
+
+type token struct {
+	typ   tokenType
+	value string
+	line  int
+}
+
+type lexer struct {
+	input string
+	pos   int
+	line  int
+}
+
+func newLexer(input string) *lexer {
+	return &lexer{input: input, line: 1}
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipSpaceAndEscapes()
+
+	if l.pos >= len(l.input) {
+		return token{typ: tokenEOF, line: l.line}, nil
+	}
+
+	c := l.input[l.pos]
+	start := l.line
+
+	switch {
+	case c == '[':
+		l.pos++
+
+		return token{typ: tokenTagOpen, line: start}, nil
+	case c == ']':
+		l.pos++
+
+		return token{typ: tokenTagClose, line: start}, nil
+	case c == '(':
+		l.pos++
+
+		return token{typ: tokenVariationOpen, line: start}, nil
+	case c == ')':
+		l.pos++
+
+		return token{typ: tokenVariationClose, line: start}, nil
+	case c == '*':
+		l.pos++
+
+		return token{typ: tokenAsterisk, value: "*", line: start}, nil
+	case c == '.':
+		begin := l.pos
+		for l.pos < len(l.input) && l.input[l.pos] == '.' {
+			l.pos++
+		}
+
+		return token{typ: tokenPeriod, value: l.input[begin:l.pos], line: start}, nil
+	case c == '"':
+		return l.string()
+	case c == '{':
+		end := strings.IndexByte(l.input[l.pos:], '}')
+		if end < 0 {
+			return token{}, errors.Errorf("line %d: unterminated comment", start)
+		}
+
+		value := l.input[l.pos+1 : l.pos+end]
+		l.line += strings.Count(value, "\n")
+		l.pos += end + 1
+
+		return token{typ: tokenComment, value: value, line: start}, nil
+	case c == ';':
+		end := strings.IndexByte(l.input[l.pos:], '\n')
+		if end < 0 {
+			end = len(l.input) - l.pos
+		}
+
+		value := l.input[l.pos+1 : l.pos+end]
+		l.pos += end
+
+		return token{typ: tokenComment, value: value, line: start}, nil
+	case c == '$':
+		l.pos++
+		begin := l.pos
+
+		for l.pos < len(l.input) && isDigit(l.input[l.pos]) {
+			l.pos++
+		}
+
+		if begin == l.pos {
+			return token{}, errors.Errorf("line %d: NAG without a number", start)
+		}
+
+		return token{typ: tokenNAG, value: l.input[begin:l.pos], line: start}, nil
+	case c == '!' || c == '?':
+		begin := l.pos
+		for l.pos < len(l.input) && (l.input[l.pos] == '!' || l.input[l.pos] == '?') {
+			l.pos++
+		}
+
+		return token{typ: tokenSuffix, value: l.input[begin:l.pos], line: start}, nil
+	case isSymbolStart(c):
+		begin := l.pos
+		for l.pos < len(l.input) && isSymbolContinuation(l.input[l.pos]) {
+			l.pos++
+		}
+
+		return token{typ: tokenSymbol, value: l.input[begin:l.pos], line: start}, nil
+	default:
+		return token{}, errors.Errorf("line %d: unexpected character %q", start, c)
+	}
+}
+
+// skipSpaceAndEscapes skips the white space and the lines escaped with % in the first column
+func (l *lexer) skipSpaceAndEscapes() {
+	for l.pos < len(l.input) {
+		c := l.input[l.pos]
+
+		switch {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\v' || c == '\f':
+			l.pos++
+		case c == '%' && (l.pos == 0 || l.input[l.pos-1] == '\n'):
+			end := strings.IndexByte(l.input[l.pos:], '\n')
+			if end < 0 {
+				l.pos = len(l.input)
+			} else {
+				l.pos += end
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) string() (token, error) {
+	var sb strings.Builder
+
+	start := l.line
+	l.pos++
+
+	for l.pos < len(l.input) {
+		c := l.input[l.pos]
+
+		switch c {
+		case '"':
+			l.pos++
+
+			return token{typ: tokenString, value: sb.String(), line: start}, nil
+		case '\\':
+			if l.pos+1 < len(l.input) {
+				l.pos++
+				c = l.input[l.pos]
+			}
+		case '\n':
+			l.line++
+		}
+
+		sb.WriteByte(c)
+		l.pos++
+	}
+
+	return token{}, errors.Errorf("line %d: unterminated string", start)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isSymbolStart(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isSymbolContinuation(c byte) bool {
+	return isSymbolStart(c) || strings.IndexByte("_+#=:-/", c) >= 0
+}
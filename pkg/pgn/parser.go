@@ -0,0 +1,309 @@
+package pgn
+
+import (
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type parser struct {
+	lex    *lexer
+	peeked *token
+}
+
+// Parse reads all games of the PGN database
+func Parse(r io.Reader) ([]*Game, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	p := &parser{lex: newLexer(string(data))}
+
+	var games []*Game
+
+	for {
+		tok, err := p.peek()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if tok.typ == tokenEOF {
+			return games, nil
+		}
+
+		g, err := p.game()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		games = append(games, g)
+	}
+}
+
+// ParseGame parses a PGN holding exactly one game
+func ParseGame(s string) (*Game, error) {
+	games, err := Parse(strings.NewReader(s))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if len(games) != 1 {
+		return nil, errors.Errorf("expected one game, found %d", len(games))
+	}
+
+	return games[0], nil
+}
+
+func (p *parser) peek() (token, error) {
+	if p.peeked == nil {
+		tok, err := p.lex.next()
+		if err != nil {
+			return token{}, err
+		}
+
+		p.peeked = &tok
+	}
+
+	return *p.peeked, nil
+}
+
+func (p *parser) next() (token, error) {
+	tok, err := p.peek()
+	p.peeked = nil
+
+	return tok, err
+}
+
+func (p *parser) expect(typ tokenType, what string) (token, error) {
+	tok, err := p.next()
+	if err != nil {
+		return token{}, err
+	}
+
+	if tok.typ != typ {
+		return token{}, errors.Errorf("line %d: expected %s, found %q", tok.line, what, tok.value)
+	}
+
+	return tok, nil
+}
+
+func (p *parser) game() (*Game, error) {
+	g := &Game{}
+
+	err := p.tags(g)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	moves, err := p.line(g, nil, 1, false)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	g.Moves = moves
+
+	if g.Result == "" {
+		g.Result = g.Tag("Result")
+	}
+
+	return g, nil
+}
+
+func (p *parser) tags(g *Game) error {
+	for {
+		tok, err := p.peek()
+		if err != nil {
+			return err
+		}
+
+		if tok.typ != tokenTagOpen {
+			return nil
+		}
+
+		_, _ = p.next()
+
+		name, err := p.expect(tokenSymbol, "tag name")
+		if err != nil {
+			return err
+		}
+
+		value, err := p.expect(tokenString, "tag value")
+		if err != nil {
+			return err
+		}
+
+		_, err = p.expect(tokenTagClose, "]")
+		if err != nil {
+			return err
+		}
+
+		g.Tags = append(g.Tags, Tag{Name: name.value, Value: value.value})
+	}
+}
+
+// line parses the moves until the game result or, inside a variation, until the closing parenthesis.
+// prev is the move preceding the line, the comments found before the first move are attached to it.
+func (p *parser) line(g *Game, prev *Move, ply int, variation bool) ([]*Move, error) {
+	var (
+		moves []*Move
+		last  = prev
+	)
+
+	for {
+		tok, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+
+		switch tok.typ {
+		case tokenEOF, tokenTagOpen:
+			if variation {
+				return nil, errors.Errorf("line %d: unterminated variation", tok.line)
+			}
+
+			return moves, nil
+		case tokenVariationClose:
+			if !variation {
+				return nil, errors.Errorf("line %d: unexpected )", tok.line)
+			}
+
+			_, _ = p.next()
+
+			return moves, nil
+		case tokenAsterisk:
+			_, _ = p.next()
+
+			if !variation {
+				g.Result = ResultUnfinished
+
+				return moves, nil
+			}
+		case tokenComment:
+			_, _ = p.next()
+
+			switch {
+			case last != nil:
+				last.addComment(tok.value)
+			case !variation:
+				g.Comment = strings.TrimSpace(strings.Join([]string{g.Comment, tok.value}, " "))
+			}
+		case tokenNAG:
+			_, _ = p.next()
+
+			if last == nil {
+				return nil, errors.Errorf("line %d: NAG before the first move", tok.line)
+			}
+
+			nag, _ := strconv.Atoi(tok.value)
+			last.NAGs = append(last.NAGs, nag)
+		case tokenSuffix:
+			_, _ = p.next()
+
+			if last == nil {
+				return nil, errors.Errorf("line %d: annotation before the first move", tok.line)
+			}
+
+			nag, ok := suffixNAGs[tok.value]
+			if !ok {
+				return nil, errors.Errorf("line %d: unknown annotation %s", tok.line, tok.value)
+			}
+
+			last.NAGs = append(last.NAGs, nag)
+		case tokenVariationOpen:
+			_, _ = p.next()
+
+			if len(moves) == 0 {
+				return nil, errors.Errorf("line %d: variation before the first move", tok.line)
+			}
+
+			alt := moves[len(moves)-1]
+
+			variationMoves, err := p.line(g, nil, alt.Ply, true)
+			if err != nil {
+				return nil, err
+			}
+
+			alt.Variations = append(alt.Variations, variationMoves)
+		case tokenPeriod:
+			_, _ = p.next()
+		case tokenSymbol:
+			_, _ = p.next()
+
+			switch {
+			case isResult(tok.value):
+				if variation {
+					return nil, errors.Errorf("line %d: result inside a variation", tok.line)
+				}
+
+				g.Result = tok.value
+
+				return moves, nil
+			case isMoveNumber(tok.value):
+				ply, err = p.moveNumber(tok)
+				if err != nil {
+					return nil, err
+				}
+			default:
+				m := &Move{Ply: ply, SAN: normalizeSAN(tok.value)}
+				moves = append(moves, m)
+				last = m
+				ply++
+			}
+		default:
+			return nil, errors.Errorf("line %d: unexpected token %q", tok.line, tok.value)
+		}
+	}
+}
+
+// moveNumber returns the ply indicated by the move number and the periods following it ("12." or "12...")
+func (p *parser) moveNumber(tok token) (int, error) {
+	n, err := strconv.Atoi(tok.value)
+	if err != nil {
+		return 0, errors.Errorf("line %d: bad move number %s", tok.line, tok.value)
+	}
+
+	next, err := p.peek()
+	if err != nil {
+		return 0, err
+	}
+
+	if next.typ == tokenPeriod {
+		_, _ = p.next()
+
+		if len(next.value) >= 3 {
+			return 2 * n, nil
+		}
+	}
+
+	return 2*n - 1, nil
+}
+
+func isResult(v string) bool {
+	return v == ResultWhiteWins || v == ResultBlackWins || v == ResultDraw
+}
+
+func isMoveNumber(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if !isDigit(v[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// normalizeSAN rewrites the castling written with zeros
+func normalizeSAN(san string) string {
+	switch {
+	case strings.HasPrefix(san, "0-0-0"):
+		return "O-O-O" + san[5:]
+	case strings.HasPrefix(san, "0-0"):
+		return "O-O" + san[3:]
+	default:
+		return san
+	}
+}
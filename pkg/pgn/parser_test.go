@@ -0,0 +1,99 @@
+package pgn
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const annotatedGames = `[Event "Rated Blitz game"]
+[Site "https://lichess.org/abcd1234"]
+[White "a \"q\""]
+[Result "1-0"]
+
+{ opening comment } 1. e4 { [%eval 0.17] [%clk 0:03:00] } 1... e5!? $14 { [%clk 0:02:58] nice } 2. Nf3 ( 2. f4 exf4 ( 2... d5 ) 3. Nf3 ) 2... Nc6?? 3. 0-0-0+ ; rest
+3... Nd4 4. Qxf7# { [%eval #-3,20] } 1-0
+
+[Event "second"]
+
+1. d4 *
+`
+
+func TestParse(t *testing.T) {
+	games, err := Parse(strings.NewReader(annotatedGames))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if len(games) != 2 {
+		t.Fatalf("Parse() returned %d games, want 2", len(games))
+	}
+
+	g := games[0]
+
+	if g.Tag("White") != `a "q"` || g.Tag("Site") != "https://lichess.org/abcd1234" || g.Tag("Round") != "" {
+		t.Errorf("Tags = %+v", g.Tags)
+	}
+
+	if g.Comment != "opening comment" || g.Result != "1-0" {
+		t.Errorf("Comment = %q, Result = %q", g.Comment, g.Result)
+	}
+
+	var sans []string
+
+	for _, m := range g.Moves {
+		sans = append(sans, m.SAN)
+	}
+
+	if want := []string{"e4", "e5", "Nf3", "Nc6", "O-O-O+", "Nd4", "Qxf7#"}; !reflect.DeepEqual(sans, want) {
+		t.Fatalf("main line = %v, want %v", sans, want)
+	}
+
+	e4, e5, nf3, nc6, castling, mate := g.Moves[0], g.Moves[1], g.Moves[2], g.Moves[3], g.Moves[4], g.Moves[6]
+
+	if e4.Clock == nil || *e4.Clock != 3*time.Minute || e4.Eval == nil || *e4.Eval != (Eval{Centipawns: 17}) || len(e4.Comments) != 0 {
+		t.Errorf("1. e4 = %+v", e4)
+	}
+
+	if !reflect.DeepEqual(e5.NAGs, []int{5, 14}) || !reflect.DeepEqual(e5.Comments, []string{"nice"}) || e5.White() || e5.MoveNumber() != 1 {
+		t.Errorf("1... e5 = %+v", e5)
+	}
+
+	if len(nf3.Variations) != 1 || len(nf3.Variations[0]) != 3 || nf3.Variations[0][0].SAN != "f4" {
+		t.Fatalf("variations of 2. Nf3 = %+v", nf3.Variations)
+	}
+
+	if exf4 := nf3.Variations[0][1]; exf4.Ply != 4 || len(exf4.Variations) != 1 || exf4.Variations[0][0].SAN != "d5" {
+		t.Errorf("nested variation of 2... exf4 = %+v", exf4)
+	}
+
+	if !reflect.DeepEqual(nc6.NAGs, []int{4}) {
+		t.Errorf("NAGs of 2... Nc6 = %v, want [4]", nc6.NAGs)
+	}
+
+	if castling.Ply != 5 || !reflect.DeepEqual(castling.Comments, []string{"rest"}) {
+		t.Errorf("3. O-O-O+ = %+v", castling)
+	}
+
+	if mate.Eval == nil || mate.Eval.Mate != -3 || mate.MoveNumber() != 4 {
+		t.Errorf("4. Qxf7# = %+v", mate)
+	}
+
+	if games[1].Result != "*" || len(games[1].Moves) != 1 || games[1].Moves[0].SAN != "d4" {
+		t.Errorf("second game = %+v", games[1])
+	}
+}
+
+func TestParseGameErrors(t *testing.T) {
+	for _, s := range []string{
+		`[Event "unterminated]`,
+		"1. e4 ( 1. d4",
+		"1. e4 { open",
+	} {
+		_, err := ParseGame(s)
+		if err == nil {
+			t.Errorf("ParseGame(%q) error = nil, want an error", s)
+		}
+	}
+}
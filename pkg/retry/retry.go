@@ -0,0 +1,103 @@
+package retry
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	randMu sync.Mutex
+	random = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// Policy retries the operations failing with a retryable error, the delay between the attempts grows
+// exponentially and is fully jittered. The zero value makes a single attempt.
+type Policy struct {
+	MaxAttempts  int           //including the first one
+	InitialDelay time.Duration //upper bound of the delay before the second attempt
+	MaxDelay     time.Duration //upper bound of any delay
+	Multiplier   float64
+	Deadline     time.Duration //no attempt starts later than the deadline after the first one, 0 means no deadline
+
+	//Retryable classifies the errors, IsRetryable is used when it is nil
+	Retryable func(err error) bool
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts:  5,
+		InitialDelay: 500 * time.Millisecond,
+		MaxDelay:     30 * time.Second,
+		Multiplier:   2,
+		Deadline:     2 * time.Minute,
+	}
+}
+
+// Do calls fn until it succeeds, fails with a permanent error, runs out of attempts or of time
+func (p Policy) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+
+	var deadline time.Time
+	if p.Deadline > 0 {
+		deadline = time.Now().Add(p.Deadline)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= p.MaxAttempts || !retryable(err) || ctx.Err() != nil {
+			return wrap(err, attempt)
+		}
+
+		delay := p.delay(attempt)
+		if !deadline.IsZero() && time.Now().Add(delay).After(deadline) {
+			return wrap(err, attempt)
+		}
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+
+			return wrap(err, attempt)
+		}
+	}
+}
+
+// delay returns a random duration up to the exponential backoff of the attempt
+func (p Policy) delay(attempt int) time.Duration {
+	backoff := float64(p.InitialDelay) * math.Pow(p.Multiplier, float64(attempt-1))
+	if p.MaxDelay > 0 && backoff > float64(p.MaxDelay) {
+		backoff = float64(p.MaxDelay)
+	}
+
+	if backoff < 1 {
+		return 0
+	}
+
+	randMu.Lock()
+	defer randMu.Unlock()
+
+	return time.Duration(random.Int63n(int64(backoff)))
+}
+
+func wrap(err error, attempts int) error {
+	if attempts == 1 {
+		return err
+	}
+
+	return errors.Wrapf(err, "gave up after %d attempts", attempts)
+}
@@ -0,0 +1,92 @@
+package retry
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func testPolicy() Policy {
+	return Policy{MaxAttempts: 4, InitialDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, Multiplier: 2, Deadline: time.Second}
+}
+
+func TestPolicyDo(t *testing.T) {
+	tests := []struct {
+		name     string
+		errs     []error //returned by the successive attempts, nil afterwards
+		attempts int
+		failed   bool
+	}{
+		{"success", nil, 1, false},
+		{"recovered", []error{status.Error(codes.Unavailable, "x"), status.Error(codes.Unavailable, "x")}, 3, false},
+		{"exhausted", []error{&googleapi.Error{Code: 503}, &googleapi.Error{Code: 503}, &googleapi.Error{Code: 503}, &googleapi.Error{Code: 503}}, 4, true},
+		{"permanent", []error{errors.WithStack(&googleapi.Error{Code: http.StatusNotFound})}, 1, true},
+	}
+
+	for _, tt := range tests {
+		var attempts int
+
+		err := testPolicy().Do(context.Background(), func(context.Context) error {
+			attempts++
+
+			if attempts <= len(tt.errs) {
+				return tt.errs[attempts-1]
+			}
+
+			return nil
+		})
+
+		if attempts != tt.attempts || (err != nil) != tt.failed {
+			t.Errorf("%s: Do() made %d attempts, error = %v, want %d attempts", tt.name, attempts, err, tt.attempts)
+		}
+	}
+}
+
+func TestPolicyDoStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var attempts int
+
+	err := testPolicy().Do(ctx, func(context.Context) error {
+		attempts++
+		cancel()
+
+		return status.Error(codes.Unavailable, "x")
+	})
+
+	if attempts != 1 || err == nil {
+		t.Errorf("Do() made %d attempts, error = %v, want a single failed attempt", attempts, err)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("x"), false},
+		{context.Canceled, false},
+		{errors.WithStack(context.DeadlineExceeded), false},
+		{&googleapi.Error{Code: http.StatusTooManyRequests}, true},
+		{errors.Wrap(&googleapi.Error{Code: http.StatusBadGateway}, "x"), true},
+		{&googleapi.Error{Code: http.StatusForbidden}, false},
+		{&googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "userRateLimitExceeded"}}}, true},
+		{status.Error(codes.ResourceExhausted, "x"), true},
+		{errors.WithStack(status.Error(codes.InvalidArgument, "x")), false},
+		{io.ErrUnexpectedEOF, true},
+	}
+
+	for _, tt := range tests {
+		if got := IsRetryable(tt.err); got != tt.want {
+			t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
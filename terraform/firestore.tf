@@ -0,0 +1,41 @@
+# The games of a user are queried by source and user ordered by the played time, the newest first by the archiver
+# and the oldest first by verify, export and stats. Firestore needs a composite index for each direction.
+resource "google_firestore_index" "games_by_user_desc" {
+  project    = var.project
+  collection = "games"
+
+  fields {
+    field_path = "source"
+    order      = "ASCENDING"
+  }
+
+  fields {
+    field_path = "user_id"
+    order      = "ASCENDING"
+  }
+
+  fields {
+    field_path = "played_at"
+    order      = "DESCENDING"
+  }
+}
+
+resource "google_firestore_index" "games_by_user_asc" {
+  project    = var.project
+  collection = "games"
+
+  fields {
+    field_path = "source"
+    order      = "ASCENDING"
+  }
+
+  fields {
+    field_path = "user_id"
+    order      = "ASCENDING"
+  }
+
+  fields {
+    field_path = "played_at"
+    order      = "ASCENDING"
+  }
+}